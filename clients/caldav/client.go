@@ -0,0 +1,295 @@
+package caldav
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const maxRedirects = 5
+
+// Client talks to a CalDAV server on behalf of a single user.
+type Client struct {
+	http     *http.Client
+	endpoint *url.URL
+	username string
+	password string
+}
+
+// Error is returned when the server answers with an unexpected status.
+type Error struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Status     string
+	Header     http.Header
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("caldav: %s %s: %s", e.Method, e.URL, e.Status)
+}
+
+// HTTPStatus returns the status code of the response.
+func (e *Error) HTTPStatus() int {
+	return e.StatusCode
+}
+
+// HTTPHeader returns the headers of the response.
+func (e *Error) HTTPHeader() http.Header {
+	return e.Header
+}
+
+// IsNotFound reports whether err is caused by a missing resource.
+func IsNotFound(err error) bool {
+	caldavErr, ok := errors.Cause(err).(*Error)
+	return ok && caldavErr.StatusCode == http.StatusNotFound
+}
+
+// IsPreconditionFailed reports whether err is caused by a resource that
+// already existed when it was created, or was modified since it was read.
+func IsPreconditionFailed(err error) bool {
+	caldavErr, ok := errors.Cause(err).(*Error)
+	return ok && caldavErr.StatusCode == http.StatusPreconditionFailed
+}
+
+// New creates a client for the server at endpoint, which is either the
+// server root or any URL of the user on the server.
+func New(httpClient *http.Client, endpoint, username, password string) (*Client, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid caldav url")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.Errorf("invalid caldav url: %s", endpoint)
+	}
+
+	// redirects are followed by do, so that the method and body are kept
+	client := *httpClient
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	return &Client{
+		http:     &client,
+		endpoint: u,
+		username: username,
+		password: password,
+	}, nil
+}
+
+// resolve makes an href returned by the server absolute.
+func (c *Client) resolve(href string) (string, error) {
+	u, err := c.endpoint.Parse(href)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid href %s", href)
+	}
+	return u.String(), nil
+}
+
+// path returns the path of an absolute URL, which is how hrefs are compared.
+func (c *Client) path(href string) string {
+	u, err := c.endpoint.Parse(href)
+	if err != nil {
+		return href
+	}
+	return u.EscapedPath()
+}
+
+func (c *Client) do(ctx context.Context, method, href string, header http.Header, body []byte) (*http.Response, error) {
+	target, err := c.resolve(href)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; ; i++ {
+		req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid caldav request")
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		if c.username != "" || c.password != "" {
+			req.SetBasicAuth(c.username, c.password)
+		}
+
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return nil, errors.Wrapf(err, "caldav %s %s failed", method, target)
+		}
+
+		location := resp.Header.Get("Location")
+		if !isRedirect(resp.StatusCode) || location == "" || i >= maxRedirects {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		next, err := req.URL.Parse(location)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid redirect from %s", target)
+		}
+		// the credentials are only sent to the server they were given for
+		if next.Scheme != req.URL.Scheme || next.Host != req.URL.Host {
+			return nil, errors.Errorf("caldav %s %s redirected to another server: %s", method, target, next.Redacted())
+		}
+		if resp.StatusCode == http.StatusSeeOther && method != http.MethodHead {
+			method, body = http.MethodGet, nil
+			header = header.Clone()
+			header.Del("Content-Type")
+			header.Del("Depth")
+		}
+		target = next.String()
+	}
+}
+
+func isRedirect(code int) bool {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// xmlRequest sends a PROPFIND or REPORT request and decodes the multistatus
+// response. The Depth header is omitted when depth is empty.
+func (c *Client) xmlRequest(ctx context.Context, method, href, depth, body string) (*multistatus, error) {
+	header := http.Header{}
+	header.Set("Content-Type", "application/xml; charset=utf-8")
+	if depth != "" {
+		header.Set("Depth", depth)
+	}
+
+	resp, err := c.do(ctx, method, href, header, []byte(xml.Header+body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, responseError(resp)
+	}
+
+	var result multistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, errors.Wrapf(err, "invalid response to caldav %s %s", method, resp.Request.URL)
+	}
+	return &result, nil
+}
+
+// Object is a calendar object resource.
+type Object struct {
+	Href string
+	ETag string
+	Data string
+}
+
+// Get downloads a calendar object.
+func (c *Client) Get(ctx context.Context, href string) (Object, error) {
+	resp, err := c.do(ctx, http.MethodGet, href, http.Header{"Accept": {"text/calendar"}}, nil)
+	if err != nil {
+		return Object{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Object{}, responseError(resp)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return Object{}, errors.Wrap(err, "failed to read calendar object")
+	}
+	return Object{
+		Href: c.path(href),
+		ETag: resp.Header.Get("ETag"),
+		Data: string(data),
+	}, nil
+}
+
+// Put stores a calendar object. A new object is created when etag is empty,
+// otherwise the object is only replaced if it was not modified since it was
+// read. The new ETag is returned when the server provides it.
+func (c *Client) Put(ctx context.Context, href string, data []byte, etag string) (string, error) {
+	header := http.Header{}
+	header.Set("Content-Type", "text/calendar; charset=utf-8")
+	if etag == "" {
+		header.Set("If-None-Match", "*")
+	} else {
+		header.Set("If-Match", etag)
+	}
+
+	resp, err := c.do(ctx, http.MethodPut, href, header, data)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return "", responseError(resp)
+	}
+	return resp.Header.Get("ETag"), nil
+}
+
+// Delete removes a calendar object. The object is only removed if it was
+// not modified since it was read, unless etag is empty.
+func (c *Client) Delete(ctx context.Context, href, etag string) error {
+	header := http.Header{}
+	if etag != "" {
+		header.Set("If-Match", etag)
+	}
+
+	resp, err := c.do(ctx, http.MethodDelete, href, header, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	return nil
+}
+
+func responseError(resp *http.Response) error {
+	io.Copy(ioutil.Discard, resp.Body)
+	return &Error{
+		Method:     resp.Request.Method,
+		URL:        resp.Request.URL.String(),
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Header:     resp.Header,
+	}
+}
+
+// statusOK reports whether a multistatus status line is successful.
+func statusOK(status string) bool {
+	if status == "" {
+		return true
+	}
+	fields := strings.Fields(status)
+	return len(fields) >= 2 && strings.HasPrefix(fields[1], "2")
+}
+
+// statusNotFound reports whether a multistatus status line is 404.
+func statusNotFound(status string) bool {
+	fields := strings.Fields(status)
+	return len(fields) >= 2 && fields[1] == "404"
+}
+
+// escape encodes a string for use in an XML request body.
+func escape(value string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(value))
+	return b.String()
+}
@@ -0,0 +1,310 @@
+package caldav
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const (
+	testUser     = "user"
+	testPassword = "secret"
+)
+
+// fakeServer is a CalDAV server with a single calendar object. Requests
+// without the test credentials are rejected.
+type fakeServer struct {
+	t       *testing.T
+	object  string
+	etag    string
+	puts    []*http.Request
+	bodies  []string
+	deletes []*http.Request
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if user, password, ok := r.BasicAuth(); !ok || user != testUser || password != testPassword {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+
+	switch {
+	case r.Method == "PROPFIND" && r.URL.Path == "/":
+		w.WriteHeader(http.StatusNotFound)
+	case r.URL.Path == wellKnownPath:
+		http.Redirect(w, r, "/dav/", http.StatusMovedPermanently)
+	case r.Method == "PROPFIND" && r.URL.Path == "/dav/":
+		multistatusResponse(w, `<D:response><D:href>/dav/</D:href><D:propstat><D:prop>
+  <D:current-user-principal><D:href>/principals/user/</D:href></D:current-user-principal>
+</D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>`)
+	case r.Method == "PROPFIND" && r.URL.Path == "/principals/user/":
+		multistatusResponse(w, `<D:response><D:href>/principals/user/</D:href><D:propstat><D:prop>
+  <C:calendar-home-set><D:href>/calendars/user/</D:href></C:calendar-home-set>
+</D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>`)
+	case r.Method == "PROPFIND" && r.URL.Path == "/calendars/user/":
+		if r.Header.Get("Depth") != "1" {
+			f.t.Errorf("calendar home listed with depth %q", r.Header.Get("Depth"))
+		}
+		multistatusResponse(w, `<D:response><D:href>/calendars/user/</D:href><D:propstat><D:prop>
+  <D:resourcetype><D:collection/></D:resourcetype>
+</D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>
+<D:response><D:href>/calendars/user/work/</D:href><D:propstat><D:prop>
+  <D:resourcetype><D:collection/><C:calendar/></D:resourcetype>
+  <D:displayname>Work</D:displayname>
+  <C:supported-calendar-component-set><C:comp name="VEVENT"/></C:supported-calendar-component-set>
+</D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>
+<D:response><D:href>/calendars/user/tasks/</D:href><D:propstat><D:prop>
+  <D:resourcetype><D:collection/><C:calendar/></D:resourcetype>
+  <C:supported-calendar-component-set><C:comp name="VTODO"/></C:supported-calendar-component-set>
+</D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>`)
+	case r.Method == "REPORT" && r.URL.Path == "/calendars/user/work/":
+		if strings.Contains(string(body), "calendar-query") {
+			multistatusResponse(w, `<D:response><D:href>/calendars/user/work/a.ics</D:href><D:propstat><D:prop>
+  <D:getetag>"1"</D:getetag>
+  <C:calendar-data>BEGIN:VCALENDAR&#13;
+END:VCALENDAR&#13;
+</C:calendar-data>
+</D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>`)
+			return
+		}
+		if !strings.Contains(string(body), "sync-collection") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if !strings.Contains(string(body), "<D:sync-token>token-1</D:sync-token>") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		multistatusResponse(w, `<D:response><D:href>/calendars/user/work/a.ics</D:href><D:propstat><D:prop>
+  <D:getetag>"2"</D:getetag>
+</D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>
+<D:response><D:href>/calendars/user/work/b.ics</D:href><D:status>HTTP/1.1 404 Not Found</D:status></D:response>
+<D:sync-token>token-2</D:sync-token>`)
+	case r.Method == http.MethodGet:
+		if r.URL.Path != "/calendars/user/work/series.ics" || f.object == "" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("ETag", f.etag)
+		w.Write([]byte(f.object))
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/calendars/user/work/"):
+		f.puts = append(f.puts, r)
+		f.bodies = append(f.bodies, string(body))
+		if !preconditionOK(r, f.etag) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		f.etag = `"next"`
+		w.Header().Set("ETag", f.etag)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/calendars/user/work/"):
+		f.deletes = append(f.deletes, r)
+		if !preconditionOK(r, f.etag) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// preconditionOK checks the conditional headers against the ETag of the
+// single object of the server.
+func preconditionOK(r *http.Request, etag string) bool {
+	if r.Header.Get("If-None-Match") == "*" {
+		return etag == ""
+	}
+	if match := r.Header.Get("If-Match"); match != "" {
+		return match == etag
+	}
+	return true
+}
+
+func multistatusResponse(w http.ResponseWriter, responses string) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?>
+<D:multistatus xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">` + responses + `</D:multistatus>`))
+}
+
+func newTestClient(t *testing.T, handler http.Handler) (*Client, *httptest.Server) {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client, err := New(server.Client(), server.URL+"/", testUser, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, server
+}
+
+func TestCalendarsDiscovery(t *testing.T) {
+	client, _ := newTestClient(t, &fakeServer{t: t})
+
+	calendars, err := client.Calendars(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calendars) != 1 {
+		t.Fatalf("calendars = %+v, want only the event calendar", calendars)
+	}
+	if calendars[0].Href != "/calendars/user/work/" || calendars[0].Name != "Work" || calendars[0].ReadOnly {
+		t.Errorf("calendar = %+v", calendars[0])
+	}
+}
+
+func TestQuery(t *testing.T) {
+	client, _ := newTestClient(t, &fakeServer{t: t})
+
+	objects, err := client.Query(context.Background(), "/calendars/user/work/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || objects[0].Href != "/calendars/user/work/a.ics" || objects[0].ETag != `"1"` ||
+		objects[0].Data != "BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n" {
+		t.Errorf("objects = %+v", objects)
+	}
+}
+
+func TestSyncCollection(t *testing.T) {
+	client, _ := newTestClient(t, &fakeServer{t: t})
+
+	changes, err := client.SyncCollection(context.Background(), "/calendars/user/work/", "token-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes.Token != "token-2" ||
+		len(changes.Changed) != 1 || changes.Changed[0] != "/calendars/user/work/a.ics" ||
+		len(changes.Removed) != 1 || changes.Removed[0] != "/calendars/user/work/b.ics" {
+		t.Errorf("changes = %+v", changes)
+	}
+
+	_, err = client.SyncCollection(context.Background(), "/calendars/user/work/", "expired")
+	if !IsInvalidSyncToken(err) {
+		t.Errorf("expired token error = %v, want an invalid sync token", err)
+	}
+}
+
+func TestPutDeletePreconditions(t *testing.T) {
+	server := &fakeServer{t: t}
+	client, _ := newTestClient(t, server)
+	ctx := context.Background()
+	href := "/calendars/user/work/a.ics"
+
+	etag, err := client.Put(ctx, href, []byte("BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if etag != `"next"` {
+		t.Errorf("etag = %s", etag)
+	}
+	if got := server.puts[0].Header.Get("If-None-Match"); got != "*" {
+		t.Errorf("create If-None-Match = %q, want *", got)
+	}
+
+	if _, err := client.Put(ctx, href, []byte("BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n"), `"stale"`); err == nil {
+		t.Error("replaced an object modified since it was read")
+	}
+	if got := server.puts[1].Header.Get("If-Match"); got != `"stale"` {
+		t.Errorf("update If-Match = %q", got)
+	}
+
+	if err := client.Delete(ctx, href, `"stale"`); err == nil {
+		t.Error("deleted an object modified since it was read")
+	}
+	if err := client.Delete(ctx, href, etag); err != nil {
+		t.Error(err)
+	}
+	if got := server.deletes[1].Header.Get("If-Match"); got != etag {
+		t.Errorf("delete If-Match = %q, want %s", got, etag)
+	}
+}
+
+func TestDeleteInstanceCancelsIt(t *testing.T) {
+	server := &fakeServer{
+		t:    t,
+		etag: `"1"`,
+		object: "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//EN\r\n" +
+			"BEGIN:VEVENT\r\nUID:series\r\nDTSTAMP:20240101T000000Z\r\n" +
+			"DTSTART:20240101T100000Z\r\nDTEND:20240101T110000Z\r\n" +
+			"RRULE:FREQ=DAILY;COUNT=3\r\nSUMMARY:Daily\r\nEND:VEVENT\r\n" +
+			"BEGIN:VEVENT\r\nUID:series\r\nDTSTAMP:20240101T000000Z\r\n" +
+			"RECURRENCE-ID:20240102T100000Z\r\nDTSTART:20240102T120000Z\r\n" +
+			"DTEND:20240102T130000Z\r\nSUMMARY:Moved\r\nEND:VEVENT\r\n" +
+			"END:VCALENDAR\r\n",
+	}
+	client, _ := newTestClient(t, server)
+	events := client.Events("/calendars/user/work/")
+
+	if err := events.Delete(context.Background(), "series_20240102T100000Z"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(server.puts) != 1 {
+		t.Fatalf("%d objects stored, want 1", len(server.puts))
+	}
+	if got := server.puts[0].Header.Get("If-Match"); got != `"1"` {
+		t.Errorf("If-Match = %q, want the ETag of the loaded object", got)
+	}
+	stored := server.bodies[0]
+	if !strings.Contains(stored, "RRULE:FREQ=DAILY;COUNT=3") {
+		t.Errorf("recurring event not kept:\n%s", stored)
+	}
+	if !strings.Contains(stored, "EXDATE:20240102T100000Z") || strings.Contains(stored, "Moved") {
+		t.Errorf("instance not cancelled:\n%s", stored)
+	}
+
+	if err := client.Events("/calendars/user/other/").Delete(context.Background(), "missing_20240102T100000Z"); err != nil {
+		t.Errorf("deleting an instance of a missing event = %v, want nil", err)
+	}
+}
+
+func TestRedirects(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("request %s %s sent to another server", r.Method, r.URL)
+	}))
+	defer other.Close()
+
+	var requests []*http.Request
+	client, _ := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		switch r.URL.Path {
+		case "/temporary":
+			http.Redirect(w, r, "/target", http.StatusTemporaryRedirect)
+		case "/see-other":
+			http.Redirect(w, r, "/target", http.StatusSeeOther)
+		case "/elsewhere":
+			http.Redirect(w, r, other.URL+"/target", http.StatusTemporaryRedirect)
+		case "/target":
+			if _, password, _ := r.BasicAuth(); password != testPassword {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	ctx := context.Background()
+
+	if _, err := client.Put(ctx, "/temporary", []byte("data"), ""); err != nil {
+		t.Fatal(err)
+	}
+	if last := requests[len(requests)-1]; last.Method != http.MethodPut || last.URL.Path != "/target" {
+		t.Errorf("temporary redirect followed with %s %s, want PUT /target", last.Method, last.URL.Path)
+	}
+
+	if err := client.Delete(ctx, "/see-other", ""); err != nil {
+		t.Fatal(err)
+	}
+	if last := requests[len(requests)-1]; last.Method != http.MethodGet || last.URL.Path != "/target" {
+		t.Errorf("see other followed with %s %s, want GET /target", last.Method, last.URL.Path)
+	}
+
+	if err := client.Delete(ctx, "/elsewhere", ""); err == nil {
+		t.Error("followed a redirect to another server")
+	}
+}
@@ -0,0 +1,145 @@
+package caldav
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const wellKnownPath = "/.well-known/caldav"
+
+// Calendar is a calendar collection of the user.
+type Calendar struct {
+	Href string
+	Name string
+	// ReadOnly is only set when the server reports the privileges of the
+	// user.
+	ReadOnly bool
+	Color    string
+	// TimeZone is the TZID of the calendar time zone.
+	TimeZone string
+}
+
+// Calendars discovers the calendar collections of the user that can hold
+// events.
+func (c *Client) Calendars(ctx context.Context) ([]Calendar, error) {
+	principal, err := c.principal(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	home, err := c.calendarHome(ctx, principal)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := c.xmlRequest(ctx, "PROPFIND", home, "1", `<D:propfind xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop>
+    <D:resourcetype/>
+    <D:displayname/>
+    <C:supported-calendar-component-set/>
+    <D:current-user-privilege-set/>
+    <A:calendar-color xmlns:A="http://apple.com/ns/ical/"/>
+    <C:calendar-timezone/>
+  </D:prop>
+</D:propfind>`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list calendars")
+	}
+
+	var calendars []Calendar
+	for _, r := range result.Responses {
+		p := r.props()
+		if p.ResourceType == nil || p.ResourceType.Calendar == nil || !supportsEvents(p.Components) {
+			continue
+		}
+		name := p.DisplayName
+		if name == "" {
+			name = c.path(r.Href)
+		}
+		calendars = append(calendars, Calendar{
+			Href:     c.path(r.Href),
+			Name:     name,
+			ReadOnly: p.Privileges != nil && !p.Privileges.canWrite(),
+			Color:    p.CalendarColor,
+			TimeZone: timeZoneID(p.CalendarTimeZone),
+		})
+	}
+
+	sort.Slice(calendars, func(i, j int) bool {
+		return calendars[i].Href < calendars[j].Href
+	})
+	return calendars, nil
+}
+
+// principal finds the principal of the user, starting from the endpoint and
+// falling back to the well known location.
+func (c *Client) principal(ctx context.Context) (string, error) {
+	const body = `<D:propfind xmlns:D="DAV:">
+  <D:prop>
+    <D:current-user-principal/>
+  </D:prop>
+</D:propfind>`
+
+	var lastErr error
+	for _, href := range []string{c.endpoint.String(), wellKnownPath} {
+		result, err := c.xmlRequest(ctx, "PROPFIND", href, "0", body)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, r := range result.Responses {
+			if p := r.props(); p.CurrentUserPrincipal != nil && p.CurrentUserPrincipal.Href != "" {
+				return p.CurrentUserPrincipal.Href, nil
+			}
+		}
+	}
+	if lastErr != nil {
+		return "", errors.Wrap(lastErr, "failed to find the user principal")
+	}
+	return "", errors.New("failed to find the user principal")
+}
+
+func (c *Client) calendarHome(ctx context.Context, principal string) (string, error) {
+	result, err := c.xmlRequest(ctx, "PROPFIND", principal, "0", `<D:propfind xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop>
+    <C:calendar-home-set/>
+  </D:prop>
+</D:propfind>`)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to find the calendar home")
+	}
+	for _, r := range result.Responses {
+		if p := r.props(); p.CalendarHomeSet != nil && p.CalendarHomeSet.Href != "" {
+			return p.CalendarHomeSet.Href, nil
+		}
+	}
+	return "", errors.New("failed to find the calendar home")
+}
+
+// supportsEvents reports whether a calendar accepts VEVENT components. All
+// components are allowed when the server doesn't say.
+func supportsEvents(components []component) bool {
+	if len(components) == 0 {
+		return true
+	}
+	for _, c := range components {
+		if c.Name == "VEVENT" {
+			return true
+		}
+	}
+	return false
+}
+
+// timeZoneID returns the TZID of the VTIMEZONE in a calendar-timezone
+// property.
+func timeZoneID(vtimezone string) string {
+	for _, line := range strings.Split(vtimezone, "\n") {
+		if id, ok := strings.CutPrefix(strings.TrimSpace(line), "TZID:"); ok {
+			return id
+		}
+	}
+	return ""
+}
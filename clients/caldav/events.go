@@ -0,0 +1,230 @@
+package caldav
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/calendar/v3"
+
+	"github.com/robertdolca/calendar-sync/clients/ics"
+)
+
+const uidSuffix = "@calendar-sync"
+
+// Events stores events of the calendar API in a calendar collection. A
+// recurring event is stored in a single calendar object together with its
+// modified instances, whose IDs are built with ics.InstanceID.
+type Events struct {
+	client     *Client
+	collection string
+}
+
+// Events returns the events of a calendar collection.
+func (c *Client) Events(collection string) *Events {
+	return &Events{
+		client:     c,
+		collection: collection,
+	}
+}
+
+// ObjectEvents parses the events of a calendar object.
+func ObjectEvents(object Object) ([]*calendar.Event, error) {
+	cal, err := ics.Parse(strings.NewReader(object.Data))
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid calendar object %s", object.Href)
+	}
+	events, err := ics.Events(cal)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid calendar object %s", object.Href)
+	}
+	return events, nil
+}
+
+// Insert creates an event and returns its ID. Instances of recurring events
+// are added to the object of their recurring event. Other events are stored
+// under their ID, see NewUID, or a new one when it is empty. The object must
+// not exist yet, so creating it again fails with a precondition error.
+func (e *Events) Insert(ctx context.Context, event *calendar.Event) (string, error) {
+	if event.RecurringEventId != "" {
+		return e.storeInstance(ctx, event)
+	}
+
+	uid := event.Id
+	if uid == "" {
+		var err error
+		if uid, err = NewUID(); err != nil {
+			return "", err
+		}
+	}
+
+	created := *event
+	created.Id = uid
+	if err := e.store(ctx, uid, []*calendar.Event{&created}, ""); err != nil {
+		return "", err
+	}
+	return uid, nil
+}
+
+// Update replaces an event. The modified instances of a recurring event are
+// kept.
+func (e *Events) Update(ctx context.Context, eventID string, event *calendar.Event) (string, error) {
+	if event.RecurringEventId != "" {
+		return e.storeInstance(ctx, event)
+	}
+
+	events, etag, err := e.load(ctx, eventID)
+	if err != nil {
+		return "", err
+	}
+
+	updated := *event
+	updated.Id = eventID
+	events = replace(events, &updated)
+	if err := e.store(ctx, eventID, events, etag); err != nil {
+		return "", err
+	}
+	return eventID, nil
+}
+
+// Delete removes an event. Deleting an instance of a recurring event
+// cancels it. Events that no longer exist are ignored.
+func (e *Events) Delete(ctx context.Context, eventID string) error {
+	uid, isInstance := recurringEventID(eventID)
+	if !isInstance {
+		err := e.client.Delete(ctx, e.href(eventID), "")
+		if IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	events, etag, err := e.load(ctx, uid)
+	if IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		if event.Id == eventID {
+			events = replace(events, cancelledInstance(uid, event.OriginalStartTime))
+			return e.store(ctx, uid, events, etag)
+		}
+	}
+	return nil
+}
+
+// DeleteInstance cancels an instance of a recurring event. It reports false
+// when the recurring event doesn't exist.
+func (e *Events) DeleteInstance(ctx context.Context, recurringEventID string, originalStart *calendar.EventDateTime) (bool, error) {
+	events, etag, err := e.load(ctx, recurringEventID)
+	if IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	events = replace(events, cancelledInstance(recurringEventID, originalStart))
+	if err := e.store(ctx, recurringEventID, events, etag); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (e *Events) storeInstance(ctx context.Context, event *calendar.Event) (string, error) {
+	if event.OriginalStartTime == nil {
+		return "", errors.New("missing original start of recurring event instance")
+	}
+
+	uid := event.RecurringEventId
+	events, etag, err := e.load(ctx, uid)
+	if err != nil {
+		return "", err
+	}
+
+	instance := *event
+	instance.Id = ics.InstanceID(uid, event.OriginalStartTime)
+	events = replace(events, &instance)
+	if err := e.store(ctx, uid, events, etag); err != nil {
+		return "", err
+	}
+	return instance.Id, nil
+}
+
+func (e *Events) load(ctx context.Context, uid string) ([]*calendar.Event, string, error) {
+	object, err := e.client.Get(ctx, e.href(uid))
+	if err != nil {
+		return nil, "", err
+	}
+	events, err := ObjectEvents(object)
+	if err != nil {
+		return nil, "", err
+	}
+	return events, object.ETag, nil
+}
+
+func (e *Events) store(ctx context.Context, uid string, events []*calendar.Event, etag string) error {
+	cal, err := ics.Calendar(events)
+	if err != nil {
+		return errors.Wrap(err, "unable to build calendar object")
+	}
+
+	var data bytes.Buffer
+	if err := ics.Encode(&data, cal); err != nil {
+		return err
+	}
+
+	_, err = e.client.Put(ctx, e.href(uid), data.Bytes(), etag)
+	return err
+}
+
+func (e *Events) href(uid string) string {
+	return strings.TrimSuffix(e.collection, "/") + "/" + url.PathEscape(uid) + ".ics"
+}
+
+// replace replaces the event with the same ID or adds it.
+func replace(events []*calendar.Event, event *calendar.Event) []*calendar.Event {
+	for i := range events {
+		if events[i].Id == event.Id {
+			events[i] = event
+			return events
+		}
+	}
+	return append(events, event)
+}
+
+func cancelledInstance(recurringEventID string, originalStart *calendar.EventDateTime) *calendar.Event {
+	return &calendar.Event{
+		Id:                ics.InstanceID(recurringEventID, originalStart),
+		RecurringEventId:  recurringEventID,
+		OriginalStartTime: originalStart,
+		Status:            "cancelled",
+	}
+}
+
+// recurringEventID returns the UID of the recurring event of an instance ID.
+// The UIDs of created events don't contain underscores, so the first one
+// separates the UID from the original start.
+func recurringEventID(eventID string) (string, bool) {
+	i := strings.Index(eventID, "_")
+	if i < 0 {
+		return eventID, false
+	}
+	return eventID[:i], true
+}
+
+// NewUID returns a random UID for an event created by Insert.
+func NewUID() (string, error) {
+	data := make([]byte, 16)
+	if _, err := rand.Read(data); err != nil {
+		return "", errors.Wrap(err, "failed to generate event uid")
+	}
+	return hex.EncodeToString(data) + uidSuffix, nil
+}
@@ -0,0 +1,150 @@
+package caldav
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Changes are the calendar objects modified since a sync token.
+type Changes struct {
+	Changed []string
+	Removed []string
+	Token   string
+}
+
+// SyncToken returns the current sync token of a collection or an empty
+// string when the server doesn't support collection synchronization.
+func (c *Client) SyncToken(ctx context.Context, collection string) (string, error) {
+	result, err := c.xmlRequest(ctx, "PROPFIND", collection, "0", `<D:propfind xmlns:D="DAV:">
+  <D:prop>
+    <D:sync-token/>
+  </D:prop>
+</D:propfind>`)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read the sync token")
+	}
+	for _, r := range result.Responses {
+		if token := r.props().SyncToken; token != "" {
+			return token, nil
+		}
+	}
+	return "", nil
+}
+
+// Query lists all the events of a collection.
+func (c *Client) Query(ctx context.Context, collection string) ([]Object, error) {
+	return c.query(ctx, collection, `<C:comp-filter name="VEVENT"/>`)
+}
+
+// QueryUID returns the calendar objects of an event UID.
+func (c *Client) QueryUID(ctx context.Context, collection, uid string) ([]Object, error) {
+	return c.query(ctx, collection, `<C:comp-filter name="VEVENT">
+        <C:prop-filter name="UID">
+          <C:text-match collation="i;octet">`+escape(uid)+`</C:text-match>
+        </C:prop-filter>
+      </C:comp-filter>`)
+}
+
+func (c *Client) query(ctx context.Context, collection, eventFilter string) ([]Object, error) {
+	result, err := c.xmlRequest(ctx, "REPORT", collection, "1", `<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop>
+    <D:getetag/>
+    <C:calendar-data/>
+  </D:prop>
+  <C:filter>
+    <C:comp-filter name="VCALENDAR">
+      `+eventFilter+`
+    </C:comp-filter>
+  </C:filter>
+</C:calendar-query>`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query events")
+	}
+	return c.objects(result), nil
+}
+
+// SyncCollection returns the objects changed since the sync token. The
+// server rejects tokens that expired, see IsInvalidSyncToken.
+func (c *Client) SyncCollection(ctx context.Context, collection, token string) (Changes, error) {
+	result, err := c.xmlRequest(ctx, "REPORT", collection, "0", `<D:sync-collection xmlns:D="DAV:">
+  <D:sync-token>`+escape(token)+`</D:sync-token>
+  <D:sync-level>1</D:sync-level>
+  <D:prop>
+    <D:getetag/>
+  </D:prop>
+</D:sync-collection>`)
+	if err != nil {
+		return Changes{}, errors.Wrap(err, "failed to sync collection")
+	}
+
+	changes := Changes{Token: result.SyncToken}
+	collectionPath := strings.TrimSuffix(c.path(collection), "/")
+	for _, r := range result.Responses {
+		href := c.path(r.Href)
+		if strings.TrimSuffix(href, "/") == collectionPath {
+			continue
+		}
+		if statusNotFound(r.Status) {
+			changes.Removed = append(changes.Removed, href)
+		} else {
+			changes.Changed = append(changes.Changed, href)
+		}
+	}
+	return changes, nil
+}
+
+// IsInvalidSyncToken reports whether the server rejected a sync token,
+// after which the collection has to be listed again.
+func IsInvalidSyncToken(err error) bool {
+	caldavErr, ok := errors.Cause(err).(*Error)
+	if !ok {
+		return false
+	}
+	switch caldavErr.StatusCode {
+	case http.StatusForbidden, http.StatusConflict, http.StatusBadRequest, http.StatusPreconditionFailed:
+		return true
+	}
+	return false
+}
+
+// Multiget downloads several calendar objects of a collection at once.
+func (c *Client) Multiget(ctx context.Context, collection string, hrefs []string) ([]Object, error) {
+	if len(hrefs) == 0 {
+		return nil, nil
+	}
+
+	var b strings.Builder
+	for _, href := range hrefs {
+		b.WriteString("  <D:href>" + escape(href) + "</D:href>\n")
+	}
+
+	result, err := c.xmlRequest(ctx, "REPORT", collection, "", `<C:calendar-multiget xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop>
+    <D:getetag/>
+    <C:calendar-data/>
+  </D:prop>
+`+b.String()+`</C:calendar-multiget>`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to download events")
+	}
+	return c.objects(result), nil
+}
+
+func (c *Client) objects(result *multistatus) []Object {
+	var objects []Object
+	for _, r := range result.Responses {
+		p := r.props()
+		if p.CalendarData == "" {
+			continue
+		}
+		objects = append(objects, Object{
+			Href: c.path(r.Href),
+			ETag: p.ETag,
+			Data: p.CalendarData,
+		})
+	}
+	return objects
+}
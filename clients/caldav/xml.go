@@ -0,0 +1,115 @@
+package caldav
+
+type multistatus struct {
+	Responses []response `xml:"DAV: response"`
+	SyncToken string     `xml:"DAV: sync-token"`
+}
+
+type response struct {
+	Href     string     `xml:"DAV: href"`
+	Status   string     `xml:"DAV: status"`
+	Propstat []propstat `xml:"DAV: propstat"`
+}
+
+type propstat struct {
+	Prop   prop   `xml:"DAV: prop"`
+	Status string `xml:"DAV: status"`
+}
+
+type prop struct {
+	ResourceType         *resourceType `xml:"DAV: resourcetype"`
+	DisplayName          string        `xml:"DAV: displayname"`
+	ETag                 string        `xml:"DAV: getetag"`
+	SyncToken            string        `xml:"DAV: sync-token"`
+	CurrentUserPrincipal *hrefProp     `xml:"DAV: current-user-principal"`
+	CalendarHomeSet      *hrefProp     `xml:"urn:ietf:params:xml:ns:caldav calendar-home-set"`
+	CalendarData         string        `xml:"urn:ietf:params:xml:ns:caldav calendar-data"`
+	Components           []component   `xml:"urn:ietf:params:xml:ns:caldav supported-calendar-component-set>comp"`
+	Privileges           *privilegeSet `xml:"DAV: current-user-privilege-set"`
+	CalendarColor        string        `xml:"http://apple.com/ns/ical/ calendar-color"`
+	CalendarTimeZone     string        `xml:"urn:ietf:params:xml:ns:caldav calendar-timezone"`
+}
+
+type resourceType struct {
+	Calendar *struct{} `xml:"urn:ietf:params:xml:ns:caldav calendar"`
+}
+
+type hrefProp struct {
+	Href string `xml:"DAV: href"`
+}
+
+type component struct {
+	Name string `xml:"name,attr"`
+}
+
+type privilegeSet struct {
+	Privileges []privilege `xml:"DAV: privilege"`
+}
+
+// privilege holds the privileges that allow changing events: bind to create
+// them and write-content to modify them, both included in write and write in
+// all.
+type privilege struct {
+	All          *struct{} `xml:"DAV: all"`
+	Write        *struct{} `xml:"DAV: write"`
+	Bind         *struct{} `xml:"DAV: bind"`
+	WriteContent *struct{} `xml:"DAV: write-content"`
+}
+
+// canWrite reports whether the privileges allow creating and changing events.
+func (s privilegeSet) canWrite() bool {
+	var bind, writeContent bool
+	for _, p := range s.Privileges {
+		if p.All != nil || p.Write != nil {
+			return true
+		}
+		bind = bind || p.Bind != nil
+		writeContent = writeContent || p.WriteContent != nil
+	}
+	return bind && writeContent
+}
+
+// props merges the properties of the successful propstat elements.
+func (r response) props() prop {
+	var result prop
+	for _, ps := range r.Propstat {
+		if !statusOK(ps.Status) {
+			continue
+		}
+		p := ps.Prop
+		if p.ResourceType != nil {
+			result.ResourceType = p.ResourceType
+		}
+		if p.DisplayName != "" {
+			result.DisplayName = p.DisplayName
+		}
+		if p.ETag != "" {
+			result.ETag = p.ETag
+		}
+		if p.SyncToken != "" {
+			result.SyncToken = p.SyncToken
+		}
+		if p.CurrentUserPrincipal != nil {
+			result.CurrentUserPrincipal = p.CurrentUserPrincipal
+		}
+		if p.CalendarHomeSet != nil {
+			result.CalendarHomeSet = p.CalendarHomeSet
+		}
+		if p.CalendarData != "" {
+			result.CalendarData = p.CalendarData
+		}
+		if len(p.Components) > 0 {
+			result.Components = p.Components
+		}
+		if p.Privileges != nil {
+			result.Privileges = p.Privileges
+		}
+		if p.CalendarColor != "" {
+			result.CalendarColor = p.CalendarColor
+		}
+		if p.CalendarTimeZone != "" {
+			result.CalendarTimeZone = p.CalendarTimeZone
+		}
+	}
+	return result
+}
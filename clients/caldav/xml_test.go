@@ -0,0 +1,32 @@
+package caldav
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+func TestPrivilegeSetCanWrite(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		privileges string
+		want       bool
+	}{
+		{"all", `<d:privilege><d:all/></d:privilege>`, true},
+		{"write", `<d:privilege><d:read/></d:privilege><d:privilege><d:write/></d:privilege>`, true},
+		{"bind and write-content", `<d:privilege><d:bind/></d:privilege><d:privilege><d:write-content/></d:privilege>`, true},
+		{"write-content only", `<d:privilege><d:read/></d:privilege><d:privilege><d:write-content/></d:privilege>`, false},
+		{"bind only", `<d:privilege><d:bind/></d:privilege>`, false},
+		{"read only", `<d:privilege><d:read/></d:privilege>`, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var set privilegeSet
+			data := `<d:current-user-privilege-set xmlns:d="DAV:">` + test.privileges + `</d:current-user-privilege-set>`
+			if err := xml.Unmarshal([]byte(data), &set); err != nil {
+				t.Fatal(err)
+			}
+			if got := set.canWrite(); got != test.want {
+				t.Errorf("canWrite() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
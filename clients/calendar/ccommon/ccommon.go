@@ -2,6 +2,10 @@ package ccommon
 
 import (
 	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
 
 	"github.com/pkg/errors"
 	"golang.org/x/oauth2"
@@ -9,26 +13,57 @@ import (
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 
+	"github.com/robertdolca/calendar-sync/clients/caldav"
+	"github.com/robertdolca/calendar-sync/clients/graph"
+	"github.com/robertdolca/calendar-sync/clients/metrics"
+	"github.com/robertdolca/calendar-sync/clients/retry"
 	"github.com/robertdolca/calendar-sync/clients/syncdb"
 	"github.com/robertdolca/calendar-sync/clients/tmanager"
-	"github.com/robertdolca/calendar-sync/clients/userinfo"
+	"github.com/robertdolca/calendar-sync/clients/tracing"
 )
 
 const (
 	EventStatusCancelled = "cancelled"
-	ErrCodeNotFound = 404
+	ErrCodeNotFound      = 404
 )
 
+const (
+	caldavTimeout = time.Minute
+	graphTimeout  = time.Minute
+)
+
+// Access roles of a calendar, as named by the Google Calendar API.
+const (
+	AccessRoleOwner          = "owner"
+	AccessRoleWriter         = "writer"
+	AccessRoleReader         = "reader"
+	AccessRoleFreeBusyReader = "freeBusyReader"
+)
+
+// CalendarInfo describes a calendar of an account. AccessRole is empty when
+// the provider doesn't report it.
 type CalendarInfo struct {
-	Summary string
-	Id      string
-	Deleted bool
+	Summary    string `json:"summary"`
+	Id         string `json:"id"`
+	Deleted    bool   `json:"deleted"`
+	AccessRole string `json:"accessRole,omitempty"`
+	Primary    bool   `json:"primary"`
+	TimeZone   string `json:"timeZone,omitempty"`
+	Color      string `json:"color,omitempty"`
+	Hidden     bool   `json:"hidden"`
+	Selected   bool   `json:"selected"`
+}
+
+// Writable reports whether events can be added to the calendar, assuming
+// they can when the access role is unknown.
+func (c CalendarInfo) Writable() bool {
+	return c.AccessRole != AccessRoleReader && c.AccessRole != AccessRoleFreeBusyReader
 }
 
-type TokenCalendars struct {
-	Email     string
-	Calendars []CalendarInfo
-	Token     oauth2.Token
+// Matches reports whether the calendar is the one with the given ID, which
+// can be "primary" for the primary calendar of Google accounts.
+func (c CalendarInfo) Matches(calendarID string) bool {
+	return c.Id == calendarID || calendarID == "primary" && c.Primary
 }
 
 func calendarListEntryToCalendar(entry *calendar.CalendarListEntry) CalendarInfo {
@@ -37,86 +72,119 @@ func calendarListEntryToCalendar(entry *calendar.CalendarListEntry) CalendarInfo
 		summary = entry.Summary
 	}
 	return CalendarInfo{
-		Summary: summary,
-		Id:      entry.Id,
-		Deleted: entry.Deleted,
+		Summary:    summary,
+		Id:         entry.Id,
+		Deleted:    entry.Deleted,
+		AccessRole: entry.AccessRole,
+		Primary:    entry.Primary,
+		TimeZone:   entry.TimeZone,
+		Color:      entry.BackgroundColor,
+		Hidden:     entry.Hidden,
+		Selected:   entry.Selected,
 	}
 }
 
-func FindToken(tokensEmail []TokenCalendars, email string) *oauth2.Token {
-	for _, tokeEmail := range tokensEmail {
-		if tokeEmail.Email == email {
-			return &tokeEmail.Token
-		}
-	}
-	return nil
+// PairName identifies a source and destination calendar pair in logs and metrics.
+func PairName(srcAccountEmail, srcCalendarID, dstAccountEmail, dstCalendarID string) string {
+	return fmt.Sprintf("%s/%s->%s/%s", srcAccountEmail, srcCalendarID, dstAccountEmail, dstCalendarID)
 }
 
-func UsersCalendarsTokens(
-	ctx context.Context,
-	userInfo *userinfo.Manager,
-	tokenMgr *tmanager.Manager,
-) ([]TokenCalendars, error) {
-	tokens := tokenMgr.List()
-	config := tokenMgr.Config()
-
-	result := make([]TokenCalendars, 0, len(tokens))
-	for _, token := range tokens {
-		email, err := userInfo.Email(ctx, &token)
-		if err != nil {
-			return nil, err
-		}
+// GoogleCalendars lists the calendars of a Google account.
+func GoogleCalendars(ctx context.Context, tokenMgr *tmanager.Manager, email string) (calendars []CalendarInfo, err error) {
+	ctx, span := tracing.Start(ctx, "calendars.list")
+	defer func() {
+		tracing.End(span, err)
+	}()
 
-		calendars, err := calendars(ctx, config, &token)
-		if err != nil {
-			return nil, err
-		}
+	slog.Debug("listing calendars", "account", email)
 
-		result = append(result, TokenCalendars{
-			Email:     email,
-			Calendars: calendars,
-			Token:     token,
-		})
+	tokenSource, err := tokenMgr.GoogleTokenSource(ctx, email)
+	if err != nil {
+		return nil, err
 	}
-
-	return result, nil
+	return googleCalendars(ctx, tokenSource)
 }
 
-func calendars(ctx context.Context, config *oauth2.Config, token *oauth2.Token) ([]CalendarInfo, error) {
-	srv, err := calendar.NewService(ctx, option.WithTokenSource(config.TokenSource(ctx, token)))
+func googleCalendars(ctx context.Context, tokenSource oauth2.TokenSource) ([]CalendarInfo, error) {
+	srv, err := calendar.NewService(ctx, option.WithTokenSource(tokenSource))
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to create calendar client")
 	}
 
-	calendarList, err := srv.CalendarList.List().Do()
+	// hidden and deleted entries are kept so callers can tell them apart
+	// from calendars the account can't access
+	var calendars []CalendarInfo
+	err = srv.CalendarList.List().ShowHidden(true).ShowDeleted(true).Pages(ctx, func(page *calendar.CalendarList) error {
+		for _, entry := range page.Items {
+			calendars = append(calendars, calendarListEntryToCalendar(entry))
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to get calendar list")
 	}
 
-	var calendars []CalendarInfo
-	for _, entry := range calendarList.Items {
-		calendars = append(calendars, calendarListEntryToCalendar(entry))
-	}
-
 	return calendars, nil
 }
 
-func DeleteDstEvent(syncDB *syncdb.DB, dstService *calendar.Service, r syncdb.Record, softDelete bool) error {
-	dstEvent, err := dstService.Events.Get(r.Dst.CalendarID, r.Dst.EventID).Do()
+func DeleteDstEvent(
+	ctx context.Context,
+	syncDB *syncdb.DB,
+	dstService *calendar.Service,
+	retrier *retry.Retrier,
+	r syncdb.Record,
+	softDelete bool,
+) error {
+	ctx, span := tracing.Start(ctx, "calendar.events.get")
+	var dstEvent *calendar.Event
+	err := retrier.Do(ctx, func() (err error) {
+		dstEvent, err = dstService.Events.Get(r.Dst.CalendarID, r.Dst.EventID).Context(ctx).Do()
+		return err
+	})
+	tracing.End(span, err)
 	if err != nil {
 		if calendarErr, ok := err.(*googleapi.Error); ok && calendarErr.Code == ErrCodeNotFound {
+			slog.Debug("copy already removed", "operation", "delete", "event_id", r.Src.EventID, "dst_event_id", r.Dst.EventID)
 			return syncDB.Delete(r)
 		}
 		return errors.Wrapf(err, "failed to get event before deletion")
 	}
 
 	if dstEvent.Status != EventStatusCancelled {
-		if err := dstService.Events.Delete(r.Dst.CalendarID, r.Dst.EventID).Do(); err != nil {
+		deleteCtx, span := tracing.Start(ctx, "calendar.events.delete")
+		err = retrier.Do(deleteCtx, func() error {
+			return dstService.Events.Delete(r.Dst.CalendarID, r.Dst.EventID).Context(deleteCtx).Do()
+		})
+		tracing.End(span, err)
+		metrics.Operation(
+			PairName(r.Src.AccountEmail, r.Src.CalendarID, r.Dst.AccountEmail, r.Dst.CalendarID),
+			"delete",
+			err,
+		)
+		if err != nil {
 			return errors.Wrapf(err, "failed to delete event")
 		}
 	}
+	slog.Debug("deleted copy", "operation", "delete", "event_id", r.Src.EventID, "dst_event_id", r.Dst.EventID, "soft_delete", softDelete)
 	if softDelete {
 		return syncDB.SoftDelete(r)
 	}
 	return syncDB.Delete(r)
 }
+
+// CalDAVClient creates the client of a CalDAV account.
+func CalDAVClient(account tmanager.CalDAVAccount) (*caldav.Client, error) {
+	return caldav.New(&http.Client{Timeout: caldavTimeout}, account.URL, account.Username, account.Password)
+}
+
+// GraphClient creates the Microsoft Graph client of an account.
+func GraphClient(ctx context.Context, tokenManager *tmanager.Manager, account tmanager.GraphAccount) (*graph.Client, error) {
+	config, endpoint, err := tokenManager.GraphConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient := oauth2.NewClient(ctx, tokenManager.GraphTokenSource(ctx, config, account))
+	httpClient.Timeout = graphTimeout
+	return graph.New(httpClient, endpoint)
+}
@@ -2,6 +2,10 @@ package calendar
 
 import (
 	"context"
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"time"
 
 	"github.com/pkg/errors"
 	"google.golang.org/api/calendar/v3"
@@ -9,78 +13,376 @@ import (
 
 	"github.com/robertdolca/calendar-sync/clients/calendar/ccommon"
 	"github.com/robertdolca/calendar-sync/clients/calendar/sync"
+	"github.com/robertdolca/calendar-sync/clients/config"
+	"github.com/robertdolca/calendar-sync/clients/graph"
+	"github.com/robertdolca/calendar-sync/clients/metrics"
+	"github.com/robertdolca/calendar-sync/clients/retry"
 	"github.com/robertdolca/calendar-sync/clients/syncdb"
 	"github.com/robertdolca/calendar-sync/clients/tmanager"
-	"github.com/robertdolca/calendar-sync/clients/userinfo"
 )
 
+// calendarListTTL is how long listed calendars are reused.
+const calendarListTTL = 24 * time.Hour
+
 type Manager struct {
 	tokenManager *tmanager.Manager
-	userInfo     *userinfo.Manager
 	syncDB       *syncdb.DB
+	aliases      map[string]config.Alias
 }
 
-type UserCalendars struct {
-	Email     string
-	Calendars []ccommon.CalendarInfo
-}
-
-func New(tokenManager *tmanager.Manager, userInfo *userinfo.Manager, syncDB *syncdb.DB) *Manager {
+func New(tokenManager *tmanager.Manager, syncDB *syncdb.DB, aliases map[string]config.Alias) *Manager {
 	return &Manager{
 		tokenManager: tokenManager,
-		userInfo:     userInfo,
 		syncDB:       syncDB,
+		aliases:      aliases,
+	}
+}
+
+// Accounts returns the emails of the Google and Microsoft accounts and the
+// names of the CalDAV accounts.
+func (s *Manager) Accounts() []string {
+	emails := s.tokenManager.GoogleEmails()
+	for _, account := range s.tokenManager.CalDAVAccounts() {
+		emails = append(emails, account.Name)
+	}
+	for _, account := range s.tokenManager.GraphAccounts() {
+		emails = append(emails, account.Email)
 	}
+	return emails
 }
 
-func (s *Manager) UsersCalendars(ctx context.Context) ([]UserCalendars, error) {
-	calendarTokens, err := ccommon.UsersCalendarsTokens(ctx, s.userInfo, s.tokenManager)
+// AccountCalendars lists the calendars of an account, from the cache when
+// they were listed less than calendarListTTL ago and refresh is not set.
+func (s *Manager) AccountCalendars(ctx context.Context, email string, refresh bool) ([]ccommon.CalendarInfo, error) {
+	if !refresh {
+		calendars, ok := s.cachedCalendars(email)
+		if ok {
+			return calendars, nil
+		}
+	}
+
+	calendars, err := s.listCalendars(ctx, email)
 	if err != nil {
 		return nil, err
 	}
 
-	result := make([]UserCalendars, 0, len(calendarTokens))
-	for _, calendarToken := range calendarTokens {
-		result = append(result, UserCalendars{
-			Email:     calendarToken.Email,
-			Calendars: calendarToken.Calendars,
+	data, err := json.Marshal(calendars)
+	if err == nil {
+		err = s.syncDB.SaveCalendarList(syncdb.CalendarList{
+			AccountEmail: email,
+			Calendars:    data,
+			FetchedAt:    time.Now(),
 		})
 	}
+	if err != nil {
+		slog.Warn("failed to cache calendar list", "account", email, "error", err)
+	}
 
-	return result, nil
+	return calendars, nil
 }
 
-func (s *Manager) Clear(ctx context.Context, accountEmail, calendarID string) error {
-	calendarTokens, err := ccommon.UsersCalendarsTokens(ctx, s.userInfo, s.tokenManager)
+func (s *Manager) cachedCalendars(email string) ([]ccommon.CalendarInfo, bool) {
+	list, err := s.syncDB.FindCalendarList(email)
 	if err != nil {
-		return err
+		if err != syncdb.ErrNotFound {
+			slog.Warn("failed to read cached calendar list", "account", email, "error", err)
+		}
+		return nil, false
+	}
+	if time.Since(list.FetchedAt) > calendarListTTL {
+		return nil, false
 	}
 
-	token := ccommon.FindToken(calendarTokens, accountEmail)
-	if token == nil {
-		return errors.New("source account not authenticated")
+	var calendars []ccommon.CalendarInfo
+	if err := json.Unmarshal(list.Calendars, &calendars); err != nil {
+		slog.Warn("failed to read cached calendar list", "account", email, "error", err)
+		return nil, false
 	}
+	slog.Debug("using cached calendar list", "account", email, "fetched_at", list.FetchedAt)
+	return calendars, true
+}
 
-	config := s.tokenManager.Config()
-	service, err := calendar.NewService(ctx, option.WithTokenSource(config.TokenSource(ctx, token)))
+// knownMissing reports whether the calendar was not found in the cached
+// calendar list of the account when it was last looked up.
+func (s *Manager) knownMissing(email, calendarID string) bool {
+	list, err := s.syncDB.FindCalendarList(email)
 	if err != nil {
-		return errors.Wrap(err, "unable to create calendar client")
+		return false
 	}
+	return slices.Contains(list.Missing, calendarID)
+}
+
+// rememberMissing records that the calendar is not in the cached calendar
+// list of the account, until the list is fetched again.
+func (s *Manager) rememberMissing(email, calendarID string) {
+	list, err := s.syncDB.FindCalendarList(email)
+	if err == nil && !slices.Contains(list.Missing, calendarID) {
+		list.Missing = append(list.Missing, calendarID)
+		err = s.syncDB.SaveCalendarList(list)
+	}
+	if err != nil && err != syncdb.ErrNotFound {
+		slog.Warn("failed to cache missing calendar", "account", email, "calendar", calendarID, "error", err)
+	}
+}
+
+func (s *Manager) listCalendars(ctx context.Context, email string) ([]ccommon.CalendarInfo, error) {
+	if account, ok := s.tokenManager.CalDAVAccount(email); ok {
+		return caldavCalendars(ctx, account)
+	}
+	if account, ok := s.tokenManager.GraphAccount(email); ok {
+		return graphCalendars(ctx, s.tokenManager, account)
+	}
+	return ccommon.GoogleCalendars(ctx, s.tokenManager, email)
+}
+
+func graphCalendars(ctx context.Context, tokenManager *tmanager.Manager, account tmanager.GraphAccount) ([]ccommon.CalendarInfo, error) {
+	client, err := ccommon.GraphClient(ctx, tokenManager, account)
+	if err != nil {
+		return nil, err
+	}
+
+	calendars, err := client.Calendars(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]ccommon.CalendarInfo, 0, len(calendars))
+	for _, cal := range calendars {
+		accessRole := ccommon.AccessRoleReader
+		if cal.CanEdit {
+			accessRole = ccommon.AccessRoleWriter
+		}
+		result = append(result, ccommon.CalendarInfo{
+			Summary:    cal.Name,
+			Id:         cal.ID,
+			AccessRole: accessRole,
+			Primary:    cal.IsDefaultCalendar,
+			Color:      cal.HexColor,
+			Selected:   true,
+		})
+	}
+	return result, nil
+}
+
+func caldavCalendars(ctx context.Context, account tmanager.CalDAVAccount) ([]ccommon.CalendarInfo, error) {
+	client, err := ccommon.CalDAVClient(account)
+	if err != nil {
+		return nil, err
+	}
+
+	calendars, err := client.Calendars(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]ccommon.CalendarInfo, 0, len(calendars))
+	for _, cal := range calendars {
+		var accessRole string
+		if cal.ReadOnly {
+			accessRole = ccommon.AccessRoleReader
+		}
+		result = append(result, ccommon.CalendarInfo{
+			Summary:    cal.Name,
+			Id:         cal.Href,
+			AccessRole: accessRole,
+			TimeZone:   cal.TimeZone,
+			Color:      cal.Color,
+			Selected:   true,
+		})
+	}
+	return result, nil
+}
 
+// ClearFilter selects the records removed by Clear. Empty fields match every
+// record. The start of recurring events is their first occurrence. Records
+// saved before their start was recorded don't match a date range.
+type ClearFilter struct {
+	SrcAccountEmail string
+	SrcCalendarID   string
+	StartAfter      time.Time
+	StartBefore     time.Time
+	OnlySoftDeleted bool
+}
+
+func (f ClearFilter) matches(r syncdb.Record) bool {
+	if f.SrcAccountEmail != "" && r.Src.AccountEmail != f.SrcAccountEmail {
+		return false
+	}
+	if f.SrcCalendarID != "" && r.Src.CalendarID != f.SrcCalendarID {
+		return false
+	}
+	if f.OnlySoftDeleted && !r.Deleted {
+		return false
+	}
+	if !f.StartAfter.IsZero() && (r.Start.IsZero() || r.Start.Before(f.StartAfter)) {
+		return false
+	}
+	if !f.StartBefore.IsZero() && (r.Start.IsZero() || !r.Start.Before(f.StartBefore)) {
+		return false
+	}
+	return true
+}
+
+// ClearRecords returns the records of a destination calendar selected by the
+// filter, to be passed to Clear, and the number of records left out only
+// because their start is unknown.
+func (s *Manager) ClearRecords(accountEmail, calendarID string, filter ClearFilter) ([]syncdb.Record, int, error) {
 	records, err := s.syncDB.ListDst(accountEmail, calendarID)
 	if err != nil {
-		return errors.New("failed to list records")
+		return nil, 0, errors.Wrap(err, "failed to list records")
+	}
+
+	anyStart := filter
+	anyStart.StartAfter, anyStart.StartBefore = time.Time{}, time.Time{}
+
+	var result []syncdb.Record
+	unknownStart := 0
+	for _, record := range records {
+		if filter.matches(record) {
+			result = append(result, record)
+		} else if record.Start.IsZero() && anyStart.matches(record) {
+			unknownStart++
+		}
+	}
+	return result, unknownStart, nil
+}
+
+// Clear deletes the copies of the records from the destination calendar and
+// then the records. Progress is called after every record with the error
+// deleting it, which doesn't stop the other records from being deleted.
+// It returns the number of records that failed.
+func (s *Manager) Clear(
+	ctx context.Context,
+	accountEmail, calendarID string,
+	records []syncdb.Record,
+	progress func(record syncdb.Record, err error),
+) (int, error) {
+	deleteRecord, err := s.recordDeleter(ctx, accountEmail, calendarID)
+	if err != nil {
+		return 0, err
 	}
+	return deleteRecords(ctx, records, deleteRecord, progress)
+}
 
+func deleteRecords(
+	ctx context.Context,
+	records []syncdb.Record,
+	deleteRecord func(syncdb.Record) error,
+	progress func(record syncdb.Record, err error),
+) (int, error) {
+	failed := 0
 	for _, record := range records {
-		if err := ccommon.DeleteDstEvent(s.syncDB, service, record, false); err != nil {
-			return err
+		if err := ctx.Err(); err != nil {
+			return failed, err
+		}
+		err := deleteRecord(record)
+		if err != nil {
+			failed++
+		}
+		progress(record, err)
+	}
+	return failed, nil
+}
+
+func (s *Manager) recordDeleter(ctx context.Context, accountEmail, calendarID string) (func(syncdb.Record) error, error) {
+	retrier := retry.New(retry.DefaultPolicy, nil)
+
+	if account, ok := s.tokenManager.CalDAVAccount(accountEmail); ok {
+		client, err := ccommon.CalDAVClient(account)
+		if err != nil {
+			return nil, err
+		}
+		events := client.Events(calendarID)
+		return func(record syncdb.Record) error {
+			err := retrier.Do(ctx, func() error {
+				return events.Delete(ctx, record.Dst.EventID)
+			})
+			if err != nil {
+				return errors.Wrapf(err, "failed to delete event")
+			}
+			return s.syncDB.Delete(record)
+		}, nil
+	}
+
+	if account, ok := s.tokenManager.GraphAccount(accountEmail); ok {
+		client, err := ccommon.GraphClient(ctx, s.tokenManager, account)
+		if err != nil {
+			return nil, err
+		}
+		return func(record syncdb.Record) error {
+			err := retrier.Do(ctx, func() error {
+				return client.DeleteEvent(ctx, record.Dst.EventID)
+			})
+			if err != nil && !graph.IsNotFound(err) {
+				return errors.Wrapf(err, "failed to delete event")
+			}
+			return s.syncDB.Delete(record)
+		}, nil
+	}
+
+	tokenSource, err := s.tokenManager.GoogleTokenSource(ctx, accountEmail)
+	if err != nil {
+		return nil, err
+	}
+	service, err := calendar.NewService(ctx, option.WithTokenSource(tokenSource))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create calendar client")
+	}
+	return func(record syncdb.Record) error {
+		return ccommon.DeleteDstEvent(ctx, s.syncDB, service, retrier, record, false)
+	}, nil
+}
+
+func (s *Manager) Failures() ([]syncdb.Failure, error) {
+	return s.syncDB.ListFailures()
+}
+
+// RegisterMetrics exposes the sync database record counts as metrics.
+func (s *Manager) RegisterMetrics() error {
+	return metrics.RegisterSyncDB(s.syncDB)
+}
+
+func (s *Manager) Sync(ctx context.Context, request sync.Request) (sync.Report, error) {
+	if request.DstICSPath == "" {
+		if err := s.checkDestination(ctx, request.DstAccountEmail, request.DstCalendarID); err != nil {
+			return sync.Report{}, err
 		}
 	}
+	return sync.RunSync(ctx, s.syncDB, s.tokenManager, request)
+}
 
+// checkDestination refuses destination calendars the account can only read,
+// before any event is listed. A cached read only calendar is listed again in
+// case the access changed, as is a missing one, once until the list expires.
+// Calendars missing from the list are let through, the account may still be
+// able to write to them.
+func (s *Manager) checkDestination(ctx context.Context, accountEmail, calendarID string) error {
+	info, found, err := s.findCalendar(ctx, accountEmail, calendarID, false)
+	if err == nil && (found && !info.Writable() || !found && !s.knownMissing(accountEmail, calendarID)) {
+		info, found, err = s.findCalendar(ctx, accountEmail, calendarID, true)
+		if err == nil && !found {
+			s.rememberMissing(accountEmail, calendarID)
+		}
+	}
+	if err != nil {
+		slog.Warn("unable to check destination calendar access", "account", accountEmail, "calendar", calendarID, "error", err)
+		return nil
+	}
+	if found && !info.Writable() {
+		return errors.Errorf("destination calendar %s of %s is read only (access role %s)", calendarID, accountEmail, info.AccessRole)
+	}
 	return nil
 }
 
-func (s *Manager) Sync(ctx context.Context, request sync.Request) error {
-	return sync.RunSync(ctx, s.syncDB, s.tokenManager, s.userInfo, request)
+func (s *Manager) findCalendar(ctx context.Context, accountEmail, calendarID string, refresh bool) (ccommon.CalendarInfo, bool, error) {
+	calendars, err := s.AccountCalendars(ctx, accountEmail, refresh)
+	if err != nil {
+		return ccommon.CalendarInfo{}, false, err
+	}
+	for _, cal := range calendars {
+		if cal.Matches(calendarID) && !cal.Deleted {
+			return cal, true, nil
+		}
+	}
+	return ccommon.CalendarInfo{}, false, nil
 }
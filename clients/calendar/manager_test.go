@@ -0,0 +1,208 @@
+package calendar
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/robertdolca/calendar-sync/clients/calendar/ccommon"
+	"github.com/robertdolca/calendar-sync/clients/syncdb"
+)
+
+const testAccount = "user@example.com"
+
+// newTestManager returns a manager whose calendar list of testAccount is
+// cached. It has no token manager, listing the calendars again panics.
+func newTestManager(t *testing.T, calendars ...ccommon.CalendarInfo) *Manager {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	syncDB, err := syncdb.New()
+	if err != nil {
+		os.Chdir(wd)
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		syncDB.Close()
+		os.Chdir(wd)
+	})
+
+	data, err := json.Marshal(calendars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := syncdb.CalendarList{AccountEmail: testAccount, Calendars: data, FetchedAt: time.Now()}
+	if err := syncDB.SaveCalendarList(list); err != nil {
+		t.Fatal(err)
+	}
+	return New(nil, syncDB, nil)
+}
+
+func TestCheckDestinationKnownMissing(t *testing.T) {
+	s := newTestManager(t, ccommon.CalendarInfo{Id: "cal", Summary: "Calendar", AccessRole: "owner"})
+	s.rememberMissing(testAccount, "other")
+
+	if err := s.checkDestination(context.Background(), testAccount, "other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.checkDestination(context.Background(), testAccount, "cal"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRememberMissing(t *testing.T) {
+	s := newTestManager(t)
+	if s.knownMissing(testAccount, "cal") {
+		t.Fatal("calendar missing before it was looked up")
+	}
+
+	s.rememberMissing(testAccount, "cal")
+	s.rememberMissing(testAccount, "cal")
+	if !s.knownMissing(testAccount, "cal") || s.knownMissing(testAccount, "other") {
+		t.Error("missing calendar not remembered")
+	}
+	list, err := s.syncDB.FindCalendarList(testAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Missing) != 1 {
+		t.Errorf("missing = %v, want cal once", list.Missing)
+	}
+
+	// the lookups are forgotten with the list
+	list.Missing = nil
+	if err := s.syncDB.SaveCalendarList(list); err != nil {
+		t.Fatal(err)
+	}
+	if s.knownMissing(testAccount, "cal") {
+		t.Error("missing calendar remembered after the list was fetched again")
+	}
+}
+
+func TestResolveCalendarKnownMissing(t *testing.T) {
+	s := newTestManager(t, ccommon.CalendarInfo{Id: "cal-id", Summary: "Work"})
+	s.rememberMissing(testAccount, "other-id")
+
+	for calendar, want := range map[string]string{"work": "cal-id", "cal-id": "cal-id", "other-id": "other-id"} {
+		account, id, err := s.ResolveCalendar(context.Background(), testAccount, calendar)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if account != testAccount || id != want {
+			t.Errorf("ResolveCalendar(%s) = %s %s, want %s", calendar, account, id, want)
+		}
+	}
+}
+
+func clearTestRecord(id string, start time.Time) syncdb.Record {
+	return syncdb.Record{
+		Src:   syncdb.Event{EventID: id, AccountEmail: "src@example.com", CalendarID: "src"},
+		Dst:   syncdb.Event{EventID: "dst-" + id, AccountEmail: testAccount, CalendarID: "dst"},
+		Start: start,
+	}
+}
+
+func TestClearFilterMatches(t *testing.T) {
+	march := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	april := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	record := clearTestRecord("e", march)
+	deleted := record
+	deleted.Deleted = true
+
+	for _, test := range []struct {
+		name   string
+		filter ClearFilter
+		record syncdb.Record
+		want   bool
+	}{
+		{"empty", ClearFilter{}, record, true},
+		{"empty unknown start", ClearFilter{}, clearTestRecord("e", time.Time{}), true},
+		{"source account", ClearFilter{SrcAccountEmail: "src@example.com"}, record, true},
+		{"other source account", ClearFilter{SrcAccountEmail: "other@example.com"}, record, false},
+		{"source calendar", ClearFilter{SrcCalendarID: "src"}, record, true},
+		{"other source calendar", ClearFilter{SrcCalendarID: "other"}, record, false},
+		{"soft deleted", ClearFilter{OnlySoftDeleted: true}, deleted, true},
+		{"not soft deleted", ClearFilter{OnlySoftDeleted: true}, record, false},
+		{"start after", ClearFilter{StartAfter: march.Add(-time.Hour)}, record, true},
+		{"start after inclusive", ClearFilter{StartAfter: march}, record, true},
+		{"starts before start after", ClearFilter{StartAfter: march.Add(time.Hour)}, record, false},
+		{"start before", ClearFilter{StartBefore: april}, record, true},
+		{"start before exclusive", ClearFilter{StartBefore: march}, record, false},
+		{"in range", ClearFilter{StartAfter: march, StartBefore: april}, record, true},
+		{"unknown start after", ClearFilter{StartAfter: march}, clearTestRecord("e", time.Time{}), false},
+		{"unknown start before", ClearFilter{StartBefore: april}, clearTestRecord("e", time.Time{}), false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.filter.matches(test.record); got != test.want {
+				t.Errorf("matches() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestClearRecordsCountsUnknownStart(t *testing.T) {
+	s := newTestManager(t)
+	march := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	for _, record := range []syncdb.Record{
+		clearTestRecord("in-range", march),
+		clearTestRecord("out-of-range", march.AddDate(1, 0, 0)),
+		clearTestRecord("unknown", time.Time{}),
+	} {
+		if err := s.syncDB.Insert(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	filter := ClearFilter{StartBefore: march.AddDate(0, 1, 0)}
+	records, unknownStart, err := s.ClearRecords(testAccount, "dst", filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Src.EventID != "in-range" || unknownStart != 1 {
+		t.Errorf("ClearRecords = %v, %d, want in-range and 1 unknown start", records, unknownStart)
+	}
+
+	// without a range the start doesn't matter
+	records, unknownStart, err = s.ClearRecords(testAccount, "dst", ClearFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || unknownStart != 0 {
+		t.Errorf("ClearRecords = %v, %d, want all records", records, unknownStart)
+	}
+}
+
+func TestDeleteRecordsContinuesAfterFailure(t *testing.T) {
+	records := []syncdb.Record{clearTestRecord("a", time.Time{}), clearTestRecord("b", time.Time{}), clearTestRecord("c", time.Time{})}
+
+	var deleted, reported []string
+	failed, err := deleteRecords(context.Background(), records, func(record syncdb.Record) error {
+		if record.Src.EventID == "a" {
+			return errors.New("forbidden")
+		}
+		deleted = append(deleted, record.Src.EventID)
+		return nil
+	}, func(record syncdb.Record, err error) {
+		reported = append(reported, record.Src.EventID)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed != 1 {
+		t.Errorf("failed = %d, want 1", failed)
+	}
+	if want := []string{"b", "c"}; !reflect.DeepEqual(deleted, want) {
+		t.Errorf("deleted = %v, want %v", deleted, want)
+	}
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(reported, want) {
+		t.Errorf("progress reported %v, want %v", reported, want)
+	}
+}
@@ -0,0 +1,121 @@
+package calendar
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/robertdolca/calendar-sync/clients/calendar/ccommon"
+)
+
+// primaryCalendar is the id Google accepts for the primary calendar.
+const primaryCalendar = "primary"
+
+// ResolveCalendar returns the account and calendar id named by a calendar id,
+// a calendar name or, when no account is given, an alias from the config.
+// Names are matched case-sensitively first, then ignoring case. Values
+// matching no calendar are used as given, they may be ids of calendars the
+// account doesn't list.
+func (s *Manager) ResolveCalendar(ctx context.Context, account, calendar string) (string, string, error) {
+	if account == "" {
+		alias, ok := s.aliases[calendar]
+		if !ok {
+			return "", "", errors.Errorf("no account given and %s is not an alias", calendar)
+		}
+		account, calendar = alias.Account, alias.Calendar
+	}
+
+	// Google accepts primary as is, resolving it would change the id the
+	// synced events are recorded under.
+	if calendar == primaryCalendar && s.isGoogle(account) {
+		return account, calendar, nil
+	}
+
+	// values missing from the cached list are listed again once, until the
+	// list expires
+	id, found, err := s.resolveCalendarID(ctx, account, calendar, false)
+	if err == nil && !found && !s.knownMissing(account, calendar) {
+		id, found, err = s.resolveCalendarID(ctx, account, calendar, true)
+		if err == nil && !found {
+			s.rememberMissing(account, calendar)
+		}
+	}
+	if err != nil {
+		var ambiguous *ambiguousError
+		if errors.As(err, &ambiguous) {
+			return "", "", err
+		}
+		slog.Warn("unable to resolve calendar name", "account", account, "calendar", calendar, "error", err)
+		return account, calendar, nil
+	}
+	if !found {
+		return account, calendar, nil
+	}
+	if id != calendar {
+		slog.Info("resolved calendar", "account", account, "calendar", calendar, "id", id)
+	}
+	return account, id, nil
+}
+
+func (s *Manager) resolveCalendarID(ctx context.Context, account, calendar string, refresh bool) (string, bool, error) {
+	calendars, err := s.AccountCalendars(ctx, account, refresh)
+	if err != nil {
+		return "", false, err
+	}
+
+	var exact, folded []ccommon.CalendarInfo
+	for _, cal := range calendars {
+		if cal.Deleted {
+			continue
+		}
+		if cal.Matches(calendar) {
+			return cal.Id, true, nil
+		}
+		if cal.Summary == calendar {
+			exact = append(exact, cal)
+		} else if strings.EqualFold(cal.Summary, calendar) {
+			folded = append(folded, cal)
+		}
+	}
+
+	candidates := exact
+	if len(candidates) == 0 {
+		candidates = folded
+	}
+	switch len(candidates) {
+	case 0:
+		return "", false, nil
+	case 1:
+		return candidates[0].Id, true, nil
+	}
+	return "", false, &ambiguousError{account: account, calendar: calendar, candidates: candidates}
+}
+
+// ambiguousError is returned when a name matches several calendars.
+type ambiguousError struct {
+	account    string
+	calendar   string
+	candidates []ccommon.CalendarInfo
+}
+
+func (e *ambiguousError) Error() string {
+	names := make([]string, 0, len(e.candidates))
+	for _, cal := range e.candidates {
+		names = append(names, fmt.Sprintf("%s (%s)", cal.Summary, cal.Id))
+	}
+	return fmt.Sprintf("calendar %s of %s matches %d calendars, use the id of one of: %s",
+		e.calendar, e.account, len(e.candidates), strings.Join(names, ", "))
+}
+
+func (s *Manager) isGoogle(account string) bool {
+	if _, ok := s.tokenManager.CalDAVAccount(account); ok {
+		return false
+	}
+	if _, ok := s.tokenManager.GraphAccount(account); ok {
+		return false
+	}
+	return true
+}
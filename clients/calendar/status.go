@@ -0,0 +1,81 @@
+package calendar
+
+import (
+	"context"
+
+	"github.com/robertdolca/calendar-sync/clients/calendar/sync"
+	"github.com/robertdolca/calendar-sync/clients/syncdb"
+)
+
+// TokenOK is the token health of accounts that can get a token.
+const TokenOK = "ok"
+
+// PairStatus is what the sync database knows about a sync pair. Pending
+// retries are the failed events that the next run tries again. The token
+// health is TokenOK, the error getting a token, or empty for iCalendar files.
+type PairStatus struct {
+	syncdb.PairStats
+	Failures       int
+	PendingRetries int
+	SrcToken       string
+	DstToken       string
+}
+
+// Status returns the status of every sync pair in the sync database. A
+// token is requested for every account, which refreshes expired tokens.
+func (s *Manager) Status(ctx context.Context) ([]PairStatus, error) {
+	stats, err := s.syncDB.PairStats()
+	if err != nil {
+		return nil, err
+	}
+	failures, err := s.syncDB.ListFailures()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]PairStatus, 0, len(stats))
+	index := make(map[syncdb.Pair]int)
+	for _, pairStats := range stats {
+		index[pairStats.Pair] = len(result)
+		result = append(result, PairStatus{PairStats: pairStats})
+	}
+
+	for _, failure := range failures {
+		pair := syncdb.Pair{
+			SrcAccountEmail: failure.Src.AccountEmail,
+			SrcCalendarID:   failure.Src.CalendarID,
+			DstAccountEmail: failure.DstAccountEmail,
+			DstCalendarID:   failure.DstCalendarID,
+		}
+		i, ok := index[pair]
+		if !ok {
+			i = len(result)
+			index[pair] = i
+			result = append(result, PairStatus{PairStats: syncdb.PairStats{Pair: pair}})
+		}
+		result[i].Failures++
+		if failure.Attempts < sync.MaxFailureAttempts {
+			result[i].PendingRetries++
+		}
+	}
+
+	health := make(map[string]string)
+	tokenHealth := func(account string) string {
+		if account == sync.ICSAccount {
+			return ""
+		}
+		if _, ok := health[account]; !ok {
+			health[account] = TokenOK
+			if err := s.tokenManager.CheckToken(ctx, account); err != nil {
+				health[account] = err.Error()
+			}
+		}
+		return health[account]
+	}
+	for i := range result {
+		result[i].SrcToken = tokenHealth(result[i].SrcAccountEmail)
+		result[i].DstToken = tokenHealth(result[i].DstAccountEmail)
+	}
+
+	return result, nil
+}
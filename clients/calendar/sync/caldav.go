@@ -0,0 +1,313 @@
+package sync
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/calendar/v3"
+
+	"github.com/robertdolca/calendar-sync/clients/caldav"
+	"github.com/robertdolca/calendar-sync/clients/metrics"
+	"github.com/robertdolca/calendar-sync/clients/syncdb"
+)
+
+// caldavSource reads the events of a CalDAV calendar collection. The first
+// run lists all the events, later runs only get the objects changed since
+// the sync token saved by the previous run. Like iCalendar sources, all the
+// events are returned in a single page.
+type caldavSource struct {
+	job        *job
+	client     *caldav.Client
+	collection string
+	// cursor is saved once the run synced all the events
+	cursor syncdb.Cursor
+	events []*calendar.Event
+}
+
+func (c *caldavSource) newCheckpoint() syncdb.Checkpoint {
+	var checkpoint syncdb.Checkpoint
+	if !c.job.request.StartAfter.IsZero() {
+		checkpoint.TimeMin = c.job.request.StartAfter.Format(time.RFC3339)
+	}
+	return checkpoint
+}
+
+func (c *caldavSource) page(syncdb.Checkpoint) (*calendar.Events, error) {
+	if c.events == nil {
+		events, err := c.load()
+		if err != nil {
+			return nil, err
+		}
+		c.events = events
+	}
+	return &calendar.Events{Items: c.events}, nil
+}
+
+func (c *caldavSource) get(eventID string) (*calendar.Event, error) {
+	// the UID of an instance is the part of its ID before the original start
+	uids := []string{eventID}
+	if i := strings.LastIndex(eventID, "_"); i > 0 {
+		uids = append(uids, eventID[:i])
+	}
+
+	for _, uid := range uids {
+		if err := c.job.wait(); err != nil {
+			return nil, err
+		}
+		var objects []caldav.Object
+		err := c.job.call("caldav.query", func(ctx context.Context) (err error) {
+			objects, err = c.client.QueryUID(ctx, c.collection, uid)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range objects {
+			events, err := caldav.ObjectEvents(object)
+			if err != nil {
+				return nil, err
+			}
+			for _, event := range events {
+				if event.Id == eventID {
+					return event, nil
+				}
+			}
+		}
+	}
+	return cancelledEvent(eventID), nil
+}
+
+func (c *caldavSource) resumable() bool {
+	return false
+}
+
+func (c *caldavSource) finish() error {
+	if c.cursor.Token == "" {
+		return nil
+	}
+	return c.job.syncDB.SaveCursor(c.job.srcEvent(""), c.job.request.DstAccountEmail, c.job.request.DstCalendarID, c.cursor)
+}
+
+func (c *caldavSource) load() ([]*calendar.Event, error) {
+	cursor, err := c.job.syncDB.FindCursor(c.job.srcEvent(""), c.job.request.DstAccountEmail, c.job.request.DstCalendarID)
+	if err != nil && err != syncdb.ErrNotFound {
+		return nil, errors.Wrap(err, "failed to read sync cursor")
+	}
+
+	if cursor.Token != "" && !c.job.request.Restart {
+		events, err := c.changes(cursor)
+		if err == nil || !caldav.IsInvalidSyncToken(err) {
+			return events, err
+		}
+		c.job.logger.Warn("sync token rejected, listing all events", "error", err)
+	}
+
+	return c.all()
+}
+
+// all lists every event of the collection. Synced events that are no longer
+// in the collection are returned as cancelled.
+func (c *caldavSource) all() ([]*calendar.Event, error) {
+	// the token is read first so that changes made while listing are synced
+	// by the next run
+	var token string
+	err := c.job.call("caldav.sync_token", func(ctx context.Context) (err error) {
+		token, err = c.client.SyncToken(ctx, c.collection)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []caldav.Object
+	err = c.job.call("caldav.query", func(ctx context.Context) (err error) {
+		objects, err = c.client.Query(ctx, c.collection)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := c.job.syncDB.ListPair(c.job.srcEvent(""), c.job.request.DstAccountEmail, c.job.request.DstCalendarID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list sync records")
+	}
+
+	resources := make(map[string][]string, len(objects))
+	present := make(map[string]bool)
+	var events []*calendar.Event
+	for _, object := range objects {
+		objectEvents := c.parse(object)
+		for _, event := range objectEvents {
+			present[event.Id] = true
+			resources[object.Href] = append(resources[object.Href], event.Id)
+			if !endsBefore(event, c.job.request.StartAfter) {
+				events = append(events, event)
+			}
+		}
+	}
+	for _, record := range records {
+		if !present[record.Src.EventID] {
+			events = append(events, cancelledEvent(record.Src.EventID))
+		}
+	}
+
+	c.cursor = syncdb.Cursor{Token: token, Resources: resources}
+	return events, nil
+}
+
+// changes returns the events of the objects changed since the cursor. The
+// events of removed objects, and the instances removed from changed objects,
+// are returned as cancelled.
+func (c *caldavSource) changes(cursor syncdb.Cursor) ([]*calendar.Event, error) {
+	var changes caldav.Changes
+	err := c.job.call("caldav.sync_collection", func(ctx context.Context) (err error) {
+		changes, err = c.client.SyncCollection(ctx, c.collection, cursor.Token)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []caldav.Object
+	if len(changes.Changed) > 0 {
+		if err := c.job.wait(); err != nil {
+			return nil, err
+		}
+		err = c.job.call("caldav.multiget", func(ctx context.Context) (err error) {
+			objects, err = c.client.Multiget(ctx, c.collection, changes.Changed)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	resources := make(map[string][]string, len(cursor.Resources))
+	for href, ids := range cursor.Resources {
+		resources[href] = ids
+	}
+
+	var events []*calendar.Event
+	removed := append([]string{}, changes.Removed...)
+	fetched := make(map[string]bool, len(objects))
+	for _, object := range objects {
+		fetched[object.Href] = true
+
+		present := make(map[string]bool)
+		var ids []string
+		for _, event := range c.parse(object) {
+			present[event.Id] = true
+			ids = append(ids, event.Id)
+			if !endsBefore(event, c.job.request.StartAfter) {
+				events = append(events, event)
+			}
+		}
+		for _, id := range resources[object.Href] {
+			if !present[id] {
+				events = append(events, cancelledEvent(id))
+			}
+		}
+		resources[object.Href] = ids
+	}
+	// objects removed after the changes were listed
+	for _, href := range changes.Changed {
+		if !fetched[href] {
+			removed = append(removed, href)
+		}
+	}
+	for _, href := range removed {
+		for _, id := range resources[href] {
+			events = append(events, cancelledEvent(id))
+		}
+		delete(resources, href)
+	}
+
+	c.cursor = syncdb.Cursor{Token: changes.Token, Resources: resources}
+	return events, nil
+}
+
+// parse returns the events of an object. Invalid objects are skipped so
+// they don't prevent the other events from being synced.
+func (c *caldavSource) parse(object caldav.Object) []*calendar.Event {
+	events, err := caldav.ObjectEvents(object)
+	if err != nil {
+		c.job.logger.Warn("skipping invalid calendar object", "href", object.Href, "error", err)
+		return nil
+	}
+	return events
+}
+
+type caldavDestination struct {
+	job    *job
+	events *caldav.Events
+}
+
+func (c *caldavDestination) insert(event *calendar.Event) (string, error) {
+	if err := c.job.wait(); err != nil {
+		return "", err
+	}
+	// with the UID chosen before the first attempt a retried insert whose
+	// first attempt went through finds the object instead of creating a
+	// second copy
+	if event.RecurringEventId == "" {
+		uid, err := caldav.NewUID()
+		if err != nil {
+			return "", err
+		}
+		event.Id = uid
+	}
+
+	var eventID string
+	attempts := 0
+	err := c.job.call("caldav.insert", func(ctx context.Context) (err error) {
+		attempts++
+		eventID, err = c.events.Insert(ctx, event)
+		if attempts > 1 && event.RecurringEventId == "" && caldav.IsPreconditionFailed(err) {
+			eventID, err = event.Id, nil
+		}
+		return err
+	})
+	return eventID, err
+}
+
+func (c *caldavDestination) update(eventID string, event *calendar.Event) error {
+	if err := c.job.wait(); err != nil {
+		return err
+	}
+	return c.job.call("caldav.update", func(ctx context.Context) error {
+		_, err := c.events.Update(ctx, eventID, event)
+		return err
+	})
+}
+
+func (c *caldavDestination) delete(r syncdb.Record, softDelete bool) error {
+	if err := c.job.wait(); err != nil {
+		return err
+	}
+	err := c.job.call("caldav.delete", func(ctx context.Context) error {
+		return c.events.Delete(ctx, r.Dst.EventID)
+	})
+	metrics.Operation(c.job.pair, "delete", err)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete event")
+	}
+	if softDelete {
+		return c.job.syncDB.SoftDelete(r)
+	}
+	return c.job.syncDB.Delete(r)
+}
+
+func (c *caldavDestination) deleteInstance(recurringEventID string, originalStart *calendar.EventDateTime) (bool, error) {
+	if err := c.job.wait(); err != nil {
+		return false, err
+	}
+	var deleted bool
+	err := c.job.call("caldav.delete_instance", func(ctx context.Context) (err error) {
+		deleted, err = c.events.DeleteInstance(ctx, recurringEventID, originalStart)
+		return err
+	})
+	return deleted, err
+}
@@ -0,0 +1,62 @@
+package sync
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robertdolca/calendar-sync/clients/caldav"
+	"github.com/robertdolca/calendar-sync/clients/retry"
+)
+
+func TestCalDAVDestinationInsertRetry(t *testing.T) {
+	// the first PUT is stored but its response is lost
+	objects := make(map[string]bool)
+	puts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		puts++
+		if r.Header.Get("If-None-Match") != "*" {
+			t.Errorf("object created without If-None-Match")
+		}
+		if objects[r.URL.Path] {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		objects[r.URL.Path] = true
+		if puts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	client, err := caldav.New(server.Client(), server.URL, "user", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := newTestJob(context.Background(), nil, nil, nil)
+	j.retrier = retry.New(retry.Policy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond, Budget: 10}, nil)
+	dst := &caldavDestination{job: j, events: client.Events("/calendars/user/work/")}
+
+	id, err := dst.insert(singleEvent("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if puts != 2 || len(objects) != 1 {
+		t.Fatalf("%d puts created %d objects, want a single object", puts, len(objects))
+	}
+	for path := range objects {
+		if !strings.HasSuffix(path, "/"+id+".ics") {
+			t.Errorf("insert returned %s for the object %s", id, path)
+		}
+	}
+}
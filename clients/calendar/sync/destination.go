@@ -0,0 +1,145 @@
+package sync
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+
+	"github.com/robertdolca/calendar-sync/clients/caldav"
+	"github.com/robertdolca/calendar-sync/clients/calendar/ccommon"
+	"github.com/robertdolca/calendar-sync/clients/graph"
+	"github.com/robertdolca/calendar-sync/clients/syncdb"
+)
+
+// destination writes the copies of the source events of a sync pair.
+type destination interface {
+	// insert creates a copy and returns its ID. The ID is returned with the
+	// error when the copy was created but couldn't be completed, so that it
+	// is recorded and fixed by the update of a later run.
+	insert(event *calendar.Event) (string, error)
+	update(eventID string, event *calendar.Event) error
+	// delete removes the copy of a record and the record, which is only
+	// soft deleted when asked.
+	delete(r syncdb.Record, softDelete bool) error
+	// deleteInstance removes an instance of a recurring copy. It reports
+	// false when there is no such instance.
+	deleteInstance(recurringEventID string, originalStart *calendar.EventDateTime) (bool, error)
+}
+
+// isNotFound reports whether a destination call failed because the event or
+// its recurring event doesn't exist.
+func isNotFound(err error) bool {
+	if apiErr, ok := errors.Cause(err).(*googleapi.Error); ok {
+		return apiErr.Code == ccommon.ErrCodeNotFound
+	}
+	return caldav.IsNotFound(err) || graph.IsNotFound(err)
+}
+
+type googleDestination struct {
+	job     *job
+	service *calendar.Service
+}
+
+func (g *googleDestination) insert(event *calendar.Event) (string, error) {
+	if err := g.job.wait(); err != nil {
+		return "", err
+	}
+	// with a client generated id a retried insert whose first attempt went
+	// through fails with a conflict instead of creating a second copy
+	id, err := newEventID()
+	if err != nil {
+		return "", err
+	}
+	event.Id = id
+
+	attempts := 0
+	err = g.job.call("calendar.events.insert", func(ctx context.Context) error {
+		attempts++
+		_, err := g.service.Events.Insert(g.job.request.DstCalendarID, event).Context(ctx).Do()
+		if attempts > 1 && isConflict(err) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// newEventID returns a random event id, made of the base32hex characters
+// Google accepts in ids.
+func newEventID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", errors.Wrap(err, "failed to generate event id")
+	}
+	return hex.EncodeToString(id), nil
+}
+
+func isConflict(err error) bool {
+	apiErr, ok := errors.Cause(err).(*googleapi.Error)
+	return ok && apiErr.Code == http.StatusConflict
+}
+
+func (g *googleDestination) update(eventID string, event *calendar.Event) error {
+	if err := g.job.wait(); err != nil {
+		return err
+	}
+	return g.job.call("calendar.events.update", func(ctx context.Context) error {
+		_, err := g.service.Events.Update(g.job.request.DstCalendarID, eventID, event).Context(ctx).Do()
+		return err
+	})
+}
+
+func (g *googleDestination) delete(r syncdb.Record, softDelete bool) error {
+	if err := g.job.wait(); err != nil {
+		return err
+	}
+	return ccommon.DeleteDstEvent(g.job.ctx, g.job.syncDB, g.service, g.job.retrier, r, softDelete)
+}
+
+func (g *googleDestination) deleteInstance(recurringEventID string, originalStart *calendar.EventDateTime) (bool, error) {
+	start := originalStart.DateTime
+	if start == "" {
+		start = originalStart.Date
+	}
+
+	if err := g.job.wait(); err != nil {
+		return false, err
+	}
+	var instances *calendar.Events
+	err := g.job.call("calendar.events.instances", func(ctx context.Context) (err error) {
+		instances, err = g.service.Events.
+			Instances(g.job.request.DstCalendarID, recurringEventID).
+			OriginalStart(start).
+			MaxResults(1).
+			Context(ctx).
+			Do()
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if len(instances.Items) == 0 {
+		return false, nil
+	}
+
+	instance := instances.Items[0]
+	if err := g.job.wait(); err != nil {
+		return false, err
+	}
+	err = g.job.call("calendar.events.delete", func(ctx context.Context) error {
+		return g.service.Events.Delete(g.job.request.DstCalendarID, instance.Id).Context(ctx).Do()
+	})
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to delete event")
+	}
+	return true, nil
+}
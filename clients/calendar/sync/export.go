@@ -0,0 +1,85 @@
+package sync
+
+import (
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/api/calendar/v3"
+
+	"github.com/robertdolca/calendar-sync/clients/calendar/ccommon"
+	"github.com/robertdolca/calendar-sync/clients/ics"
+	"github.com/robertdolca/calendar-sync/clients/metrics"
+)
+
+// export writes the filtered and mapped source events to the destination
+// iCalendar file. The file is regenerated from all the source events on
+// every run, so exported events are reported as created.
+func (s *job) export() error {
+	checkpoint := s.src.newCheckpoint()
+	// every event is needed to regenerate the file
+	checkpoint.UpdatedMin = ""
+
+	var events []*calendar.Event
+	for {
+		page, err := s.src.page(checkpoint)
+		if err != nil {
+			return errors.Wrap(err, "unable to list events")
+		}
+
+		for _, srcEvent := range page.Items {
+			event, o := s.exportEvent(srcEvent)
+			if event != nil {
+				events = append(events, event)
+			}
+			s.report.add(o)
+			metrics.Event(s.pair, o.String())
+		}
+
+		if page.NextPageToken == "" {
+			break
+		}
+		checkpoint.PageToken = page.NextPageToken
+		if err := s.wait(); err != nil {
+			return err
+		}
+	}
+
+	return s.within("ics.write", func(trace.Span) error {
+		cal, err := ics.Calendar(events)
+		if err != nil {
+			return errors.Wrap(err, "unable to build calendar")
+		}
+		if err := ics.WriteFile(s.request.DstICSPath, cal); err != nil {
+			return err
+		}
+		s.logger.Info("wrote calendar file", "path", s.request.DstICSPath, "events", len(events))
+		return nil
+	})
+}
+
+// exportEvent returns the mapped copy of a source event or nil when it is
+// not exported. Cancelled and excluded instances of recurring events are
+// returned as cancelled so they are left out of their recurring event.
+func (s *job) exportEvent(srcEvent *calendar.Event) (*calendar.Event, outcome) {
+	reason := SkipReasonCancelled
+	if srcEvent.Status != ccommon.EventStatusCancelled {
+		reason = s.exclusionReason(srcEvent)
+	}
+
+	if reason != "" {
+		if srcEvent.RecurringEventId == "" {
+			return nil, skipped(reason)
+		}
+		return &calendar.Event{
+			Id:                srcEvent.Id,
+			RecurringEventId:  srcEvent.RecurringEventId,
+			OriginalStartTime: srcEvent.OriginalStartTime,
+			Status:            ccommon.EventStatusCancelled,
+		}, skipped(reason)
+	}
+
+	event := mapEvent(srcEvent, s.request.MappingOptions)
+	event.Id = srcEvent.Id
+	event.RecurringEventId = srcEvent.RecurringEventId
+	event.Updated = srcEvent.Updated
+	return event, outcome{action: actionCreated}
+}
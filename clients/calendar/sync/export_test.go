@@ -0,0 +1,140 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/calendar/v3"
+
+	"github.com/robertdolca/calendar-sync/clients/ics"
+)
+
+// exportFile exports the events of src and returns the written file.
+func exportFile(t *testing.T, src source, path string) string {
+	t.Helper()
+	job := newTestJob(context.Background(), nil, src, nil)
+	job.request.DstICSPath = path
+	job.request.MappingOptions = MappingOptions{CopyDescription: true, CopyLocation: true}
+	if err := job.export(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// parseExport parses an exported file and returns its events by ID.
+func parseExport(t *testing.T, data string) map[string]*calendar.Event {
+	t.Helper()
+	cal, err := ics.Parse(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := ics.Events(cal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byID := make(map[string]*calendar.Event, len(events))
+	for _, event := range events {
+		byID[event.Id] = event
+	}
+	return byID
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	long := singleEvent("long")
+	long.Summary = strings.Repeat("Sommerfest im Großen Saal ", 6)
+	long.Description = "Agenda: food, drinks; music\nBring a \\ backslash\r\nand a second line"
+	long.Location = "Hall 1, floor 2; east wing"
+
+	weekly := &calendar.Event{
+		Id:         "weekly",
+		Summary:    "Weekly",
+		Start:      &calendar.EventDateTime{DateTime: "2030-01-01T10:00:00+01:00", TimeZone: "Europe/Berlin"},
+		End:        &calendar.EventDateTime{DateTime: "2030-01-01T11:00:00+01:00", TimeZone: "Europe/Berlin"},
+		Recurrence: []string{"RRULE:FREQ=WEEKLY;COUNT=10"},
+	}
+	moved := &calendar.Event{
+		Id:                "weekly_20300108T090000Z",
+		RecurringEventId:  "weekly",
+		Summary:           "Moved",
+		Start:             &calendar.EventDateTime{DateTime: "2030-01-08T14:00:00+01:00", TimeZone: "Europe/Berlin"},
+		End:               &calendar.EventDateTime{DateTime: "2030-01-08T15:00:00+01:00", TimeZone: "Europe/Berlin"},
+		OriginalStartTime: &calendar.EventDateTime{DateTime: "2030-01-08T10:00:00+01:00", TimeZone: "Europe/Berlin"},
+	}
+	cancelled := &calendar.Event{
+		Id:                "weekly_20300115T090000Z",
+		RecurringEventId:  "weekly",
+		Status:            "cancelled",
+		OriginalStartTime: &calendar.EventDateTime{DateTime: "2030-01-15T10:00:00+01:00", TimeZone: "Europe/Berlin"},
+	}
+
+	src := &fakeSource{events: []*calendar.Event{long, weekly, moved, cancelled, singleEvent("gone")}}
+	path := filepath.Join(t.TempDir(), "calendar.ics")
+	data := exportFile(t, src, path)
+
+	lines := strings.Split(strings.TrimSuffix(data, "\r\n"), "\r\n")
+	folded := false
+	for _, line := range lines {
+		if len(line) > 75 {
+			t.Errorf("line of %d octets: %q", len(line), line)
+		}
+		if strings.HasPrefix(line, " ") {
+			folded = true
+		}
+	}
+	if !folded {
+		t.Error("long lines are not folded")
+	}
+
+	events := parseExport(t, data)
+	if len(events) != 4 {
+		t.Fatalf("exported %d events, want 4", len(events))
+	}
+
+	got := events["long"]
+	if got.Summary != long.Summary || got.Location != long.Location {
+		t.Errorf("summary, location = %q, %q", got.Summary, got.Location)
+	}
+	if want := strings.ReplaceAll(long.Description, "\r\n", "\n"); got.Description != want {
+		t.Errorf("description = %q, want %q", got.Description, want)
+	}
+
+	wantRecurrence := []string{"RRULE:FREQ=WEEKLY;COUNT=10", "EXDATE:20300115T090000Z"}
+	if got := events["weekly"]; !reflect.DeepEqual(got.Recurrence, wantRecurrence) {
+		t.Errorf("recurrence = %q, want %q", got.Recurrence, wantRecurrence)
+	}
+	if got := events["weekly"]; !reflect.DeepEqual(got.Start, weekly.Start) {
+		t.Errorf("recurring start = %+v, want %+v", got.Start, weekly.Start)
+	}
+
+	override := events[moved.Id]
+	if override == nil {
+		t.Fatalf("override missing from %v", events)
+	}
+	if override.RecurringEventId != "weekly" || override.Summary != "Moved" ||
+		!reflect.DeepEqual(override.Start, moved.Start) ||
+		!reflect.DeepEqual(override.OriginalStartTime, moved.OriginalStartTime) {
+		t.Errorf("override = %+v", override)
+	}
+	if _, ok := events[cancelled.Id]; ok {
+		t.Error("cancelled instance exported as an override")
+	}
+
+	// the file is regenerated, so events removed from the source disappear
+	src.events = []*calendar.Event{long, weekly, moved, cancelled}
+	data = exportFile(t, src, path)
+	if strings.Contains(data, "UID:gone") {
+		t.Error("removed event is still in the file")
+	}
+	events = parseExport(t, data)
+	if _, ok := events["gone"]; ok || len(events) != 3 {
+		t.Errorf("events after removal = %v", events)
+	}
+}
@@ -0,0 +1,455 @@
+package sync
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/calendar/v3"
+
+	"github.com/robertdolca/calendar-sync/clients/calendar/ccommon"
+	"github.com/robertdolca/calendar-sync/clients/graph"
+	"github.com/robertdolca/calendar-sync/clients/metrics"
+	"github.com/robertdolca/calendar-sync/clients/syncdb"
+)
+
+const (
+	// graphHistory is how far back Microsoft calendars are listed when the
+	// request has no start.
+	graphHistory = 365 * 24 * time.Hour
+	// graphHorizon is how far ahead Microsoft calendars are listed. The
+	// range is extended by listing all the events again once less than
+	// graphHorizonRenewal is left.
+	graphHorizon        = 3 * 365 * 24 * time.Hour
+	graphHorizonRenewal = 365 * 24 * time.Hour
+)
+
+// graphSource reads the events of a Microsoft calendar. The API only reports
+// changes within a time range, as occurrences of recurring events instead of
+// the series, so the series masters of the changed occurrences are fetched
+// and the occurrences are tracked in the cursor to detect removals. Like
+// iCalendar sources, all the events are returned in a single page.
+type graphSource struct {
+	job        *job
+	client     *graph.Client
+	calendarID string
+	// cursor is saved once the run synced all the events
+	cursor syncdb.Cursor
+	events []*calendar.Event
+}
+
+func (g *graphSource) newCheckpoint() syncdb.Checkpoint {
+	var checkpoint syncdb.Checkpoint
+	if !g.job.request.StartAfter.IsZero() {
+		checkpoint.TimeMin = g.job.request.StartAfter.Format(time.RFC3339)
+	}
+	return checkpoint
+}
+
+func (g *graphSource) page(syncdb.Checkpoint) (*calendar.Events, error) {
+	if g.events == nil {
+		events, err := g.load()
+		if err != nil {
+			return nil, err
+		}
+		g.events = events
+	}
+	return &calendar.Events{Items: g.events}, nil
+}
+
+func (g *graphSource) get(eventID string) (*calendar.Event, error) {
+	if err := g.job.wait(); err != nil {
+		return nil, err
+	}
+	var event graph.Event
+	err := g.job.call("graph.events.get", func(ctx context.Context) (err error) {
+		event, err = g.client.Event(ctx, eventID)
+		return err
+	})
+	if graph.IsNotFound(err) {
+		return cancelledEvent(eventID), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return graph.ToCalendar(event)
+}
+
+func (g *graphSource) resumable() bool {
+	return false
+}
+
+func (g *graphSource) finish() error {
+	if g.cursor.Token == "" {
+		return nil
+	}
+	return g.job.syncDB.SaveCursor(g.job.srcEvent(""), g.job.request.DstAccountEmail, g.job.request.DstCalendarID, g.cursor)
+}
+
+func (g *graphSource) load() ([]*calendar.Event, error) {
+	cursor, err := g.job.syncDB.FindCursor(g.job.srcEvent(""), g.job.request.DstAccountEmail, g.job.request.DstCalendarID)
+	if err != nil && err != syncdb.ErrNotFound {
+		return nil, errors.Wrap(err, "failed to read sync cursor")
+	}
+	resume := cursor.Token != "" && !g.job.request.Restart
+
+	now := time.Now()
+	if resume && cursor.TimeMax.Sub(now) > graphHorizonRenewal {
+		events, err := g.changes(cursor)
+		if err == nil || !graph.IsSyncStateInvalid(err) {
+			return events, err
+		}
+		g.job.logger.Warn("delta link rejected, listing all events", "error", err)
+	}
+
+	timeMin := now.Add(-graphHistory)
+	if !g.job.request.StartAfter.IsZero() {
+		timeMin = g.job.request.StartAfter
+	}
+	if resume {
+		timeMin = cursor.TimeMin
+	}
+	return g.all(cursor, timeMin, now.Add(graphHorizon))
+}
+
+// all lists the events in the time range. The events of the previous cursor
+// that are no longer listed are removed, which is only known when the range
+// covers the previous one.
+func (g *graphSource) all(previous syncdb.Cursor, timeMin, timeMax time.Time) ([]*calendar.Event, error) {
+	var delta graph.Delta
+	err := g.job.call("graph.events.delta", func(ctx context.Context) (err error) {
+		delta, err = g.client.Delta(ctx, g.calendarID, timeMin, timeMax, "")
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resources := make(map[string][]string)
+	items := delta.Events
+	if previous.Token != "" && !timeMin.After(previous.TimeMin) && !timeMax.Before(previous.TimeMax) {
+		listed := make(map[string]bool, len(items))
+		for _, item := range items {
+			listed[item.ID] = true
+			listed[item.SeriesMasterID] = true
+		}
+		for id, ids := range previous.Resources {
+			if !listed[id] {
+				resources[id] = ids
+				items = append(items, graph.Event{ID: id, Removed: &graph.Removed{Reason: "deleted"}})
+			}
+		}
+	}
+
+	events, err := g.process(items, resources)
+	if err != nil {
+		return nil, err
+	}
+	g.cursor = syncdb.Cursor{Token: delta.Link, Resources: resources, TimeMin: timeMin, TimeMax: timeMax}
+	return events, nil
+}
+
+// changes returns the events changed since the cursor.
+func (g *graphSource) changes(cursor syncdb.Cursor) ([]*calendar.Event, error) {
+	var delta graph.Delta
+	err := g.job.call("graph.events.delta", func(ctx context.Context) (err error) {
+		delta, err = g.client.Delta(ctx, g.calendarID, cursor.TimeMin, cursor.TimeMax, cursor.Token)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resources := make(map[string][]string, len(cursor.Resources))
+	for id, ids := range cursor.Resources {
+		resources[id] = ids
+	}
+
+	events, err := g.process(delta.Events, resources)
+	if err != nil {
+		return nil, err
+	}
+	g.cursor = syncdb.Cursor{Token: delta.Link, Resources: resources, TimeMin: cursor.TimeMin, TimeMax: cursor.TimeMax}
+	return events, nil
+}
+
+// process converts the listed events and updates the resources, which map
+// the listed events to nothing and the occurrences to their series master ID
+// and original start. The series masters are returned first so that their
+// exceptions can be mapped, and the removed events last.
+func (g *graphSource) process(items []graph.Event, resources map[string][]string) ([]*calendar.Event, error) {
+	var (
+		instances        []*calendar.Event
+		masterIDs        []string
+		masters          = make(map[string]bool)
+		removedEvents    = make(map[string]bool)
+		removedInstances = make(map[string][]string)
+	)
+	addMaster := func(id string) {
+		if !masters[id] {
+			masters[id] = true
+			masterIDs = append(masterIDs, id)
+		}
+	}
+
+	for _, item := range items {
+		if item.Removed != nil {
+			ids, ok := resources[item.ID]
+			if !ok {
+				continue
+			}
+			if len(ids) == 2 {
+				removedInstances[ids[0]] = append(removedInstances[ids[0]], item.ID)
+			} else {
+				removedEvents[item.ID] = true
+			}
+			continue
+		}
+
+		switch item.Type {
+		case graph.TypeOccurrence, graph.TypeException:
+			addMaster(item.SeriesMasterID)
+			resources[item.ID] = []string{item.SeriesMasterID, item.OriginalStart}
+			// occurrences are synced with their series
+			if item.Type == graph.TypeOccurrence {
+				continue
+			}
+		case graph.TypeSeriesMaster:
+			addMaster(item.ID)
+			continue
+		default:
+			resources[item.ID] = nil
+		}
+
+		if event := g.convert(item); event != nil {
+			instances = append(instances, event)
+		}
+	}
+
+	// the series of removed occurrences is fetched to find out whether it
+	// was removed as a whole
+	for _, id := range sortedKeys(removedInstances) {
+		addMaster(id)
+	}
+
+	var events []*calendar.Event
+	fetched := make(map[string]graph.Event, len(masterIDs))
+	for _, id := range masterIDs {
+		if removedEvents[id] {
+			continue
+		}
+		if err := g.job.wait(); err != nil {
+			return nil, err
+		}
+		var master graph.Event
+		err := g.job.call("graph.events.get", func(ctx context.Context) (err error) {
+			master, err = g.client.Event(ctx, id)
+			return err
+		})
+		if graph.IsNotFound(err) {
+			removedEvents[id] = true
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		fetched[id] = master
+		resources[id] = nil
+		if event := g.convert(master); event != nil {
+			events = append(events, event)
+		}
+	}
+	events = append(events, instances...)
+
+	for _, masterID := range sortedKeys(removedInstances) {
+		master, ok := fetched[masterID]
+		for _, id := range removedInstances[masterID] {
+			originalStart := resources[id][1]
+			delete(resources, id)
+			if !ok {
+				continue
+			}
+			start, err := master.InstanceStart(originalStart)
+			if err != nil {
+				g.job.logger.Warn("skipping removed occurrence", "event_id", id, "error", err)
+				continue
+			}
+			events = append(events, &calendar.Event{
+				Id:                id,
+				RecurringEventId:  masterID,
+				OriginalStartTime: start,
+				Status:            ccommon.EventStatusCancelled,
+			})
+		}
+	}
+
+	for _, id := range sortedKeys(removedEvents) {
+		events = append(events, cancelledEvent(id))
+		delete(resources, id)
+		for other, ids := range resources {
+			if len(ids) == 2 && ids[0] == id {
+				delete(resources, other)
+			}
+		}
+	}
+
+	return events, nil
+}
+
+// convert returns nil for events that can't be converted, so they don't
+// prevent the other events from being synced.
+func (g *graphSource) convert(item graph.Event) *calendar.Event {
+	event, err := graph.ToCalendar(item)
+	if err != nil {
+		g.job.logger.Warn("skipping invalid event", "event_id", item.ID, "error", err)
+		return nil
+	}
+	return event
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type graphDestination struct {
+	job    *job
+	client *graph.Client
+}
+
+func (g *graphDestination) insert(event *calendar.Event) (string, error) {
+	// exceptions are created by updating the occurrence
+	if event.RecurringEventId != "" {
+		instance, found, err := g.findInstance(event.RecurringEventId, event.OriginalStartTime)
+		if err != nil {
+			return "", err
+		}
+		if !found {
+			return "", errors.Errorf("recurring event has no instance starting at %s%s", event.OriginalStartTime.Date, event.OriginalStartTime.DateTime)
+		}
+		if err := g.update(instance.ID, event); err != nil {
+			return "", err
+		}
+		return instance.ID, nil
+	}
+
+	e, err := graph.FromCalendar(event)
+	if err != nil {
+		return "", err
+	}
+	// a retried create whose first attempt went through returns the event
+	// created by it
+	if e.TransactionID, err = newEventID(); err != nil {
+		return "", err
+	}
+
+	if err := g.job.wait(); err != nil {
+		return "", err
+	}
+	var created graph.Event
+	err = g.job.call("graph.events.create", func(ctx context.Context) (err error) {
+		created, err = g.client.CreateEvent(ctx, g.job.request.DstCalendarID, e)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := g.exclude(created.ID, event.Recurrence); err != nil {
+		return created.ID, errors.Wrap(err, "failed to exclude instances of created event")
+	}
+	return created.ID, nil
+}
+
+func (g *graphDestination) update(eventID string, event *calendar.Event) error {
+	e, err := graph.FromCalendar(event)
+	if err != nil {
+		return err
+	}
+
+	if err := g.job.wait(); err != nil {
+		return err
+	}
+	err = g.job.call("graph.events.update", func(ctx context.Context) error {
+		return g.client.UpdateEvent(ctx, eventID, e)
+	})
+	if err != nil {
+		return err
+	}
+	return g.exclude(eventID, event.Recurrence)
+}
+
+func (g *graphDestination) delete(r syncdb.Record, softDelete bool) error {
+	if err := g.job.wait(); err != nil {
+		return err
+	}
+	err := g.job.call("graph.events.delete", func(ctx context.Context) error {
+		return g.client.DeleteEvent(ctx, r.Dst.EventID)
+	})
+	if graph.IsNotFound(err) {
+		err = nil
+	}
+	metrics.Operation(g.job.pair, "delete", err)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete event")
+	}
+	if softDelete {
+		return g.job.syncDB.SoftDelete(r)
+	}
+	return g.job.syncDB.Delete(r)
+}
+
+func (g *graphDestination) deleteInstance(recurringEventID string, originalStart *calendar.EventDateTime) (bool, error) {
+	instance, found, err := g.findInstance(recurringEventID, originalStart)
+	if graph.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil || !found {
+		return false, err
+	}
+
+	if err := g.job.wait(); err != nil {
+		return false, err
+	}
+	err = g.job.call("graph.events.delete", func(ctx context.Context) error {
+		return g.client.DeleteEvent(ctx, instance.ID)
+	})
+	if err != nil {
+		return false, errors.Wrap(err, "failed to delete event")
+	}
+	return true, nil
+}
+
+func (g *graphDestination) findInstance(recurringEventID string, originalStart *calendar.EventDateTime) (graph.Event, bool, error) {
+	if err := g.job.wait(); err != nil {
+		return graph.Event{}, false, err
+	}
+	var (
+		instance graph.Event
+		found    bool
+	)
+	err := g.job.call("graph.events.instances", func(ctx context.Context) (err error) {
+		instance, found, err = g.client.FindInstance(ctx, recurringEventID, originalStart)
+		return err
+	})
+	return instance, found, err
+}
+
+// exclude deletes the occurrences of the dates excluded by the recurrence,
+// which the API can't represent.
+func (g *graphDestination) exclude(eventID string, recurrence []string) error {
+	starts, err := graph.ExcludedStarts(recurrence)
+	if err != nil {
+		return err
+	}
+	for _, start := range starts {
+		if _, err := g.deleteInstance(eventID, start); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,410 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/api/calendar/v3"
+
+	"github.com/robertdolca/calendar-sync/clients/graph"
+	"github.com/robertdolca/calendar-sync/clients/retry"
+	"github.com/robertdolca/calendar-sync/clients/syncdb"
+)
+
+const testGraphCalendar = "cal"
+
+// fakeGraph serves a calendar view delta query of a single calendar, whose
+// delta link returns the changes, and the events by ID.
+type fakeGraph struct {
+	t        *testing.T
+	url      string
+	listed   []graph.Event
+	changed  []graph.Event
+	events   map[string]graph.Event
+	linkCode string
+	requests []string
+}
+
+func (f *fakeGraph) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.requests = append(f.requests, r.URL.Path)
+	switch {
+	case r.URL.Path == "/me/calendars/"+testGraphCalendar+"/calendarView/delta":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"value": f.listed, "@odata.deltaLink": f.url + "/delta/next"})
+	case r.URL.Path == "/delta/next":
+		if f.linkCode != "" {
+			writeJSON(w, http.StatusGone, map[string]interface{}{"error": map[string]string{"code": f.linkCode}})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"value": f.changed, "@odata.deltaLink": f.url + "/delta/after"})
+	case strings.HasPrefix(r.URL.Path, "/me/events/"):
+		event, ok := f.events[strings.TrimPrefix(r.URL.Path, "/me/events/")]
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]interface{}{"error": map[string]string{"code": "ErrorItemNotFound"}})
+			return
+		}
+		writeJSON(w, http.StatusOK, event)
+	default:
+		f.t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func newGraphTestSource(t *testing.T, f *fakeGraph, syncDB *syncdb.DB) *graphSource {
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	f.t = t
+	f.url = server.URL
+
+	client, err := graph.New(server.Client(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j := &job{
+		ctx: context.Background(),
+		request: Request{
+			SrcAccountEmail: "src@example.com",
+			SrcCalendarID:   testGraphCalendar,
+			DstAccountEmail: "dst@example.com",
+			DstCalendarID:   "dst",
+		},
+		syncDB:       syncDB,
+		rateLLimiter: rate.NewLimiter(rate.Inf, 1),
+		retrier:      retry.New(retry.Policy{MaxAttempts: 1}, nil),
+		report:       newReport(),
+		logger:       slog.Default(),
+		failed:       make(map[string]bool),
+		handled:      make(map[string]bool),
+	}
+	return &graphSource{job: j, client: client, calendarID: testGraphCalendar}
+}
+
+// newTestDB opens a sync database in a temporary work directory, which is
+// where the database and its lock file are kept.
+func newTestDB(t *testing.T) *syncdb.DB {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	syncDB, err := syncdb.New()
+	if err != nil {
+		os.Chdir(wd)
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		syncDB.Close()
+		os.Chdir(wd)
+	})
+	return syncDB
+}
+
+func graphEvent(id, eventType, start string) graph.Event {
+	return graph.Event{
+		ID:      id,
+		Subject: id,
+		Type:    eventType,
+		Start:   &graph.DateTimeTimeZone{DateTime: start + ".0000000", TimeZone: "UTC"},
+		End:     &graph.DateTimeTimeZone{DateTime: start + ".0000000", TimeZone: "UTC"},
+	}
+}
+
+func seriesMaster(id, start string) graph.Event {
+	master := graphEvent(id, graph.TypeSeriesMaster, start)
+	master.Recurrence = &graph.PatternedRecurrence{
+		Pattern: graph.RecurrencePattern{Type: "daily", Interval: 1},
+		Range:   graph.RecurrenceRange{Type: "noEnd", StartDate: start[:10], RecurrenceTimeZone: "UTC"},
+	}
+	return master
+}
+
+func removedEvent(id string) graph.Event {
+	return graph.Event{ID: id, Removed: &graph.Removed{Reason: "deleted"}}
+}
+
+func eventIDs(events []*calendar.Event) []string {
+	ids := make([]string, 0, len(events))
+	for _, event := range events {
+		id := event.Id
+		if event.Status == "cancelled" {
+			id += " cancelled"
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestGraphSourceAll(t *testing.T) {
+	occurrence := graphEvent("occ1", graph.TypeOccurrence, "2030-01-02T10:00:00")
+	occurrence.SeriesMasterID = "m1"
+	occurrence.OriginalStart = "2030-01-02T10:00:00Z"
+	exception := graphEvent("exc1", graph.TypeException, "2030-01-03T12:00:00")
+	exception.SeriesMasterID = "m1"
+	exception.OriginalStart = "2030-01-03T10:00:00Z"
+
+	f := &fakeGraph{
+		listed: []graph.Event{graphEvent("keep", graph.TypeSingleInstance, "2030-01-01T10:00:00"), occurrence, exception},
+		events: map[string]graph.Event{"m1": seriesMaster("m1", "2030-01-01T10:00:00")},
+	}
+	g := newGraphTestSource(t, f, nil)
+
+	timeMin := time.Date(2029, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeMax := time.Date(2032, 1, 1, 0, 0, 0, 0, time.UTC)
+	previous := syncdb.Cursor{
+		Token:     "previous",
+		Resources: map[string][]string{"keep": nil, "gone": nil, "m1": nil},
+		TimeMin:   timeMin,
+		TimeMax:   timeMax,
+	}
+
+	events, err := g.all(previous, timeMin, timeMax)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the series first, then the other events, then the removed ones
+	if want := []string{"m1", "keep", "exc1", "gone cancelled"}; !reflect.DeepEqual(eventIDs(events), want) {
+		t.Errorf("events = %v, want %v", eventIDs(events), want)
+	}
+	if g.cursor.Token != f.url+"/delta/next" || !g.cursor.TimeMin.Equal(timeMin) || !g.cursor.TimeMax.Equal(timeMax) {
+		t.Errorf("cursor = %+v", g.cursor)
+	}
+	if want := []string{"exc1", "keep", "m1", "occ1"}; !reflect.DeepEqual(sortedKeys(g.cursor.Resources), want) {
+		t.Errorf("resources = %v, want %v", sortedKeys(g.cursor.Resources), want)
+	}
+	if want := []string{"m1", "2030-01-02T10:00:00Z"}; !reflect.DeepEqual(g.cursor.Resources["occ1"], want) {
+		t.Errorf("occurrence resource = %v, want %v", g.cursor.Resources["occ1"], want)
+	}
+}
+
+func TestGraphSourceAllNarrowerRange(t *testing.T) {
+	f := &fakeGraph{listed: []graph.Event{graphEvent("keep", graph.TypeSingleInstance, "2030-01-01T10:00:00")}}
+	g := newGraphTestSource(t, f, nil)
+
+	previous := syncdb.Cursor{
+		Token:     "previous",
+		Resources: map[string][]string{"keep": nil, "old": nil},
+		TimeMin:   time.Date(2029, 1, 1, 0, 0, 0, 0, time.UTC),
+		TimeMax:   time.Date(2032, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	// events missing from a range that doesn't cover the previous one may
+	// only be out of range
+	events, err := g.all(previous, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), previous.TimeMax)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"keep"}; !reflect.DeepEqual(eventIDs(events), want) {
+		t.Errorf("events = %v, want %v", eventIDs(events), want)
+	}
+}
+
+func TestGraphSourceChanges(t *testing.T) {
+	f := &fakeGraph{
+		changed: []graph.Event{removedEvent("single"), removedEvent("occ2"), removedEvent("m3")},
+		events:  map[string]graph.Event{"m2": seriesMaster("m2", "2030-02-01T10:00:00")},
+	}
+	g := newGraphTestSource(t, f, nil)
+
+	cursor := syncdb.Cursor{
+		Token: f.url + "/delta/next",
+		Resources: map[string][]string{
+			"single": nil,
+			"m2":     nil,
+			"occ2":   {"m2", "2030-02-02T10:00:00.0000000Z"},
+			"m3":     nil,
+			"occ3":   {"m3", "2030-03-02T10:00:00.0000000Z"},
+		},
+		TimeMin: time.Date(2029, 1, 1, 0, 0, 0, 0, time.UTC),
+		TimeMax: time.Date(2032, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	events, err := g.changes(cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"m2", "occ2 cancelled", "m3 cancelled", "single cancelled"}
+	if !reflect.DeepEqual(eventIDs(events), want) {
+		t.Fatalf("events = %v, want %v", eventIDs(events), want)
+	}
+	if events[1].RecurringEventId != "m2" || events[1].OriginalStartTime.DateTime != "2030-02-02T10:00:00Z" {
+		t.Errorf("removed occurrence = %+v, want an instance of m2 at its original start", events[1])
+	}
+	// the occurrences of removed series are forgotten with them
+	if want := []string{"m2"}; !reflect.DeepEqual(sortedKeys(g.cursor.Resources), want) {
+		t.Errorf("resources = %v, want %v", sortedKeys(g.cursor.Resources), want)
+	}
+	if g.cursor.Token != f.url+"/delta/after" {
+		t.Errorf("token = %s", g.cursor.Token)
+	}
+	// changes must not modify the resources of the cursor they start from
+	if len(cursor.Resources) != 5 {
+		t.Errorf("previous cursor resources modified: %v", cursor.Resources)
+	}
+}
+
+func TestGraphSourceLoadFallsBackToAll(t *testing.T) {
+	for _, test := range []struct {
+		code     string
+		fallback bool
+	}{
+		{code: "SyncStateNotFound", fallback: true},
+		{code: "resyncRequired", fallback: true},
+		{code: "ErrorInvalidParameter"},
+	} {
+		t.Run(test.code, func(t *testing.T) {
+			f := &fakeGraph{
+				listed:   []graph.Event{graphEvent("listed", graph.TypeSingleInstance, "2030-01-01T10:00:00")},
+				linkCode: test.code,
+			}
+			g := newGraphTestSource(t, f, newTestDB(t))
+
+			now := time.Now()
+			cursor := syncdb.Cursor{
+				Token:     f.url + "/delta/next",
+				Resources: map[string][]string{},
+				TimeMin:   now.Add(-graphHistory),
+				TimeMax:   now.Add(graphHorizon),
+			}
+			if err := g.job.syncDB.SaveCursor(g.job.srcEvent(""), g.job.request.DstAccountEmail, g.job.request.DstCalendarID, cursor); err != nil {
+				t.Fatal(err)
+			}
+
+			events, err := g.load()
+			if !test.fallback {
+				if err == nil {
+					t.Fatal("unexpected delta link error ignored")
+				}
+				if len(f.requests) != 1 {
+					t.Errorf("requests = %v, want only the delta link", f.requests)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []string{"listed"}; !reflect.DeepEqual(eventIDs(events), want) {
+				t.Errorf("events = %v, want %v", eventIDs(events), want)
+			}
+			if g.cursor.Token != f.url+"/delta/next" || !g.cursor.TimeMin.Equal(cursor.TimeMin) {
+				t.Errorf("cursor = %+v, want a new delta link from the previous start", g.cursor)
+			}
+		})
+	}
+}
+
+// fakeGraphCalendar creates events like the API does, once per transaction
+// id. The response to the first create is lost, and listing instances fails
+// when instancesFail is set.
+type fakeGraphCalendar struct {
+	t             *testing.T
+	created       map[string]string
+	creates       []string
+	instancesFail bool
+}
+
+func (f *fakeGraphCalendar) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/me/calendars/dst/events":
+		var event graph.Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			f.t.Fatal(err)
+		}
+		f.creates = append(f.creates, event.TransactionID)
+		id, ok := f.created[event.TransactionID]
+		if !ok {
+			id = "created" + strconv.Itoa(len(f.created))
+			f.created[event.TransactionID] = id
+		}
+		if len(f.creates) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(w, http.StatusCreated, graph.Event{ID: id})
+	case strings.HasSuffix(r.URL.Path, "/instances") && f.instancesFail:
+		writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": map[string]string{"code": "ErrorInternalServerError"}})
+	default:
+		f.t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newGraphTestDestination(t *testing.T, f *fakeGraphCalendar, syncDB *syncdb.DB) *graphDestination {
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	f.t = t
+	f.created = make(map[string]string)
+
+	client, err := graph.New(server.Client(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := newTestJob(context.Background(), syncDB, nil, nil)
+	j.retrier = retry.New(retry.Policy{MaxAttempts: 2, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond, Budget: 10}, nil)
+	dst := &graphDestination{job: j, client: client}
+	j.dst = dst
+	return dst
+}
+
+func TestGraphDestinationInsertRetry(t *testing.T) {
+	f := &fakeGraphCalendar{}
+	dst := newGraphTestDestination(t, f, nil)
+
+	id, err := dst.insert(singleEvent("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.creates) != 2 || f.creates[0] == "" || f.creates[0] != f.creates[1] {
+		t.Fatalf("creates with transaction ids %q, want the same id twice", f.creates)
+	}
+	if len(f.created) != 1 || id != f.created[f.creates[0]] {
+		t.Errorf("insert returned %s, created %v", id, f.created)
+	}
+}
+
+func TestGraphDestinationInsertMapsSeriesWhenExclusionFails(t *testing.T) {
+	f := &fakeGraphCalendar{instancesFail: true}
+	dst := newGraphTestDestination(t, f, newTestDB(t))
+	j := dst.job
+
+	series := singleEvent("series")
+	series.Recurrence = []string{"RRULE:FREQ=DAILY", "EXDATE:20300102T100000Z"}
+	if err := j.syncEventIsolated(series); err != nil {
+		t.Fatal(err)
+	}
+	if j.report.Failed != 1 {
+		t.Errorf("failed = %d, want the exclusion failure reported", j.report.Failed)
+	}
+
+	r, err := j.syncDB.Find(j.srcEvent("series"), j.request.DstAccountEmail, j.request.DstCalendarID, false)
+	if err != nil {
+		t.Fatalf("created series not mapped: %v", err)
+	}
+	if want := f.created[f.creates[0]]; r.Dst.EventID != want {
+		t.Errorf("mapped to %s, want %s", r.Dst.EventID, want)
+	}
+	failures, err := j.syncDB.ListFailures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failures) != 1 || failures[0].Src.EventID != "series" {
+		t.Errorf("failures = %+v, want the series to be retried", failures)
+	}
+}
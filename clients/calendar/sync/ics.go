@@ -0,0 +1,243 @@
+package sync
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/calendar/v3"
+
+	"github.com/robertdolca/calendar-sync/clients/ics"
+	"github.com/robertdolca/calendar-sync/clients/syncdb"
+	"github.com/robertdolca/calendar-sync/clients/tracing"
+)
+
+const feedTimeout = time.Minute
+
+// ICSAccount is the source account of the sync records of iCalendar sources.
+// The source calendar ID is the path of the calendar file or the ID of the
+// feed returned by FeedCalendarID.
+const ICSAccount = "ics"
+
+// FeedCalendarID identifies a calendar feed without revealing its URL, which
+// often contains a secret token, in the sync database, logs and metrics.
+func FeedCalendarID(feedURL string) string {
+	sum := sha256.Sum256([]byte(feedURL))
+	id := hex.EncodeToString(sum[:8])
+	if u, err := url.Parse(feedURL); err == nil && u.Hostname() != "" {
+		return u.Hostname() + "/" + id
+	}
+	return id
+}
+
+// icsSource reads the events of an iCalendar file or feed. The calendar is a
+// full snapshot, so every run lists all its events in a single page followed
+// by cancelled placeholders for the synced events that are no longer in it.
+// Only one of path and url is set.
+type icsSource struct {
+	job    *job
+	path   string
+	url    string
+	client *http.Client
+	events []*calendar.Event
+}
+
+func (i *icsSource) newCheckpoint() syncdb.Checkpoint {
+	return syncdb.Checkpoint{}
+}
+
+func (i *icsSource) page(syncdb.Checkpoint) (*calendar.Events, error) {
+	events, err := i.load()
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := i.job.syncDB.ListPair(
+		i.job.srcEvent(""),
+		i.job.request.DstAccountEmail,
+		i.job.request.DstCalendarID,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list sync records")
+	}
+
+	present := make(map[string]bool, len(events))
+	items := make([]*calendar.Event, 0, len(events))
+	for _, event := range events {
+		present[event.Id] = true
+		if !endsBefore(event, i.job.request.StartAfter) {
+			items = append(items, event)
+		}
+	}
+	for _, record := range records {
+		if !present[record.Src.EventID] {
+			items = append(items, cancelledEvent(record.Src.EventID))
+		}
+	}
+
+	return &calendar.Events{Items: items}, nil
+}
+
+func (i *icsSource) get(eventID string) (*calendar.Event, error) {
+	events, err := i.load()
+	if err != nil {
+		return nil, err
+	}
+	for _, event := range events {
+		if event.Id == eventID {
+			return event, nil
+		}
+	}
+	return cancelledEvent(eventID), nil
+}
+
+func (i *icsSource) resumable() bool {
+	return false
+}
+
+func (i *icsSource) finish() error {
+	return nil
+}
+
+// load parses the calendar once per run.
+func (i *icsSource) load() ([]*calendar.Event, error) {
+	if i.events != nil {
+		return i.events, nil
+	}
+
+	var (
+		events []*calendar.Event
+		err    error
+	)
+	if i.url != "" {
+		events, err = i.loadURL()
+	} else {
+		events, err = i.loadFile()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	i.events = events
+	return events, nil
+}
+
+func (i *icsSource) loadFile() ([]*calendar.Event, error) {
+	file, err := os.Open(i.path)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open calendar file")
+	}
+	defer file.Close()
+
+	return parseICS(file)
+}
+
+// loadURL fetches the feed, reusing the previously fetched version when the
+// server reports that it was not modified.
+func (i *icsSource) loadURL() ([]*calendar.Event, error) {
+	id := i.job.request.SrcCalendarID
+	feed, err := i.job.syncDB.FindFeed(id)
+	if err != nil && err != syncdb.ErrNotFound {
+		return nil, errors.Wrap(err, "failed to read cached calendar")
+	}
+
+	ctx, span := tracing.Start(i.job.ctx, "ics.fetch")
+	result, err := ics.Fetch(ctx, i.client, i.url, feed.ETag, feed.LastModified)
+	tracing.End(span, err)
+	i.job.report.APICalls++
+	if err != nil {
+		return nil, err
+	}
+
+	if result.NotModified {
+		i.job.logger.Debug("calendar not modified", "fetched_at", feed.FetchedAt)
+	} else {
+		feed = syncdb.Feed{
+			ETag:         result.ETag,
+			LastModified: result.LastModified,
+			Body:         result.Body,
+			FetchedAt:    time.Now(),
+		}
+		if err := i.job.syncDB.SaveFeed(id, feed); err != nil {
+			return nil, errors.Wrap(err, "failed to cache calendar")
+		}
+	}
+
+	return parseICS(bytes.NewReader(feed.Body))
+}
+
+// migrateFeedRecords moves the records saved when feeds were identified by
+// their URL to the ID of the feed, and drops the rest of the state kept under
+// the URL. Failed events are listed again by the next run.
+func migrateFeedRecords(job *job) error {
+	legacy := syncdb.Event{AccountEmail: ICSAccount, CalendarID: job.request.SrcICSURL}
+	dstAccountEmail, dstCalendarID := job.request.DstAccountEmail, job.request.DstCalendarID
+
+	failures, err := job.syncDB.ListFailures()
+	if err != nil {
+		return err
+	}
+	for _, f := range failures {
+		if f.Src.AccountEmail != legacy.AccountEmail || f.Src.CalendarID != legacy.CalendarID ||
+			f.DstAccountEmail != dstAccountEmail || f.DstCalendarID != dstCalendarID {
+			continue
+		}
+		if err := job.syncDB.ClearFailure(f.Src, dstAccountEmail, dstCalendarID); err != nil {
+			return err
+		}
+	}
+
+	records, err := job.syncDB.ListPair(legacy, dstAccountEmail, dstCalendarID)
+	if err != nil {
+		return errors.Wrap(err, "failed to list sync records")
+	}
+
+	for _, r := range records {
+		if err := job.syncDB.Move(r, job.srcEvent(r.Src.EventID)); err != nil {
+			return errors.Wrap(err, "failed to migrate sync record")
+		}
+	}
+	if len(records) > 0 {
+		job.logger.Info("migrated feed sync records", "records", len(records))
+	}
+	if err := job.syncDB.DeleteRun(legacy, dstAccountEmail, dstCalendarID); err != nil {
+		return err
+	}
+	return job.syncDB.DeleteCheckpoint(legacy, dstAccountEmail, dstCalendarID)
+}
+
+func parseICS(r io.Reader) ([]*calendar.Event, error) {
+	cal, err := ics.Parse(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse calendar")
+	}
+
+	events, err := ics.Events(cal)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read calendar events")
+	}
+	if events == nil {
+		events = []*calendar.Event{}
+	}
+	return events, nil
+}
+
+// endsBefore reports whether a single event ended before t. Recurring events
+// are always kept since their instances can end later.
+func endsBefore(event *calendar.Event, t time.Time) bool {
+	if t.IsZero() || event.Recurrence != nil || event.End == nil {
+		return false
+	}
+	if event.End.Date != "" {
+		end, err := time.Parse("2006-01-02", event.End.Date)
+		return err == nil && end.Before(t)
+	}
+	end, err := time.Parse(time.RFC3339, event.End.DateTime)
+	return err == nil && end.Before(t)
+}
@@ -2,21 +2,26 @@ package sync
 
 import (
 	"context"
-	"log"
+	"fmt"
+	"log/slog"
+	"net/http"
 	"regexp"
 	"strings"
 	"time"
 
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/time/rate"
 	"google.golang.org/api/calendar/v3"
-	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 
 	"github.com/robertdolca/calendar-sync/clients/calendar/ccommon"
+	"github.com/robertdolca/calendar-sync/clients/metrics"
+	"github.com/robertdolca/calendar-sync/clients/retry"
 	"github.com/robertdolca/calendar-sync/clients/syncdb"
 	"github.com/robertdolca/calendar-sync/clients/tmanager"
-	"github.com/robertdolca/calendar-sync/clients/userinfo"
+	"github.com/robertdolca/calendar-sync/clients/tracing"
 )
 
 type Request struct {
@@ -32,6 +37,15 @@ type Request struct {
 	UpdateInterval      time.Duration
 	StartAfter          time.Time
 	MappingOptions      MappingOptions
+	// Restart ignores the checkpoint left by an interrupted run.
+	Restart bool
+	// SrcICSPath and SrcICSURL replace the source account and calendar with
+	// an iCalendar file or feed when set. Only one of them can be set.
+	SrcICSPath string
+	SrcICSURL  string
+	// DstICSPath replaces the destination account and calendar with an
+	// iCalendar file that is regenerated on every run when set.
+	DstICSPath string
 }
 
 type MappingOptions struct {
@@ -42,93 +56,502 @@ type MappingOptions struct {
 	CopyColor       bool
 }
 
+const (
+	defaultRateInterval = 350 * time.Millisecond
+	maxRateInterval     = 5 * time.Second
+)
+
+// MaxFailureAttempts is the number of runs a failed event is retried in
+// before it is only reported by the failures command.
+const MaxFailureAttempts = 10
+
 type job struct {
 	ctx          context.Context
 	request      Request
-	srcService   *calendar.Service
-	dstService   *calendar.Service
+	pair         string
+	src          source
+	dst          destination
 	syncDB       *syncdb.DB
 	rateLLimiter *rate.Limiter
+	retrier      *retry.Retrier
+	report       *Report
+	logger       *slog.Logger
+	// failed holds the ids of the source events of this pair that have a
+	// failure record, so successful syncs only clear records that exist.
+	failed map[string]bool
+	// handled holds the ids of the source events already synced in this run.
+	handled map[string]bool
 }
 
 func RunSync(
 	ctx context.Context,
 	syncDB *syncdb.DB,
 	tokenManager *tmanager.Manager,
-	userInfo *userinfo.Manager,
 	request Request,
-) error {
-	calendarTokens, err := ccommon.UsersCalendarsTokens(ctx, userInfo, tokenManager)
+) (report Report, err error) {
+	if request.SrcICSPath != "" {
+		request.SrcAccountEmail = ICSAccount
+		request.SrcCalendarID = request.SrcICSPath
+	}
+	if request.SrcICSURL != "" {
+		request.SrcAccountEmail = ICSAccount
+		request.SrcCalendarID = FeedCalendarID(request.SrcICSURL)
+	}
+	if request.DstICSPath != "" {
+		request.DstAccountEmail = ICSAccount
+		request.DstCalendarID = request.DstICSPath
+	}
+
+	pair := ccommon.PairName(request.SrcAccountEmail, request.SrcCalendarID, request.DstAccountEmail, request.DstCalendarID)
+
+	ctx, span := tracing.Start(ctx, "sync.run", attribute.String("pair", pair))
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	start := time.Now()
+	defer func() {
+		saveRun(syncDB, request, start, report, err)
+	}()
+
+	job := &job{
+		ctx:          ctx,
+		request:      request,
+		syncDB:       syncDB,
+		rateLLimiter: rate.NewLimiter(rate.Every(defaultRateInterval), 1),
+		report:       newReport(),
+		pair:         pair,
+		logger:       slog.With("pair", pair),
+		failed:       make(map[string]bool),
+		handled:      make(map[string]bool),
+	}
+	job.retrier = retry.New(retry.DefaultPolicy, job.slowDown)
+
+	accounts := &accounts{ctx: ctx, tokenManager: tokenManager}
+
+	job.src, err = newSource(job, accounts)
 	if err != nil {
-		return err
+		return Report{}, errors.Wrap(err, "source account")
 	}
 
-	srcToken := ccommon.FindToken(calendarTokens, request.SrcAccountEmail)
-	if srcToken == nil {
-		return errors.New("source account not authenticated")
+	dstICS := request.DstICSPath != ""
+	if !dstICS {
+		job.dst, err = newDestination(job, accounts)
+		if err != nil {
+			return Report{}, errors.Wrap(err, "destination account")
+		}
 	}
 
-	dstToken := ccommon.FindToken(calendarTokens, request.DstAccountEmail)
-	if dstToken == nil {
-		return errors.New("source account not authenticated")
+	if dstICS {
+		err = job.export()
+	} else {
+		err = job.run()
+	}
+	job.report.Duration = time.Since(start)
+	job.report.APICalls += job.retrier.Calls()
+	metrics.Run(pair, job.report.Duration, err)
+
+	job.logger.Info(
+		"sync run finished",
+		"created", job.report.Created,
+		"updated", job.report.Updated,
+		"unchanged", job.report.Unchanged,
+		"deleted", job.report.Deleted,
+		"skipped", job.report.SkippedTotal(),
+		"failed", job.report.Failed,
+		"api_calls", job.report.APICalls,
+		"duration", job.report.Duration,
+	)
+
+	return *job.report, err
+}
+
+// saveRun records the outcome of the run for the status command. Runs that
+// fail before syncing, for example because of an expired token, are recorded
+// too.
+func saveRun(syncDB *syncdb.DB, request Request, start time.Time, report Report, err error) {
+	run := syncdb.Run{
+		Src:             syncdb.Event{AccountEmail: request.SrcAccountEmail, CalendarID: request.SrcCalendarID},
+		DstAccountEmail: request.DstAccountEmail,
+		DstCalendarID:   request.DstCalendarID,
+		StartedAt:       start,
+		FinishedAt:      time.Now(),
+		Outcome:         syncdb.RunSucceeded,
+	}
+	var failedEvents *failedEventsError
+	switch {
+	case errors.Is(err, context.Canceled):
+		run.Outcome = syncdb.RunInterrupted
+	case errors.As(err, &failedEvents):
+		run.Outcome = syncdb.RunPartial
+	case err != nil:
+		run.Outcome = syncdb.RunFailed
+	}
+	if err != nil {
+		run.Error = err.Error()
+	}
+
+	if err := syncDB.SaveRun(run); err != nil {
+		slog.Warn("failed to save sync run", "error", err)
+	}
+}
+
+func newSource(job *job, accounts *accounts) (source, error) {
+	request := job.request
+	if request.SrcICSURL != "" {
+		if err := migrateFeedRecords(job); err != nil {
+			return nil, err
+		}
+	}
+	if request.SrcICSPath != "" || request.SrcICSURL != "" {
+		return &icsSource{
+			job:    job,
+			path:   request.SrcICSPath,
+			url:    request.SrcICSURL,
+			client: &http.Client{Timeout: feedTimeout},
+		}, nil
 	}
 
-	config := tokenManager.Config()
+	if account, ok := accounts.tokenManager.CalDAVAccount(request.SrcAccountEmail); ok {
+		client, err := ccommon.CalDAVClient(account)
+		if err != nil {
+			return nil, err
+		}
+		return &caldavSource{job: job, client: client, collection: request.SrcCalendarID}, nil
+	}
 
-	srcService, err := calendar.NewService(ctx, option.WithTokenSource(config.TokenSource(ctx, srcToken)))
+	if account, ok := accounts.tokenManager.GraphAccount(request.SrcAccountEmail); ok {
+		client, err := ccommon.GraphClient(job.ctx, accounts.tokenManager, account)
+		if err != nil {
+			return nil, err
+		}
+		return &graphSource{job: job, client: client, calendarID: request.SrcCalendarID}, nil
+	}
+
+	service, err := accounts.googleService(request.SrcAccountEmail)
 	if err != nil {
-		return errors.Wrap(err, "unable to create calendar client")
+		return nil, err
+	}
+	return &googleSource{job: job, service: service}, nil
+}
+
+func newDestination(job *job, accounts *accounts) (destination, error) {
+	request := job.request
+	if account, ok := accounts.tokenManager.CalDAVAccount(request.DstAccountEmail); ok {
+		client, err := ccommon.CalDAVClient(account)
+		if err != nil {
+			return nil, err
+		}
+		return &caldavDestination{job: job, events: client.Events(request.DstCalendarID)}, nil
 	}
 
-	dstService, err := calendar.NewService(ctx, option.WithTokenSource(config.TokenSource(ctx, dstToken)))
+	if account, ok := accounts.tokenManager.GraphAccount(request.DstAccountEmail); ok {
+		client, err := ccommon.GraphClient(job.ctx, accounts.tokenManager, account)
+		if err != nil {
+			return nil, err
+		}
+		return &graphDestination{job: job, client: client}, nil
+	}
+
+	service, err := accounts.googleService(request.DstAccountEmail)
 	if err != nil {
-		return errors.Wrap(err, "unable to create calendar client")
+		return nil, err
 	}
+	return &googleDestination{job: job, service: service}, nil
+}
 
-	job := &job{
-		ctx:          ctx,
-		request:      request,
-		syncDB:       syncDB,
-		srcService:   srcService,
-		dstService:   dstService,
-		rateLLimiter: rate.NewLimiter(rate.Every(350*time.Millisecond), 1),
+// accounts creates the clients of the accounts of a sync pair.
+type accounts struct {
+	ctx          context.Context
+	tokenManager *tmanager.Manager
+}
+
+func (a *accounts) googleService(accountEmail string) (*calendar.Service, error) {
+	tokenSource, err := a.tokenManager.GoogleTokenSource(a.ctx, accountEmail)
+	if err != nil {
+		return nil, err
 	}
 
-	return job.run()
+	service, err := calendar.NewService(a.ctx, option.WithTokenSource(tokenSource))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create calendar client")
+	}
+	return service, nil
+}
+
+// wait blocks until the rate limiter allows the next API call.
+func (s *job) wait() error {
+	ctx, span := tracing.Start(s.ctx, "rate_limiter.wait")
+	start := time.Now()
+	err := s.rateLLimiter.Wait(ctx)
+	metrics.RateLimiterWait(s.pair, time.Since(start))
+	tracing.End(span, err)
+	return err
+}
+
+// call runs a calendar API call in its own span, retrying failures.
+func (s *job) call(name string, fn func(ctx context.Context) error) error {
+	ctx, span := tracing.Start(s.ctx, name)
+	err := s.retrier.Do(ctx, func() error {
+		return fn(ctx)
+	})
+	tracing.End(span, err)
+	return err
+}
+
+// within runs fn with s.ctx carrying a new span, so that the spans started
+// by fn are nested under it.
+func (s *job) within(name string, fn func(span trace.Span) error, attributes ...attribute.KeyValue) error {
+	parent := s.ctx
+	ctx, span := tracing.Start(parent, name, attributes...)
+	s.ctx = ctx
+	err := fn(span)
+	s.ctx = parent
+	tracing.End(span, err)
+	return err
+}
+
+// slowDown halves the request rate every time the API reports that a quota
+// was exceeded, down to one request every maxRateInterval.
+func (s *job) slowDown() {
+	limit := s.rateLLimiter.Limit() / 2
+	if limit < rate.Every(maxRateInterval) {
+		limit = rate.Every(maxRateInterval)
+	}
+	s.logger.Warn("quota exceeded, slowing down", "requests_per_second", float64(limit))
+	s.rateLLimiter.SetLimit(limit)
 }
 
 func (s *job) run() error {
-	call := s.srcService.Events.
-		List(s.request.SrcCalendarID).
-		OrderBy("updated")
+	if err := s.retryFailures(); err != nil {
+		return errors.Wrap(err, "unable to retry failed events")
+	}
 
-	if s.request.UpdateInterval != 0 {
-		call = call.UpdatedMin(time.Now().Add(-s.request.UpdateInterval).Format(time.RFC3339))
+	checkpoint, resumed, err := s.loadCheckpoint()
+	if err != nil {
+		return err
 	}
 
-	if !s.request.StartAfter.IsZero() {
-		call = call.TimeMin(s.request.StartAfter.Format(time.RFC3339))
+	err = s.syncPages(&checkpoint)
+	if err != nil && resumed && s.ctx.Err() == nil && isInvalidPageToken(err) {
+		s.logger.Warn("checkpoint page token rejected, restarting", "error", err)
+		checkpoint = s.src.newCheckpoint()
+		err = s.syncPages(&checkpoint)
+	}
+	if err != nil {
+		return errors.Wrap(err, "unable to sync events")
 	}
 
-	err := call.Pages(s.ctx, s.syncEvents)
+	if err := s.syncDB.DeleteCheckpoint(s.srcEvent(""), s.request.DstAccountEmail, s.request.DstCalendarID); err != nil {
+		return err
+	}
+
+	if err := s.src.finish(); err != nil {
+		return err
+	}
+
+	if s.report.Failed > 0 {
+		return &failedEventsError{count: s.report.Failed}
+	}
+	return nil
+}
+
+// failedEventsError is returned by runs that synced every event except the
+// ones that failed.
+type failedEventsError struct {
+	count int
+}
+
+func (e *failedEventsError) Error() string {
+	return fmt.Sprintf("failed to sync %d events, run the failures command for details", e.count)
+}
+
+// loadCheckpoint returns the checkpoint of an interrupted run of this pair,
+// or a new one if there is none, the request asks for a restart or the
+// source is not resumable.
+func (s *job) loadCheckpoint() (syncdb.Checkpoint, bool, error) {
+	if s.request.Restart || !s.src.resumable() {
+		return s.src.newCheckpoint(), false, nil
+	}
+
+	fresh := s.src.newCheckpoint()
+	checkpoint, err := s.syncDB.FindCheckpoint(s.srcEvent(""), s.request.DstAccountEmail, s.request.DstCalendarID)
+	if err == syncdb.ErrNotFound {
+		return fresh, false, nil
+	}
+	if err != nil {
+		return syncdb.Checkpoint{}, false, errors.Wrap(err, "failed to read checkpoint")
+	}
+
+	// page tokens are only valid for the query that produced them
+	if checkpoint.UpdateInterval != fresh.UpdateInterval || checkpoint.TimeMin != fresh.TimeMin {
+		s.logger.Info("discarding checkpoint of a run with different options", "saved_at", checkpoint.SavedAt)
+		return fresh, false, nil
+	}
+
+	s.logger.Info(
+		"resuming interrupted run",
+		"saved_at", checkpoint.SavedAt,
+		"last_event_id", checkpoint.LastEventID,
+	)
+	return checkpoint, true, nil
+}
 
-	return errors.Wrap(err, "unable to sync events")
+func (s *job) saveCheckpoint(checkpoint syncdb.Checkpoint) error {
+	return s.syncDB.SaveCheckpoint(s.srcEvent(""), s.request.DstAccountEmail, s.request.DstCalendarID, checkpoint)
 }
 
-func (s *job) syncEvents(events *calendar.Events) error {
-	for _, srcEvent := range events.Items {
-		if err := s.syncEvent(srcEvent); err != nil {
+// syncPages lists the source events page by page starting from the
+// checkpoint, which is saved after every page and when the run is
+// interrupted in the middle of one if the source is resumable.
+func (s *job) syncPages(checkpoint *syncdb.Checkpoint) error {
+	for {
+		events, err := s.src.page(*checkpoint)
+		if err != nil {
+			return err
+		}
+
+		if err := s.syncEvents(events, checkpoint); err != nil {
+			if checkpoint.LastEventID != "" && s.src.resumable() {
+				if err := s.saveCheckpoint(*checkpoint); err != nil {
+					s.logger.Error("failed to save checkpoint", "error", err)
+				}
+			}
+			return err
+		}
+
+		if events.NextPageToken == "" {
+			return nil
+		}
+
+		checkpoint.PageToken = events.NextPageToken
+		checkpoint.LastEventID = ""
+		if err := s.saveCheckpoint(*checkpoint); err != nil {
 			return err
 		}
 	}
-	// wait for a slot before next page
-	if err := s.rateLLimiter.Wait(s.ctx); err != nil {
+}
+
+// retryFailures syncs again the events of this pair that failed in previous runs.
+func (s *job) retryFailures() error {
+	failures, err := s.syncDB.ListFailures()
+	if err != nil {
 		return err
 	}
+
+	for _, f := range failures {
+		if f.Src.AccountEmail != s.request.SrcAccountEmail ||
+			f.Src.CalendarID != s.request.SrcCalendarID ||
+			f.DstAccountEmail != s.request.DstAccountEmail ||
+			f.DstCalendarID != s.request.DstCalendarID {
+			continue
+		}
+		s.failed[f.Src.EventID] = true
+		if f.Attempts >= MaxFailureAttempts {
+			continue
+		}
+
+		s.logger.Info("retrying failed event", "operation", "retry", "event_id", f.Src.EventID, "attempts", f.Attempts)
+
+		srcEvent, err := s.src.get(f.Src.EventID)
+		if err != nil {
+			s.handled[f.Src.EventID] = true
+			if err := s.recordFailure(f.Src.EventID, err); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := s.syncEventIsolated(srcEvent); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-func (s *job) syncEvent(srcEvent *calendar.Event) error {
+func (s *job) syncEvents(events *calendar.Events, checkpoint *syncdb.Checkpoint) error {
+	return s.within("sync.page", func(trace.Span) error {
+		for _, srcEvent := range eventsAfter(events.Items, checkpoint.LastEventID) {
+			if err := s.ctx.Err(); err != nil {
+				return err
+			}
+			// events retried from the failure queue are not synced twice
+			if !s.handled[srcEvent.Id] {
+				if err := s.syncEventIsolated(srcEvent); err != nil {
+					return err
+				}
+			}
+			checkpoint.LastEventID = srcEvent.Id
+		}
+		// wait for a slot before next page
+		if err := s.wait(); err != nil {
+			return err
+		}
+		return nil
+	}, attribute.Int("events", len(events.Items)))
+}
+
+// eventsAfter drops the events up to and including the last processed one.
+// All the events are returned if the last processed event is not found.
+func eventsAfter(events []*calendar.Event, lastEventID string) []*calendar.Event {
+	if lastEventID == "" {
+		return events
+	}
+	for i, event := range events {
+		if event.Id == lastEventID {
+			return events[i+1:]
+		}
+	}
+	return events
+}
+
+// syncEventIsolated syncs a single event and records a failure instead of
+// aborting the run when it can't be synced. Only errors that prevent the run
+// from continuing, such as a cancelled context, are returned.
+func (s *job) syncEventIsolated(srcEvent *calendar.Event) error {
+	s.handled[srcEvent.Id] = true
+	return s.within("sync.event", func(span trace.Span) error {
+		o, err := s.syncEvent(srcEvent)
+		if s.ctx.Err() != nil {
+			return s.ctx.Err()
+		}
+		if err != nil {
+			s.logger.Error("failed to sync event", "event_id", srcEvent.Id, "error", err)
+			span.SetAttributes(attribute.String("result", outcomeFailed))
+			span.RecordError(err)
+			metrics.Event(s.pair, outcomeFailed)
+			return s.recordFailure(srcEvent.Id, err)
+		}
+		span.SetAttributes(attribute.String("result", o.String()))
+		if o.reason != "" {
+			span.SetAttributes(attribute.String("skip_reason", o.reason))
+		}
+		s.report.add(o)
+		metrics.Event(s.pair, o.String())
+		if !s.failed[srcEvent.Id] {
+			return nil
+		}
+		delete(s.failed, srcEvent.Id)
+		return s.syncDB.ClearFailure(s.srcEvent(srcEvent.Id), s.request.DstAccountEmail, s.request.DstCalendarID)
+	}, attribute.String("event_id", srcEvent.Id))
+}
+
+func (s *job) recordFailure(eventID string, syncErr error) error {
+	s.report.Failed++
+	s.failed[eventID] = true
+	return s.syncDB.RecordFailure(s.srcEvent(eventID), s.request.DstAccountEmail, s.request.DstCalendarID, syncErr)
+}
+
+func (s *job) srcEvent(eventID string) syncdb.Event {
+	return syncdb.Event{
+		EventID:      eventID,
+		AccountEmail: s.request.SrcAccountEmail,
+		CalendarID:   s.request.SrcCalendarID,
+	}
+}
+
+func (s *job) syncEvent(srcEvent *calendar.Event) (outcome, error) {
 	r, err := s.syncDB.Find(
 		syncdb.Event{
 			EventID:      srcEvent.Id,
@@ -144,26 +567,31 @@ func (s *job) syncEvent(srcEvent *calendar.Event) error {
 			if srcEvent.RecurringEventId != "" {
 				return s.deleteRecurringEventInstance(srcEvent)
 			}
-			return nil
-		} else if s.shouldExclude(srcEvent) {
+			return skipped(SkipReasonCancelled), nil
+		} else if reason := s.exclusionReason(srcEvent); reason != "" {
 			if srcEvent.Recurrence != nil {
-				return s.createExcludedRecurringEvent(srcEvent)
+				return s.createExcludedRecurringEvent(srcEvent, reason)
 			} else if srcEvent.RecurringEventId != "" {
-				return s.deleteRecurringEventInstance(srcEvent)
+				if _, err := s.deleteRecurringEventInstance(srcEvent); err != nil {
+					return outcome{}, err
+				}
 			}
-			return nil
+			return skipped(reason), nil
 		}
 		return s.createEvent(srcEvent, false)
 	}
 	if err != nil {
-		return err
+		return outcome{}, err
 	}
 	return s.syncExistingEvent(srcEvent, r, false)
 }
 
-func (s *job) createExcludedRecurringEvent(event *calendar.Event) error {
-	if err := s.createEvent(event, false); err != nil {
-		return err
+// createExcludedRecurringEvent creates an excluded recurring event and
+// soft deletes it right away, so that the mapping needed to handle its
+// instances exists. It is reported as skipped.
+func (s *job) createExcludedRecurringEvent(event *calendar.Event, reason string) (outcome, error) {
+	if _, err := s.createEvent(event, false); err != nil {
+		return outcome{}, err
 	}
 	r, err := s.syncDB.Find(
 		syncdb.Event{
@@ -176,74 +604,92 @@ func (s *job) createExcludedRecurringEvent(event *calendar.Event) error {
 		false,
 	)
 	if err != nil {
-		return err
+		return outcome{}, err
 	}
-	return s.deleteDstEvent(r, event.Recurrence != nil)
+	if _, err := s.deleteDstEvent(r, event.Recurrence != nil); err != nil {
+		return outcome{}, err
+	}
+	return skipped(reason), nil
 }
 
 func (s *job) shouldExclude(event *calendar.Event) bool {
+	return s.exclusionReason(event) != ""
+}
+
+// exclusionReason returns the reason the event is filtered out by the
+// request or an empty string if the event should be synced.
+func (s *job) exclusionReason(event *calendar.Event) string {
 	responseStatus := eventResponseStatus(event)
 	if !s.request.IncludeNotGoing && responseStatus == "declined" {
-		return true
+		return SkipReasonNotGoing
 	}
 	if !s.request.IncludeTentative && responseStatus == "tentative" {
-		return true
+		return SkipReasonTentative
 	}
 	if !s.request.IncludeNotResponded && responseStatus == "needsAction" {
-		return true
+		return SkipReasonNotResponded
 	}
-	if !s.request.IncludeOutOfOffice && strings.HasPrefix(event.Description, "This is an out-of-office event") {
-		return true
+	if !s.request.IncludeOutOfOffice && (event.EventType == "outOfOffice" || strings.HasPrefix(event.Description, "This is an out-of-office event")) {
+		return SkipReasonOutOfOffice
 	}
 	if s.request.ExcludeTitleRegex != nil && s.request.ExcludeTitleRegex.MatchString(event.Summary) {
-		return true
+		return SkipReasonTitleRegex
 	}
-	return false
+	return ""
 }
 
-func (s *job) createEvent(srcEvent *calendar.Event, isRetry bool) error {
-	log.Printf("creating event: %s, %s\n", srcEvent.Id, srcEvent.RecurringEventId)
+func (s *job) createEvent(srcEvent *calendar.Event, isRetry bool) (outcome, error) {
+	s.logger.Debug("creating event", "operation", "create", "event_id", srcEvent.Id, "recurring_event_id", srcEvent.RecurringEventId)
 
 	mappedRecurringEventId, err := s.mapRecurringEventId(srcEvent.RecurringEventId)
 	if err != nil {
-		return errors.Wrap(err, "failed to map recurring event id")
+		return outcome{}, errors.Wrap(err, "failed to map recurring event id")
 	}
 
 	if srcEvent.RecurringEventId != "" && mappedRecurringEventId == "" {
-		log.Println("skipping recurring event instance for recurring event that does not exist")
-		return nil
+		s.logger.Info(
+			"skipping recurring event instance for recurring event that does not exist",
+			"operation", "create",
+			"event_id", srcEvent.Id,
+			"recurring_event_id", srcEvent.RecurringEventId,
+		)
+		return skipped(SkipReasonMissingRecurringEvent), nil
 	}
 
 	dstEvent := mapEvent(srcEvent, s.request.MappingOptions)
 	dstEvent.RecurringEventId = mappedRecurringEventId
+	hash, err := eventHash(dstEvent)
+	if err != nil {
+		return outcome{}, err
+	}
 
-	if err := s.rateLLimiter.Wait(s.ctx); err != nil {
-		return err
+	dstEventID, err := s.dst.insert(dstEvent)
+	metrics.Operation(s.pair, "insert", err)
+	if err != nil && dstEventID != "" {
+		// the copy exists, retrying the event updates it
+		if err := s.createMapping(srcEvent.Id, dstEventID, hash, eventStart(srcEvent)); err != nil {
+			return outcome{}, err
+		}
+		return outcome{}, err
 	}
-	dstEvent, err = s.dstService.Events.Insert(s.request.DstCalendarID, dstEvent).Do()
 	if err != nil {
 		shouldRetry, err := s.handleRecurringEventMappingIssue(err, srcEvent, isRetry)
 		if shouldRetry {
 			return s.createEvent(srcEvent, true)
 		}
-		return errors.Wrapf(err, "failed to create event")
+		return outcome{}, errors.Wrapf(err, "failed to create event")
 	}
 
-	if err = s.createMapping(srcEvent.Id, dstEvent.Id); err != nil {
-		return err
+	if err = s.createMapping(srcEvent.Id, dstEventID, hash, eventStart(srcEvent)); err != nil {
+		return outcome{}, err
 	}
 
-	log.Printf("created event: %s, %s\n", srcEvent.Id, srcEvent.RecurringEventId)
-	return nil
+	s.logger.Info("created event", "operation", "create", "event_id", srcEvent.Id, "recurring_event_id", srcEvent.RecurringEventId)
+	return outcome{action: actionCreated}, nil
 }
 
 func (s *job) handleRecurringEventMappingIssue(err error, srcEvent *calendar.Event, isRetry bool) (bool, error) {
-	calendarErr, ok := err.(*googleapi.Error)
-	if !ok {
-		return false, err
-	}
-
-	if calendarErr.Code != ccommon.ErrCodeNotFound {
+	if !isNotFound(err) {
 		return false, err
 	}
 
@@ -258,7 +704,7 @@ func (s *job) handleRecurringEventMappingIssue(err error, srcEvent *calendar.Eve
 	return true, nil
 }
 
-func (s *job) createMapping(srcEventId, dstEventId string) error {
+func (s *job) createMapping(srcEventId, dstEventId, hash string, start time.Time) error {
 	record := syncdb.Record{
 		Src: syncdb.Event{
 			EventID:      srcEventId,
@@ -270,6 +716,8 @@ func (s *job) createMapping(srcEventId, dstEventId string) error {
 			AccountEmail: s.request.DstAccountEmail,
 			CalendarID:   s.request.DstCalendarID,
 		},
+		Hash:  hash,
+		Start: start,
 	}
 	if err := s.syncDB.Insert(record); err != nil {
 		return errors.Wrapf(err, "failed to save sync mapping")
@@ -277,8 +725,8 @@ func (s *job) createMapping(srcEventId, dstEventId string) error {
 	return nil
 }
 
-func (s *job) syncExistingEvent(srcEvent *calendar.Event, r syncdb.Record, isRetry bool) error {
-	log.Printf("existing event: %s\n", r.Src.EventID)
+func (s *job) syncExistingEvent(srcEvent *calendar.Event, r syncdb.Record, isRetry bool) (outcome, error) {
+	s.logger.Debug("existing event", "event_id", r.Src.EventID)
 
 	if srcEvent.Status == "cancelled" || s.shouldExclude(srcEvent) {
 		return s.deleteDstEvent(r, srcEvent.Recurrence != nil)
@@ -286,37 +734,53 @@ func (s *job) syncExistingEvent(srcEvent *calendar.Event, r syncdb.Record, isRet
 
 	mappedRecurringEventId, err := s.mapRecurringEventId(srcEvent.RecurringEventId)
 	if err != nil {
-		return errors.Wrap(err, "failed to map recurring event id")
+		return outcome{}, errors.Wrap(err, "failed to map recurring event id")
 	}
 
 	if srcEvent.RecurringEventId != "" && mappedRecurringEventId == "" {
-		return errors.New("cannot sync recurring event instance when recurring event id mapping not found")
+		return outcome{}, errors.New("cannot sync recurring event instance when recurring event id mapping not found")
 	}
 
 	dstEvent := mapEvent(srcEvent, s.request.MappingOptions)
 	dstEvent.RecurringEventId = mappedRecurringEventId
-
-	if err := s.rateLLimiter.Wait(s.ctx); err != nil {
-		return err
+	hash, err := eventHash(dstEvent)
+	if err != nil {
+		return outcome{}, err
 	}
-	if dstEvent, err = s.dstService.Events.Update(s.request.DstCalendarID, r.Dst.EventID, dstEvent).Do(); err != nil {
+
+	// the copy is always updated so edits made to it in the destination are
+	// overwritten, the hash only tells whether the source event changed
+	err = s.dst.update(r.Dst.EventID, dstEvent)
+	metrics.Operation(s.pair, "update", err)
+	if err != nil {
 		shouldRetry, err := s.handleRecurringEventMappingIssue(err, srcEvent, isRetry)
 		if shouldRetry {
 			return s.syncExistingEvent(srcEvent, r, true)
 		}
-		return errors.Wrapf(err, "failed to update event")
+		return outcome{}, errors.Wrapf(err, "failed to update event")
 	}
 
-	log.Printf("updated event: %s\n", srcEvent.Id)
-	return nil
+	unchanged := r.Hash == hash
+	r.Hash = hash
+	r.Start = eventStart(srcEvent)
+	if err := s.syncDB.Insert(r); err != nil {
+		return outcome{}, errors.Wrapf(err, "failed to save sync mapping")
+	}
+
+	if unchanged {
+		s.logger.Debug("refreshed unchanged event", "operation", "update", "event_id", srcEvent.Id)
+		return outcome{action: actionUnchanged}, nil
+	}
+	s.logger.Info("updated event", "operation", "update", "event_id", srcEvent.Id)
+	return outcome{action: actionUpdated}, nil
 }
 
-func (s *job) deleteDstEvent(r syncdb.Record, softDelete bool) error {
-	log.Printf("delete event: %s, %t\n", r.Src.EventID, softDelete)
-	if err := s.rateLLimiter.Wait(s.ctx); err != nil {
-		return err
+func (s *job) deleteDstEvent(r syncdb.Record, softDelete bool) (outcome, error) {
+	s.logger.Info("deleting event", "operation", "delete", "event_id", r.Src.EventID, "soft_delete", softDelete)
+	if err := s.dst.delete(r, softDelete); err != nil {
+		return outcome{}, err
 	}
-	return ccommon.DeleteDstEvent(s.syncDB, s.dstService, r, softDelete)
+	return outcome{action: actionDeleted}, nil
 }
 
 func (s *job) mapRecurringEventId(recurringEventId string) (string, error) {
@@ -356,50 +820,32 @@ func (s *job) deleteRecurringEventId(recurringEventId string) error {
 	return s.syncDB.Delete(r)
 }
 
-func (s *job) deleteRecurringEventInstance(srcEvent *calendar.Event) error {
-	log.Printf("skipping event: %s\n", srcEvent.Id)
+func (s *job) deleteRecurringEventInstance(srcEvent *calendar.Event) (outcome, error) {
+	s.logger.Debug("skipping event", "event_id", srcEvent.Id)
 
 	recurringEventId, err := s.mapRecurringEventId(srcEvent.RecurringEventId)
 	if err != nil {
-		return errors.Wrap(err, "failed to map recurring event id")
+		return outcome{}, errors.Wrap(err, "failed to map recurring event id")
 	}
 
 	// recurring event already deleted
 	if recurringEventId == "" {
-		return nil
+		return skipped(SkipReasonCancelled), nil
 	}
 
-	start := srcEvent.OriginalStartTime.DateTime
-	if start == "" {
-		start = srcEvent.OriginalStartTime.Date
+	deleted, err := s.dst.deleteInstance(recurringEventId, srcEvent.OriginalStartTime)
+	if deleted || err != nil {
+		metrics.Operation(s.pair, "delete", err)
 	}
-
-	if err := s.rateLLimiter.Wait(s.ctx); err != nil {
-		return err
-	}
-	dstInstances, err := s.dstService.Events.
-		Instances(s.request.DstCalendarID, recurringEventId).
-		OriginalStart(start).
-		MaxResults(1).
-		Do()
 	if err != nil {
-		return err
+		return outcome{}, err
 	}
-
-	if len(dstInstances.Items) == 0 {
-		return nil
+	if !deleted {
+		return skipped(SkipReasonCancelled), nil
 	}
 
-	dstEvent := dstInstances.Items[0]
-	if err := s.rateLLimiter.Wait(s.ctx); err != nil {
-		return err
-	}
-	if err := s.dstService.Events.Delete(s.request.DstCalendarID, dstEvent.Id).Do(); err != nil {
-		return errors.Wrapf(err, "failed to delete event")
-	}
-
-	log.Printf("deleted recurring event instance: %s\n", srcEvent.Id)
-	return nil
+	s.logger.Info("deleted recurring event instance", "operation", "delete", "event_id", srcEvent.Id)
+	return outcome{action: actionDeleted}, nil
 }
 
 func eventResponseStatus(event *calendar.Event) string {
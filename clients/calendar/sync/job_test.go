@@ -0,0 +1,194 @@
+package sync
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
+	"google.golang.org/api/calendar/v3"
+
+	"github.com/robertdolca/calendar-sync/clients/retry"
+	"github.com/robertdolca/calendar-sync/clients/syncdb"
+)
+
+// fakeSource returns its events in a single page, like the delta sources.
+type fakeSource struct {
+	events []*calendar.Event
+}
+
+func (f *fakeSource) newCheckpoint() syncdb.Checkpoint {
+	return syncdb.Checkpoint{}
+}
+
+func (f *fakeSource) page(syncdb.Checkpoint) (*calendar.Events, error) {
+	return &calendar.Events{Items: f.events}, nil
+}
+
+func (f *fakeSource) get(eventID string) (*calendar.Event, error) {
+	for _, event := range f.events {
+		if event.Id == eventID {
+			return event, nil
+		}
+	}
+	return cancelledEvent(eventID), nil
+}
+
+func (f *fakeSource) resumable() bool {
+	return false
+}
+
+func (f *fakeSource) finish() error {
+	return nil
+}
+
+// fakeDestination keeps the copies by ID. Inserting the events in fail
+// fails, and interrupt, when set, is called instead of the insert after
+// interruptAfter copies were created.
+type fakeDestination struct {
+	events         map[string]*calendar.Event
+	inserts        int
+	fail           map[string]bool
+	interrupt      func()
+	interruptAfter int
+}
+
+func (f *fakeDestination) insert(event *calendar.Event) (string, error) {
+	if f.interrupt != nil && f.inserts == f.interruptAfter {
+		f.interrupt()
+		return "", context.Canceled
+	}
+	if f.fail[event.Summary] {
+		return "", errors.New("insert refused")
+	}
+	f.inserts++
+	id := event.Summary + "-copy"
+	f.events[id] = event
+	return id, nil
+}
+
+func (f *fakeDestination) update(eventID string, event *calendar.Event) error {
+	f.events[eventID] = event
+	return nil
+}
+
+func (f *fakeDestination) delete(r syncdb.Record, softDelete bool) error {
+	delete(f.events, r.Dst.EventID)
+	return nil
+}
+
+func (f *fakeDestination) deleteInstance(string, *calendar.EventDateTime) (bool, error) {
+	return false, nil
+}
+
+func newTestJob(ctx context.Context, syncDB *syncdb.DB, src source, dst destination) *job {
+	return &job{
+		ctx: ctx,
+		request: Request{
+			SrcAccountEmail:     "src@example.com",
+			SrcCalendarID:       "src",
+			DstAccountEmail:     "dst@example.com",
+			DstCalendarID:       "dst",
+			IncludeNotResponded: true,
+			IncludeTentative:    true,
+		},
+		src:          src,
+		dst:          dst,
+		syncDB:       syncDB,
+		rateLLimiter: rate.NewLimiter(rate.Inf, 1),
+		retrier:      retry.New(retry.Policy{MaxAttempts: 1}, nil),
+		report:       newReport(),
+		logger:       slog.Default(),
+		failed:       make(map[string]bool),
+		handled:      make(map[string]bool),
+	}
+}
+
+func singleEvent(id string) *calendar.Event {
+	return &calendar.Event{
+		Id:      id,
+		Summary: id,
+		Start:   &calendar.EventDateTime{DateTime: "2030-01-01T10:00:00Z"},
+		End:     &calendar.EventDateTime{DateTime: "2030-01-01T11:00:00Z"},
+	}
+}
+
+func sortedCopies(dst *fakeDestination) []string {
+	ids := make([]string, 0, len(dst.events))
+	for id := range dst.events {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestRunResumesSinglePageSourceInAnotherOrder(t *testing.T) {
+	syncDB := newTestDB(t)
+	dst := &fakeDestination{events: make(map[string]*calendar.Event)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	dst.interrupt, dst.interruptAfter = cancel, 1
+	src := &fakeSource{events: []*calendar.Event{singleEvent("a"), singleEvent("b"), singleEvent("c")}}
+	if err := newTestJob(ctx, syncDB, src, dst).run(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("interrupted run = %v, want cancelled", err)
+	}
+	if want := []string{"a-copy"}; !reflect.DeepEqual(sortedCopies(dst), want) {
+		t.Fatalf("copies after the interrupted run = %v, want %v", sortedCopies(dst), want)
+	}
+
+	// the changes are listed again from the same cursor, in another order
+	dst.interrupt = nil
+	src.events = []*calendar.Event{singleEvent("c"), singleEvent("b"), singleEvent("a")}
+	j := newTestJob(context.Background(), syncDB, src, dst)
+	if err := j.run(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a-copy", "b-copy", "c-copy"}; !reflect.DeepEqual(sortedCopies(dst), want) {
+		t.Errorf("copies = %v, want %v", sortedCopies(dst), want)
+	}
+	if dst.inserts != 3 || j.report.Created != 2 || j.report.Unchanged != 1 {
+		t.Errorf("inserts = %d, report = %+v, want every event created once", dst.inserts, *j.report)
+	}
+}
+
+func lastRunOutcome(t *testing.T, syncDB *syncdb.DB) string {
+	stats, err := syncDB.PairStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 || stats[0].LastRun == nil {
+		t.Fatalf("pair stats = %+v, want one pair with a run", stats)
+	}
+	return stats[0].LastRun.Outcome
+}
+
+func TestRunWithFailedEventsIsPartial(t *testing.T) {
+	syncDB := newTestDB(t)
+	dst := &fakeDestination{events: make(map[string]*calendar.Event), fail: map[string]bool{"b": true}}
+	src := &fakeSource{events: []*calendar.Event{singleEvent("a"), singleEvent("b"), singleEvent("c")}}
+	j := newTestJob(context.Background(), syncDB, src, dst)
+
+	start := time.Now()
+	err := j.run()
+	if err == nil {
+		t.Fatal("run with a failed event succeeded")
+	}
+	if j.report.Created != 2 || j.report.Failed != 1 {
+		t.Errorf("report = %+v, want 2 created and 1 failed", *j.report)
+	}
+
+	saveRun(syncDB, j.request, start, *j.report, err)
+	if outcome := lastRunOutcome(t, syncDB); outcome != syncdb.RunPartial {
+		t.Errorf("outcome = %s, want %s", outcome, syncdb.RunPartial)
+	}
+
+	// a run that fails for another reason after events failed is failed
+	saveRun(syncDB, j.request, start, *j.report, errors.Wrap(errors.New("listing failed"), "unable to sync events"))
+	if outcome := lastRunOutcome(t, syncDB); outcome != syncdb.RunFailed {
+		t.Errorf("outcome = %s, want %s", outcome, syncdb.RunFailed)
+	}
+}
@@ -1,9 +1,13 @@
 package sync
 
 import (
+	"time"
+
 	"google.golang.org/api/calendar/v3"
 )
 
+const dateLayout = "2006-01-02"
+
 func mapEvent(event *calendar.Event, mappingOptions MappingOptions) *calendar.Event {
 	if event == nil {
 		return nil
@@ -46,3 +50,23 @@ func mapEventDateTime(dt *calendar.EventDateTime) *calendar.EventDateTime {
 		TimeZone: dt.TimeZone,
 	}
 }
+
+// eventStart returns when the event starts, all day events at midnight UTC
+// and recurring events at their first occurrence. It is zero when the start
+// is missing or invalid.
+func eventStart(event *calendar.Event) time.Time {
+	if event.Start == nil {
+		return time.Time{}
+	}
+	var t time.Time
+	var err error
+	if event.Start.Date != "" {
+		t, err = time.Parse(dateLayout, event.Start.Date)
+	} else {
+		t, err = time.Parse(time.RFC3339, event.Start.DateTime)
+	}
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
@@ -0,0 +1,140 @@
+package sync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/calendar/v3"
+)
+
+const (
+	SkipReasonCancelled             = "cancelled"
+	SkipReasonMissingRecurringEvent = "missing-recurring-event"
+	SkipReasonNotGoing              = "not-going"
+	SkipReasonTentative             = "tentative"
+	SkipReasonNotResponded          = "not-responded"
+	SkipReasonOutOfOffice           = "out-of-office"
+	SkipReasonTitleRegex            = "title-regex"
+)
+
+// SkipReasons are the reasons events are skipped for, in the order the csv
+// report lists them.
+var SkipReasons = []string{
+	SkipReasonCancelled,
+	SkipReasonMissingRecurringEvent,
+	SkipReasonNotGoing,
+	SkipReasonTentative,
+	SkipReasonNotResponded,
+	SkipReasonOutOfOffice,
+	SkipReasonTitleRegex,
+}
+
+// outcomeFailed labels events that could not be synced in metrics.
+const outcomeFailed = "failed"
+
+const (
+	actionCreated action = iota + 1
+	actionUpdated
+	actionUnchanged
+	actionDeleted
+	actionSkipped
+)
+
+// Report summarises what a sync run did.
+type Report struct {
+	Created   int            `json:"created"`
+	Updated   int            `json:"updated"`
+	Unchanged int            `json:"unchanged"`
+	Deleted   int            `json:"deleted"`
+	Skipped   map[string]int `json:"skipped"`
+	Failed    int            `json:"failed"`
+	APICalls  int            `json:"apiCalls"`
+	Duration  time.Duration  `json:"-"`
+}
+
+type action int
+
+// outcome is what happened to a single source event. The reason is only
+// set for skipped events.
+type outcome struct {
+	action action
+	reason string
+}
+
+func newReport() *Report {
+	return &Report{
+		Skipped: make(map[string]int),
+	}
+}
+
+// String names the outcome for metrics, ignoring the skip reason.
+func (o outcome) String() string {
+	switch o.action {
+	case actionCreated:
+		return "created"
+	case actionUpdated:
+		return "updated"
+	case actionUnchanged:
+		return "unchanged"
+	case actionDeleted:
+		return "deleted"
+	case actionSkipped:
+		return "skipped"
+	}
+	return "unknown"
+}
+
+func skipped(reason string) outcome {
+	return outcome{action: actionSkipped, reason: reason}
+}
+
+// MarshalJSON encodes the duration in seconds, which is what monitoring
+// systems usually expect.
+func (r Report) MarshalJSON() ([]byte, error) {
+	type report Report
+	return json.Marshal(struct {
+		report
+		DurationSeconds float64 `json:"durationSeconds"`
+	}{
+		report:          report(r),
+		DurationSeconds: r.Duration.Seconds(),
+	})
+}
+
+// SkippedTotal returns the number of skipped events regardless of the reason.
+func (r Report) SkippedTotal() int {
+	total := 0
+	for _, count := range r.Skipped {
+		total += count
+	}
+	return total
+}
+
+func (r *Report) add(o outcome) {
+	switch o.action {
+	case actionCreated:
+		r.Created++
+	case actionUpdated:
+		r.Updated++
+	case actionUnchanged:
+		r.Unchanged++
+	case actionDeleted:
+		r.Deleted++
+	case actionSkipped:
+		r.Skipped[o.reason]++
+	}
+}
+
+// eventHash fingerprints a mapped event so updates of events that did not
+// change in the source are reported as unchanged.
+func eventHash(event *calendar.Event) (string, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to serialize event")
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
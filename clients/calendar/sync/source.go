@@ -0,0 +1,143 @@
+package sync
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+
+	"github.com/robertdolca/calendar-sync/clients/calendar/ccommon"
+	"github.com/robertdolca/calendar-sync/clients/syncdb"
+)
+
+// source provides the events of the source calendar of a sync pair.
+type source interface {
+	// newCheckpoint returns the starting point of a run from scratch.
+	newCheckpoint() syncdb.Checkpoint
+	// page returns the events of the page the checkpoint points to. The next
+	// page token is empty for the last page.
+	page(checkpoint syncdb.Checkpoint) (*calendar.Events, error)
+	// get returns a single event, or a cancelled placeholder when the event
+	// no longer exists so that its copy is removed.
+	get(eventID string) (*calendar.Event, error)
+	// resumable reports whether interrupted runs continue from their
+	// checkpoint. Sources returning all the events in a single page list
+	// them again, in any order, and rely on the sync records instead.
+	resumable() bool
+	// finish is called once a run synced all the events.
+	finish() error
+}
+
+type googleSource struct {
+	job     *job
+	service *calendar.Service
+}
+
+func (g *googleSource) newCheckpoint() syncdb.Checkpoint {
+	var checkpoint syncdb.Checkpoint
+	if g.job.request.UpdateInterval != 0 {
+		checkpoint.UpdateInterval = g.job.request.UpdateInterval
+		checkpoint.UpdatedMin = time.Now().Add(-g.job.request.UpdateInterval).Format(time.RFC3339)
+	}
+	if !g.job.request.StartAfter.IsZero() {
+		checkpoint.TimeMin = g.job.request.StartAfter.Format(time.RFC3339)
+	}
+	return checkpoint
+}
+
+func (g *googleSource) page(checkpoint syncdb.Checkpoint) (*calendar.Events, error) {
+	call := g.service.Events.
+		List(g.job.request.SrcCalendarID).
+		OrderBy("updated")
+
+	if checkpoint.UpdatedMin != "" {
+		call = call.UpdatedMin(checkpoint.UpdatedMin)
+	}
+
+	if checkpoint.TimeMin != "" {
+		call = call.TimeMin(checkpoint.TimeMin)
+	}
+
+	if checkpoint.PageToken != "" {
+		call = call.PageToken(checkpoint.PageToken)
+	}
+
+	var events *calendar.Events
+	err := g.job.call("calendar.events.list", func(ctx context.Context) (err error) {
+		events, err = call.Context(ctx).Do()
+		return err
+	})
+	return events, err
+}
+
+func (g *googleSource) get(eventID string) (*calendar.Event, error) {
+	if err := g.job.wait(); err != nil {
+		return nil, err
+	}
+
+	var srcEvent *calendar.Event
+	err := g.job.call("calendar.events.get", func(ctx context.Context) (err error) {
+		srcEvent, err = g.service.Events.Get(g.job.request.SrcCalendarID, eventID).Context(ctx).Do()
+		return err
+	})
+	if calendarErr, ok := err.(*googleapi.Error); ok && calendarErr.Code == ccommon.ErrCodeNotFound {
+		return cancelledEvent(eventID), nil
+	}
+	return srcEvent, err
+}
+
+func (g *googleSource) resumable() bool {
+	return true
+}
+
+func (g *googleSource) finish() error {
+	return nil
+}
+
+// reasonFullSyncRequired is the error reason of the API when the query a page
+// token belongs to can't be continued.
+const reasonFullSyncRequired = "fullSyncRequired"
+
+// isInvalidPageToken reports whether the API rejected the page token of a
+// checkpoint, either because it expired or because it is no longer valid.
+func isInvalidPageToken(err error) bool {
+	apiErr, ok := errors.Cause(err).(*googleapi.Error)
+	if !ok {
+		return false
+	}
+	switch apiErr.Code {
+	case http.StatusGone:
+		for _, item := range apiErr.Errors {
+			if item.Reason == reasonFullSyncRequired {
+				return true
+			}
+		}
+	case http.StatusBadRequest:
+		return mentionsPageToken(apiErr.Message) || mentionsPageTokenItem(apiErr)
+	}
+	return false
+}
+
+// mentionsPageTokenItem reports whether an error item of the API error is
+// about the page token.
+func mentionsPageTokenItem(apiErr *googleapi.Error) bool {
+	for _, item := range apiErr.Errors {
+		if mentionsPageToken(item.Message) {
+			return true
+		}
+	}
+	return false
+}
+
+func mentionsPageToken(message string) bool {
+	message = strings.ToLower(strings.ReplaceAll(message, " ", ""))
+	return strings.Contains(message, "pagetoken")
+}
+
+func cancelledEvent(eventID string) *calendar.Event {
+	return &calendar.Event{Id: eventID, Status: ccommon.EventStatusCancelled}
+}
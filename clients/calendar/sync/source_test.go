@@ -0,0 +1,80 @@
+package sync
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/googleapi"
+)
+
+func TestIsInvalidPageToken(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "full sync required",
+			err: &googleapi.Error{
+				Code:   http.StatusGone,
+				Errors: []googleapi.ErrorItem{{Reason: reasonFullSyncRequired}},
+			},
+			want: true,
+		},
+		{
+			name: "invalid page token",
+			err: errors.Wrap(&googleapi.Error{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid page token value.",
+			}, "list"),
+			want: true,
+		},
+		{
+			name: "invalid page token item",
+			err: &googleapi.Error{
+				Code:   http.StatusBadRequest,
+				Errors: []googleapi.ErrorItem{{Reason: "invalid", Message: "Invalid pageToken"}},
+			},
+			want: true,
+		},
+		{
+			name: "other bad request",
+			err: &googleapi.Error{
+				Code:   http.StatusBadRequest,
+				Errors: []googleapi.ErrorItem{{Reason: "timeRangeEmpty", Message: "The specified time range is empty."}},
+			},
+		},
+		{
+			name: "other gone",
+			err: &googleapi.Error{
+				Code:   http.StatusGone,
+				Errors: []googleapi.ErrorItem{{Reason: "deleted"}},
+			},
+		},
+		{
+			name: "not an api error",
+			err:  errors.New("connection reset"),
+		},
+	} {
+		if got := isInvalidPageToken(test.err); got != test.want {
+			t.Errorf("%s: isInvalidPageToken = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestMentionsPageTokenItem(t *testing.T) {
+	for _, test := range []struct {
+		items []googleapi.ErrorItem
+		want  bool
+	}{
+		{items: nil},
+		{items: []googleapi.ErrorItem{{Reason: "invalid", Message: "Invalid page token value."}}, want: true},
+		{items: []googleapi.ErrorItem{{Reason: "invalid", Message: "Invalid value"}, {Reason: "invalid", Message: "Invalid PageToken"}}, want: true},
+		{items: []googleapi.ErrorItem{{Reason: "invalid", Message: "Invalid syncToken"}}},
+	} {
+		if got := mentionsPageTokenItem(&googleapi.Error{Errors: test.items}); got != test.want {
+			t.Errorf("mentionsPageTokenItem(%+v) = %v, want %v", test.items, got, test.want)
+		}
+	}
+}
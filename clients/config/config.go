@@ -0,0 +1,48 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultPath is the config file in the work directory.
+const DefaultPath = "config.json"
+
+// Config holds the user settings that are not secrets.
+type Config struct {
+	// Aliases name account and calendar pairs so they can be used instead
+	// of the account email and calendar id.
+	Aliases map[string]Alias `json:"aliases"`
+}
+
+// Alias is an account and calendar pair. The calendar may be an id or a
+// calendar name.
+type Alias struct {
+	Account  string `json:"account"`
+	Calendar string `json:"calendar"`
+}
+
+// Load reads the config file. A missing file is an empty config.
+func Load(path string) (Config, error) {
+	var config Config
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return config, errors.Wrap(err, "unable to read config file")
+	}
+
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, errors.Wrapf(err, "unable to parse config file %s", path)
+	}
+	for name, alias := range config.Aliases {
+		if alias.Account == "" || alias.Calendar == "" {
+			return config, errors.Errorf("alias %s needs an account and a calendar", name)
+		}
+	}
+	return config, nil
+}
@@ -0,0 +1,190 @@
+package graph
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultEndpoint is the Microsoft Graph API used when none is configured.
+const DefaultEndpoint = "https://graph.microsoft.com/v1.0"
+
+// Client talks to the Microsoft Graph API on behalf of the signed in user.
+// The HTTP client is expected to authenticate the requests.
+type Client struct {
+	http     *http.Client
+	endpoint string
+}
+
+// Error is returned when the API answers with an unexpected status.
+type Error struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Status     string
+	Header     http.Header
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("graph: %s %s: %s", e.Method, e.URL, e.Status)
+	}
+	return fmt.Sprintf("graph: %s %s: %s: %s: %s", e.Method, e.URL, e.Status, e.Code, e.Message)
+}
+
+// HTTPStatus returns the status code of the response.
+func (e *Error) HTTPStatus() int {
+	return e.StatusCode
+}
+
+// HTTPHeader returns the headers of the response.
+func (e *Error) HTTPHeader() http.Header {
+	return e.Header
+}
+
+// IsNotFound reports whether err is caused by a missing resource.
+func IsNotFound(err error) bool {
+	graphErr, ok := errors.Cause(err).(*Error)
+	return ok && graphErr.StatusCode == http.StatusNotFound
+}
+
+// New creates a client of the API at endpoint, DefaultEndpoint when empty.
+func New(httpClient *http.Client, endpoint string) (*Client, error) {
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, errors.Errorf("invalid graph endpoint: %s", endpoint)
+	}
+	return &Client{
+		http:     httpClient,
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+	}, nil
+}
+
+// User is the signed in user.
+type User struct {
+	ID                string `json:"id"`
+	DisplayName       string `json:"displayName"`
+	Mail              string `json:"mail"`
+	UserPrincipalName string `json:"userPrincipalName"`
+}
+
+// Email returns the address identifying the user, which is the sign in name
+// of accounts without a mailbox address.
+func (u User) Email() string {
+	if u.Mail != "" {
+		return u.Mail
+	}
+	return u.UserPrincipalName
+}
+
+// Me returns the signed in user.
+func (c *Client) Me(ctx context.Context) (User, error) {
+	var user User
+	if err := c.do(ctx, http.MethodGet, c.endpoint+"/me", nil, &user); err != nil {
+		return User{}, errors.Wrap(err, "failed to get the signed in user")
+	}
+	return user, nil
+}
+
+// Calendar is a calendar of the signed in user.
+type Calendar struct {
+	ID                string `json:"id"`
+	Name              string `json:"name"`
+	CanEdit           bool   `json:"canEdit"`
+	IsDefaultCalendar bool   `json:"isDefaultCalendar"`
+	HexColor          string `json:"hexColor"`
+}
+
+// Calendars lists the calendars of the signed in user.
+func (c *Client) Calendars(ctx context.Context) ([]Calendar, error) {
+	var calendars []Calendar
+	link := c.endpoint + "/me/calendars"
+	for link != "" {
+		var page struct {
+			Value    []Calendar `json:"value"`
+			NextLink string     `json:"@odata.nextLink"`
+		}
+		if err := c.do(ctx, http.MethodGet, link, nil, &page); err != nil {
+			return nil, errors.Wrap(err, "failed to list calendars")
+		}
+		calendars = append(calendars, page.Value...)
+		link = page.NextLink
+	}
+	return calendars, nil
+}
+
+// do sends a JSON request and decodes the JSON response into out when it is
+// not nil. Dates and times of events are returned in UTC.
+func (c *Client) do(ctx context.Context, method, target string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return errors.Wrap(err, "invalid graph request")
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return errors.Wrap(err, "invalid graph request")
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Add("Prefer", `outlook.timezone="UTC"`)
+	req.Header.Add("Prefer", "odata.maxpagesize=100")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "graph %s %s failed", method, target)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return responseError(resp)
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return errors.Wrapf(err, "invalid response to graph %s %s", method, target)
+	}
+	return nil
+}
+
+func responseError(resp *http.Response) error {
+	var body struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	json.Unmarshal(data, &body)
+
+	return &Error{
+		Method:     resp.Request.Method,
+		URL:        resp.Request.URL.String(),
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Header:     resp.Header,
+		Code:       body.Error.Code,
+		Message:    body.Error.Message,
+	}
+}
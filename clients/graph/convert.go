@@ -0,0 +1,257 @@
+package graph
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/calendar/v3"
+)
+
+// dateTimeLayout is the layout of DateTimeTimeZone values, which have up to
+// seven fractional second digits.
+const dateTimeLayout = "2006-01-02T15:04:05.9999999"
+
+var responses = map[string]string{
+	"organizer":           "accepted",
+	"accepted":            "accepted",
+	"tentativelyAccepted": "tentative",
+	"declined":            "declined",
+	"notResponded":        "needsAction",
+}
+
+// ToCalendar converts an event to the calendar API representation used by
+// the sync job. Occurrences and exceptions become instances of the recurring
+// event of their series master, and the response of the user is reported as
+// the response of a self attendee.
+func ToCalendar(e Event) (*calendar.Event, error) {
+	zone, loc := e.zone()
+
+	start, err := eventDateTime(e.Start, e.IsAllDay, zone, loc)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid start of event %s", e.ID)
+	}
+	end, err := eventDateTime(e.End, e.IsAllDay, zone, loc)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid end of event %s", e.ID)
+	}
+
+	event := &calendar.Event{
+		Id:           e.ID,
+		Summary:      e.Subject,
+		Start:        start,
+		End:          end,
+		Status:       "confirmed",
+		Transparency: "opaque",
+		Created:      e.CreatedDateTime,
+		Updated:      e.LastModifiedDateTime,
+	}
+	if e.Body != nil {
+		event.Description = e.Body.Content
+	}
+	if e.Location != nil {
+		event.Location = e.Location.DisplayName
+	}
+	if e.IsCancelled {
+		event.Status = "cancelled"
+	}
+	switch e.ShowAs {
+	case "free":
+		event.Transparency = "transparent"
+	case "oof":
+		event.EventType = "outOfOffice"
+	}
+	if e.Sensitivity == "private" || e.Sensitivity == "confidential" {
+		event.Visibility = "private"
+	}
+	if e.ResponseStatus != nil {
+		if response, ok := responses[e.ResponseStatus.Response]; ok {
+			event.Attendees = []*calendar.EventAttendee{{Self: true, ResponseStatus: response}}
+		}
+	}
+
+	switch e.Type {
+	case TypeOccurrence, TypeException:
+		event.RecurringEventId = e.SeriesMasterID
+		if event.OriginalStartTime, err = e.InstanceStart(e.OriginalStart); err != nil {
+			return nil, err
+		}
+	case TypeSeriesMaster:
+		if e.Recurrence == nil {
+			return nil, errors.Errorf("missing recurrence of event %s", e.ID)
+		}
+		rule, err := formatRule(e.Recurrence, e.IsAllDay, loc)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid recurrence of event %s", e.ID)
+		}
+		event.Recurrence = []string{rule}
+	}
+
+	return event, nil
+}
+
+// FromCalendar converts an event of the calendar API. Only the first
+// recurrence rule is kept: excluded dates are removed by deleting the
+// occurrences once the series exists, see ExcludedStarts.
+func FromCalendar(event *calendar.Event) (*Event, error) {
+	e := &Event{
+		Subject:     event.Summary,
+		Body:        &ItemBody{ContentType: "text", Content: event.Description},
+		Location:    &Location{DisplayName: event.Location},
+		ShowAs:      "busy",
+		Sensitivity: "normal",
+	}
+	if event.Transparency == "transparent" {
+		e.ShowAs = "free"
+	}
+	if event.Visibility == "private" || event.Visibility == "confidential" {
+		e.Sensitivity = "private"
+	}
+
+	var (
+		start time.Time
+		err   error
+	)
+	e.Start, start, e.IsAllDay, err = dateTimeTimeZone(event.Start)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid event start")
+	}
+	e.End, _, _, err = dateTimeTimeZone(event.End)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid event end")
+	}
+
+	for _, line := range event.Recurrence {
+		if !strings.HasPrefix(line, "RRULE:") {
+			continue
+		}
+		if e.Recurrence != nil {
+			return nil, errors.New("unsupported recurrence with several rules")
+		}
+		if e.Recurrence, err = parseRule(line, start); err != nil {
+			return nil, err
+		}
+		e.Recurrence.Range.RecurrenceTimeZone = e.Start.TimeZone
+	}
+
+	return e, nil
+}
+
+// InstanceStart converts the original start of an occurrence of this series
+// master to the calendar API representation.
+func (e Event) InstanceStart(originalStart string) (*calendar.EventDateTime, error) {
+	t, err := time.Parse(time.RFC3339Nano, originalStart)
+	if err != nil {
+		return nil, errors.Errorf("invalid original start %q", originalStart)
+	}
+
+	_, loc := e.zone()
+	if e.IsAllDay {
+		// all day occurrences start at midnight in the series time zone or
+		// in UTC
+		local := t.In(loc)
+		if local.Hour() != 0 || local.Minute() != 0 {
+			local = t.UTC()
+		}
+		return &calendar.EventDateTime{Date: local.Format(dateLayout)}, nil
+	}
+	return &calendar.EventDateTime{DateTime: t.UTC().Format(time.RFC3339)}, nil
+}
+
+// zone returns the time zone of the event, in which recurrences are expanded.
+func (e Event) zone() (string, *time.Location) {
+	if e.Recurrence != nil && e.Recurrence.Range.RecurrenceTimeZone != "" {
+		return location(e.Recurrence.Range.RecurrenceTimeZone)
+	}
+	return location(e.OriginalStartTimeZone)
+}
+
+func eventDateTime(dt *DateTimeTimeZone, allDay bool, zone string, loc *time.Location) (*calendar.EventDateTime, error) {
+	if dt == nil {
+		return nil, errors.New("missing date")
+	}
+	if allDay {
+		if len(dt.DateTime) < len(dateLayout) {
+			return nil, errors.Errorf("invalid date %q", dt.DateTime)
+		}
+		return &calendar.EventDateTime{Date: dt.DateTime[:len(dateLayout)]}, nil
+	}
+
+	_, valueLoc := location(dt.TimeZone)
+	t, err := time.ParseInLocation(dateTimeLayout, dt.DateTime, valueLoc)
+	if err != nil {
+		return nil, errors.Errorf("invalid date time %q", dt.DateTime)
+	}
+	return &calendar.EventDateTime{
+		DateTime: t.In(loc).Format(time.RFC3339),
+		TimeZone: zone,
+	}, nil
+}
+
+// dateTimeTimeZone converts a date or date time of the calendar API. Date
+// times are kept in their time zone so recurrences follow its daylight
+// saving changes.
+func dateTimeTimeZone(dt *calendar.EventDateTime) (*DateTimeTimeZone, time.Time, bool, error) {
+	if dt == nil {
+		return nil, time.Time{}, false, errors.New("missing date")
+	}
+	if dt.Date != "" {
+		t, err := time.Parse(dateLayout, dt.Date)
+		if err != nil {
+			return nil, time.Time{}, false, errors.Errorf("invalid date %q", dt.Date)
+		}
+		return &DateTimeTimeZone{DateTime: dt.Date + "T00:00:00", TimeZone: "UTC"}, t, true, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, dt.DateTime)
+	if err != nil {
+		return nil, time.Time{}, false, errors.Errorf("invalid date time %q", dt.DateTime)
+	}
+	zone, loc := location(dt.TimeZone)
+	t = t.In(loc)
+	return &DateTimeTimeZone{DateTime: t.Format("2006-01-02T15:04:05"), TimeZone: zone}, t, false, nil
+}
+
+// ExcludedStarts returns the original starts of the occurrences excluded by
+// the EXDATE lines of a recurrence.
+func ExcludedStarts(recurrence []string) ([]*calendar.EventDateTime, error) {
+	var starts []*calendar.EventDateTime
+	for _, line := range recurrence {
+		if !strings.HasPrefix(line, "EXDATE") {
+			continue
+		}
+		head, values, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, errors.Errorf("invalid recurrence line %q", line)
+		}
+
+		isDate := false
+		_, loc := location("")
+		for _, param := range strings.Split(head, ";")[1:] {
+			name, value, _ := strings.Cut(param, "=")
+			switch strings.ToUpper(name) {
+			case "VALUE":
+				isDate = strings.EqualFold(value, "DATE")
+			case "TZID":
+				_, loc = location(strings.Trim(value, `"`))
+			}
+		}
+
+		for _, value := range strings.Split(values, ",") {
+			if isDate || len(value) == len("20060102") {
+				t, err := time.Parse("20060102", value)
+				if err != nil {
+					return nil, errors.Errorf("invalid excluded date %q", value)
+				}
+				starts = append(starts, &calendar.EventDateTime{Date: t.Format(dateLayout)})
+				continue
+			}
+			t, err := parseUntil(value, loc)
+			if err != nil {
+				return nil, errors.Errorf("invalid excluded date %q", value)
+			}
+			starts = append(starts, &calendar.EventDateTime{DateTime: t.UTC().Format(time.RFC3339)})
+		}
+	}
+	return starts, nil
+}
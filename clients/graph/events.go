@@ -0,0 +1,232 @@
+package graph
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/calendar/v3"
+)
+
+const instanceSearchMargin = 48 * time.Hour
+
+// Event types.
+const (
+	TypeSingleInstance = "singleInstance"
+	TypeOccurrence     = "occurrence"
+	TypeException      = "exception"
+	TypeSeriesMaster   = "seriesMaster"
+)
+
+// Event is a calendar event. The fields that can't be written are omitted
+// when empty so the same type is used to create and update events.
+type Event struct {
+	ID                    string               `json:"id,omitempty"`
+	Subject               string               `json:"subject"`
+	Body                  *ItemBody            `json:"body,omitempty"`
+	Start                 *DateTimeTimeZone    `json:"start,omitempty"`
+	End                   *DateTimeTimeZone    `json:"end,omitempty"`
+	IsAllDay              bool                 `json:"isAllDay"`
+	Location              *Location            `json:"location,omitempty"`
+	ShowAs                string               `json:"showAs,omitempty"`
+	Sensitivity           string               `json:"sensitivity,omitempty"`
+	Recurrence            *PatternedRecurrence `json:"recurrence,omitempty"`
+	Type                  string               `json:"type,omitempty"`
+	SeriesMasterID        string               `json:"seriesMasterId,omitempty"`
+	OriginalStart         string               `json:"originalStart,omitempty"`
+	OriginalStartTimeZone string               `json:"originalStartTimeZone,omitempty"`
+	IsCancelled           bool                 `json:"isCancelled,omitempty"`
+	ResponseStatus        *ResponseStatus      `json:"responseStatus,omitempty"`
+	CreatedDateTime       string               `json:"createdDateTime,omitempty"`
+	LastModifiedDateTime  string               `json:"lastModifiedDateTime,omitempty"`
+	// TransactionID identifies a create request, the event is only created
+	// once when the request is sent again.
+	TransactionID string `json:"transactionId,omitempty"`
+	// Removed is set for the events removed since the previous delta query.
+	Removed *Removed `json:"@removed,omitempty"`
+}
+
+type ItemBody struct {
+	ContentType string `json:"contentType"`
+	Content     string `json:"content"`
+}
+
+// DateTimeTimeZone is a local date and time in a Windows or IANA time zone.
+type DateTimeTimeZone struct {
+	DateTime string `json:"dateTime"`
+	TimeZone string `json:"timeZone"`
+}
+
+type Location struct {
+	DisplayName string `json:"displayName"`
+}
+
+type ResponseStatus struct {
+	Response string `json:"response"`
+}
+
+type Removed struct {
+	Reason string `json:"reason"`
+}
+
+// Delta is the result of a delta query.
+type Delta struct {
+	Events []Event
+	// Link returns the changes made after this query.
+	Link string
+}
+
+// Event returns an event of the signed in user.
+func (c *Client) Event(ctx context.Context, eventID string) (Event, error) {
+	var event Event
+	if err := c.do(ctx, http.MethodGet, c.eventURL(eventID), nil, &event); err != nil {
+		return Event{}, errors.Wrap(err, "failed to get event")
+	}
+	return event, nil
+}
+
+// CreateEvent creates an event in a calendar and returns it.
+func (c *Client) CreateEvent(ctx context.Context, calendarID string, event *Event) (Event, error) {
+	var created Event
+	target := c.endpoint + "/me/calendars/" + url.PathEscape(calendarID) + "/events"
+	if err := c.do(ctx, http.MethodPost, target, event, &created); err != nil {
+		return Event{}, errors.Wrap(err, "failed to create event")
+	}
+	return created, nil
+}
+
+// UpdateEvent updates the fields of an event that are set.
+func (c *Client) UpdateEvent(ctx context.Context, eventID string, event *Event) error {
+	if err := c.do(ctx, http.MethodPatch, c.eventURL(eventID), event, nil); err != nil {
+		return errors.Wrap(err, "failed to update event")
+	}
+	return nil
+}
+
+// DeleteEvent deletes an event. Deleting an occurrence cancels it.
+func (c *Client) DeleteEvent(ctx context.Context, eventID string) error {
+	if err := c.do(ctx, http.MethodDelete, c.eventURL(eventID), nil, nil); err != nil {
+		return errors.Wrap(err, "failed to delete event")
+	}
+	return nil
+}
+
+// Instances lists the occurrences and exceptions of a series that overlap
+// the time range.
+func (c *Client) Instances(ctx context.Context, seriesMasterID string, start, end time.Time) ([]Event, error) {
+	query := url.Values{}
+	query.Set("startDateTime", start.UTC().Format(time.RFC3339))
+	query.Set("endDateTime", end.UTC().Format(time.RFC3339))
+
+	var events []Event
+	link := c.eventURL(seriesMasterID) + "/instances?" + query.Encode()
+	for link != "" {
+		var page struct {
+			Value    []Event `json:"value"`
+			NextLink string  `json:"@odata.nextLink"`
+		}
+		if err := c.do(ctx, http.MethodGet, link, nil, &page); err != nil {
+			return nil, errors.Wrap(err, "failed to list event instances")
+		}
+		events = append(events, page.Value...)
+		link = page.NextLink
+	}
+	return events, nil
+}
+
+// FindInstance returns the occurrence or exception of a series with the
+// original start. It reports false when the series has no such instance.
+func (c *Client) FindInstance(ctx context.Context, seriesMasterID string, originalStart *calendar.EventDateTime) (Event, bool, error) {
+	var (
+		t   time.Time
+		err error
+	)
+	if originalStart.Date != "" {
+		t, err = time.Parse(dateLayout, originalStart.Date)
+	} else {
+		t, err = time.Parse(time.RFC3339, originalStart.DateTime)
+	}
+	if err != nil {
+		return Event{}, false, errors.Wrap(err, "invalid original start")
+	}
+
+	// instances are listed by their current time, which exceptions may have
+	// moved around the original start
+	instances, err := c.Instances(ctx, seriesMasterID, t.Add(-instanceSearchMargin), t.Add(instanceSearchMargin))
+	if err != nil {
+		return Event{}, false, err
+	}
+	for _, instance := range instances {
+		start, err := instance.InstanceStart(instance.OriginalStart)
+		if err != nil {
+			continue
+		}
+		if originalStart.Date != "" && start.Date == originalStart.Date {
+			return instance, true, nil
+		}
+		if originalStart.Date == "" && start.DateTime != "" && sameInstant(start.DateTime, t) {
+			return instance, true, nil
+		}
+	}
+	return Event{}, false, nil
+}
+
+func sameInstant(value string, t time.Time) bool {
+	parsed, err := time.Parse(time.RFC3339, value)
+	return err == nil && parsed.Equal(t)
+}
+
+// Delta returns the events of a calendar in the time range, or the changes
+// since a previous delta query when link is set. Recurring events are
+// expanded into their occurrences and exceptions. The link of a query
+// expires after a while, see IsSyncStateInvalid.
+func (c *Client) Delta(ctx context.Context, calendarID string, start, end time.Time, link string) (Delta, error) {
+	if link == "" {
+		query := url.Values{}
+		query.Set("startDateTime", start.UTC().Format(time.RFC3339))
+		query.Set("endDateTime", end.UTC().Format(time.RFC3339))
+		link = c.endpoint + "/me/calendars/" + url.PathEscape(calendarID) + "/calendarView/delta?" + query.Encode()
+	}
+
+	var delta Delta
+	for {
+		var page struct {
+			Value     []Event `json:"value"`
+			NextLink  string  `json:"@odata.nextLink"`
+			DeltaLink string  `json:"@odata.deltaLink"`
+		}
+		if err := c.do(ctx, http.MethodGet, link, nil, &page); err != nil {
+			return Delta{}, errors.Wrap(err, "failed to list event changes")
+		}
+		delta.Events = append(delta.Events, page.Value...)
+		if page.NextLink == "" {
+			delta.Link = page.DeltaLink
+			return delta, nil
+		}
+		link = page.NextLink
+	}
+}
+
+// Error codes of delta links that can no longer be used.
+const (
+	codeSyncStateNotFound = "syncStateNotFound"
+	codeResyncRequired    = "resyncRequired"
+)
+
+// IsSyncStateInvalid reports whether the API rejected a delta link, after
+// which the events have to be listed again.
+func IsSyncStateInvalid(err error) bool {
+	graphErr, ok := errors.Cause(err).(*Error)
+	if !ok {
+		return false
+	}
+	// the case of the codes varies between API versions
+	return strings.EqualFold(graphErr.Code, codeSyncStateNotFound) || strings.EqualFold(graphErr.Code, codeResyncRequired)
+}
+
+func (c *Client) eventURL(eventID string) string {
+	return c.endpoint + "/me/events/" + url.PathEscape(eventID)
+}
@@ -0,0 +1,94 @@
+package graph
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestFindInstance(t *testing.T) {
+	const originalStart = "2024-03-10T09:00:00Z"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/me/events/master/instances" {
+			http.NotFound(w, r)
+			return
+		}
+		query := r.URL.Query()
+		if query.Get("startDateTime") != "2024-03-08T09:00:00Z" || query.Get("endDateTime") != "2024-03-12T09:00:00Z" {
+			t.Errorf("instances listed from %s to %s, want 48h around the original start",
+				query.Get("startDateTime"), query.Get("endDateTime"))
+		}
+		// the exception was moved a day later than its original start
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"value": []Event{
+				{ID: "previous", Type: TypeOccurrence, OriginalStart: "2024-03-09T09:00:00Z"},
+				{ID: "moved", Type: TypeException, OriginalStart: "2024-03-10T09:00:00.0000000Z",
+					Start: &DateTimeTimeZone{DateTime: "2024-03-11T09:00:00.0000000", TimeZone: "UTC"}},
+			},
+		})
+	}))
+	defer server.Close()
+
+	client, err := New(server.Client(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	instance, found, err := client.FindInstance(ctx, "master", &calendar.EventDateTime{DateTime: originalStart})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || instance.ID != "moved" {
+		t.Errorf("found %v %s, want the moved exception", found, instance.ID)
+	}
+
+	_, found, err = client.FindInstance(ctx, "master", &calendar.EventDateTime{DateTime: "2024-03-10T10:00:00+01:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found {
+		t.Error("instance not found by the same instant in another time zone")
+	}
+}
+
+func TestFindInstanceNotFound(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"value": []}`))
+	}))
+	defer server.Close()
+
+	client, err := New(server.Client(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, found, err := client.FindInstance(context.Background(), "master", &calendar.EventDateTime{Date: "2024-03-10"})
+	if err != nil || found {
+		t.Errorf("FindInstance = %v, %v, want not found", found, err)
+	}
+}
+
+func TestIsSyncStateInvalid(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want bool
+	}{
+		{&Error{StatusCode: http.StatusGone, Code: "SyncStateNotFound"}, true},
+		{errors.Wrap(&Error{StatusCode: http.StatusGone, Code: "syncStateNotFound"}, "delta"), true},
+		{&Error{StatusCode: http.StatusBadRequest, Code: "resyncRequired"}, true},
+		{&Error{StatusCode: http.StatusBadRequest, Code: "ErrorInvalidParameter"}, false},
+		{&Error{StatusCode: http.StatusGone}, false},
+		{errors.New("connection reset"), false},
+	} {
+		if got := IsSyncStateInvalid(test.err); got != test.want {
+			t.Errorf("IsSyncStateInvalid(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
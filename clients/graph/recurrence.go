@@ -0,0 +1,354 @@
+package graph
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// PatternedRecurrence is the recurrence of a series master.
+type PatternedRecurrence struct {
+	Pattern RecurrencePattern `json:"pattern"`
+	Range   RecurrenceRange   `json:"range"`
+}
+
+type RecurrencePattern struct {
+	Type           string   `json:"type"`
+	Interval       int      `json:"interval"`
+	Month          int      `json:"month,omitempty"`
+	DayOfMonth     int      `json:"dayOfMonth,omitempty"`
+	DaysOfWeek     []string `json:"daysOfWeek,omitempty"`
+	FirstDayOfWeek string   `json:"firstDayOfWeek,omitempty"`
+	Index          string   `json:"index,omitempty"`
+}
+
+type RecurrenceRange struct {
+	Type                string `json:"type"`
+	StartDate           string `json:"startDate"`
+	EndDate             string `json:"endDate,omitempty"`
+	NumberOfOccurrences int    `json:"numberOfOccurrences,omitempty"`
+	RecurrenceTimeZone  string `json:"recurrenceTimeZone,omitempty"`
+}
+
+const dateLayout = "2006-01-02"
+
+var (
+	weekdays = map[string]string{
+		"SU": "sunday",
+		"MO": "monday",
+		"TU": "tuesday",
+		"WE": "wednesday",
+		"TH": "thursday",
+		"FR": "friday",
+		"SA": "saturday",
+	}
+	indexes = map[string]int{
+		"first":  1,
+		"second": 2,
+		"third":  3,
+		"fourth": 4,
+		"last":   -1,
+	}
+)
+
+// parseRule converts a recurrence rule to a pattern. Start is the local
+// start of the first occurrence, which provides the values the rule omits.
+// Rules that the API can't represent, such as hourly rules or several
+// ordinal weekdays, are rejected.
+func parseRule(rule string, start time.Time) (*PatternedRecurrence, error) {
+	parts := map[string]string{}
+	for _, part := range strings.Split(strings.TrimPrefix(rule, "RRULE:"), ";") {
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, errors.Errorf("invalid recurrence rule part %q", part)
+		}
+		parts[strings.ToUpper(name)] = strings.ToUpper(value)
+	}
+
+	for name := range parts {
+		switch name {
+		case "FREQ", "INTERVAL", "COUNT", "UNTIL", "BYDAY", "BYMONTHDAY", "BYMONTH", "BYSETPOS", "WKST":
+		default:
+			return nil, errors.Errorf("unsupported recurrence rule part %s", name)
+		}
+	}
+
+	r := &PatternedRecurrence{
+		Pattern: RecurrencePattern{Interval: 1},
+		Range:   RecurrenceRange{Type: "noEnd", StartDate: start.Format(dateLayout)},
+	}
+
+	if value, ok := parts["INTERVAL"]; ok {
+		interval, err := strconv.Atoi(value)
+		if err != nil || interval < 1 {
+			return nil, errors.Errorf("invalid recurrence interval %q", value)
+		}
+		r.Pattern.Interval = interval
+	}
+
+	days, ordinal, err := parseDays(parts["BYDAY"])
+	if err != nil {
+		return nil, err
+	}
+	if value, ok := parts["BYSETPOS"]; ok {
+		if ordinal != 0 {
+			return nil, errors.New("unsupported recurrence rule with BYSETPOS and ordinal weekdays")
+		}
+		if ordinal, err = strconv.Atoi(value); err != nil {
+			return nil, errors.Errorf("unsupported recurrence position %q", value)
+		}
+	}
+
+	var month, dayOfMonth int
+	if value, ok := parts["BYMONTH"]; ok {
+		if month, err = strconv.Atoi(value); err != nil || month < 1 || month > 12 {
+			return nil, errors.Errorf("unsupported recurrence month %q", value)
+		}
+	}
+	if value, ok := parts["BYMONTHDAY"]; ok {
+		if dayOfMonth, err = strconv.Atoi(value); err != nil || dayOfMonth < 1 || dayOfMonth > 31 {
+			return nil, errors.Errorf("unsupported recurrence month day %q", value)
+		}
+	}
+
+	switch parts["FREQ"] {
+	case "DAILY":
+		if len(days) > 0 {
+			r.Pattern.Type = "weekly"
+			r.Pattern.DaysOfWeek = days
+		} else {
+			r.Pattern.Type = "daily"
+		}
+	case "WEEKLY":
+		r.Pattern.Type = "weekly"
+		r.Pattern.DaysOfWeek = days
+		if len(days) == 0 {
+			r.Pattern.DaysOfWeek = []string{strings.ToLower(start.Weekday().String())}
+		}
+		r.Pattern.FirstDayOfWeek = "monday"
+		if value, ok := parts["WKST"]; ok {
+			if r.Pattern.FirstDayOfWeek, ok = weekdays[value]; !ok {
+				return nil, errors.Errorf("invalid recurrence week start %q", value)
+			}
+		}
+	case "MONTHLY":
+		if len(days) > 0 {
+			r.Pattern.Type = "relativeMonthly"
+		} else {
+			r.Pattern.Type = "absoluteMonthly"
+		}
+	case "YEARLY":
+		if len(days) > 0 {
+			r.Pattern.Type = "relativeYearly"
+		} else {
+			r.Pattern.Type = "absoluteYearly"
+		}
+		r.Pattern.Month = month
+		if month == 0 {
+			r.Pattern.Month = int(start.Month())
+		}
+	default:
+		return nil, errors.Errorf("unsupported recurrence frequency %q", parts["FREQ"])
+	}
+
+	switch r.Pattern.Type {
+	case "relativeMonthly", "relativeYearly":
+		if r.Pattern.Index, err = formatIndex(ordinal); err != nil {
+			return nil, err
+		}
+		r.Pattern.DaysOfWeek = days
+	case "absoluteMonthly", "absoluteYearly":
+		r.Pattern.DayOfMonth = dayOfMonth
+		if dayOfMonth == 0 {
+			r.Pattern.DayOfMonth = start.Day()
+		}
+	}
+	if month != 0 && r.Pattern.Month == 0 {
+		return nil, errors.New("unsupported recurrence rule with BYMONTH")
+	}
+	if dayOfMonth != 0 && r.Pattern.DayOfMonth == 0 {
+		return nil, errors.New("unsupported recurrence rule with BYMONTHDAY")
+	}
+	if ordinal != 0 && r.Pattern.Index == "" {
+		return nil, errors.New("unsupported recurrence rule with a position")
+	}
+
+	if value, ok := parts["COUNT"]; ok {
+		count, err := strconv.Atoi(value)
+		if err != nil || count < 1 {
+			return nil, errors.Errorf("invalid recurrence count %q", value)
+		}
+		r.Range.Type = "numbered"
+		r.Range.NumberOfOccurrences = count
+	} else if value, ok := parts["UNTIL"]; ok {
+		until, err := parseUntil(value, start.Location())
+		if err != nil {
+			return nil, err
+		}
+		r.Range.Type = "endDate"
+		r.Range.EndDate = until.Format(dateLayout)
+	}
+
+	return r, nil
+}
+
+// parseDays parses a BYDAY list. All the days must have the same ordinal.
+func parseDays(value string) ([]string, int, error) {
+	if value == "" {
+		return nil, 0, nil
+	}
+
+	var days []string
+	ordinal := 0
+	for i, item := range strings.Split(value, ",") {
+		if len(item) < 2 {
+			return nil, 0, errors.Errorf("invalid recurrence day %q", item)
+		}
+		day, ok := weekdays[item[len(item)-2:]]
+		if !ok {
+			return nil, 0, errors.Errorf("invalid recurrence day %q", item)
+		}
+		n := 0
+		if prefix := item[:len(item)-2]; prefix != "" {
+			var err error
+			if n, err = strconv.Atoi(prefix); err != nil {
+				return nil, 0, errors.Errorf("invalid recurrence day %q", item)
+			}
+		}
+		if i > 0 && n != ordinal {
+			return nil, 0, errors.Errorf("unsupported recurrence days %q", value)
+		}
+		ordinal = n
+		days = append(days, day)
+	}
+	return days, ordinal, nil
+}
+
+func parseUntil(value string, loc *time.Location) (time.Time, error) {
+	if t, err := time.ParseInLocation("20060102", value, loc); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("20060102T150405Z", value)
+	if err != nil {
+		t, err = time.ParseInLocation("20060102T150405", value, loc)
+	}
+	if err != nil {
+		return time.Time{}, errors.Errorf("invalid recurrence end %q", value)
+	}
+	return t.In(loc), nil
+}
+
+func formatIndex(ordinal int) (string, error) {
+	for name, n := range indexes {
+		if n == ordinal {
+			return name, nil
+		}
+	}
+	// every week day of the month or year can't be represented
+	return "", errors.Errorf("unsupported recurrence position %d", ordinal)
+}
+
+// formatRule converts a pattern to a recurrence rule. loc is the time zone
+// of the series, used for the end of the range of timed events.
+func formatRule(r *PatternedRecurrence, allDay bool, loc *time.Location) (string, error) {
+	var parts []string
+	p := r.Pattern
+
+	switch p.Type {
+	case "daily":
+		parts = append(parts, "FREQ=DAILY")
+	case "weekly":
+		parts = append(parts, "FREQ=WEEKLY")
+	case "absoluteMonthly", "relativeMonthly":
+		parts = append(parts, "FREQ=MONTHLY")
+	case "absoluteYearly", "relativeYearly":
+		parts = append(parts, "FREQ=YEARLY")
+	default:
+		return "", errors.Errorf("unsupported recurrence pattern %q", p.Type)
+	}
+
+	if p.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(p.Interval))
+	}
+
+	switch p.Type {
+	case "weekly":
+		days, err := formatDays(p.DaysOfWeek)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, "BYDAY="+days)
+		if day, err := formatDays([]string{p.FirstDayOfWeek}); err == nil && p.FirstDayOfWeek != "" {
+			parts = append(parts, "WKST="+day)
+		}
+	case "absoluteMonthly":
+		parts = append(parts, "BYMONTHDAY="+strconv.Itoa(p.DayOfMonth))
+	case "absoluteYearly":
+		parts = append(parts, "BYMONTH="+strconv.Itoa(p.Month), "BYMONTHDAY="+strconv.Itoa(p.DayOfMonth))
+	case "relativeMonthly", "relativeYearly":
+		if p.Type == "relativeYearly" {
+			parts = append(parts, "BYMONTH="+strconv.Itoa(p.Month))
+		}
+		days, err := formatDays(p.DaysOfWeek)
+		if err != nil {
+			return "", err
+		}
+		index := p.Index
+		if index == "" {
+			index = "first"
+		}
+		ordinal, ok := indexes[index]
+		if !ok {
+			return "", errors.Errorf("unsupported recurrence index %q", p.Index)
+		}
+		parts = append(parts, "BYDAY="+days, "BYSETPOS="+strconv.Itoa(ordinal))
+	}
+
+	switch r.Range.Type {
+	case "numbered":
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Range.NumberOfOccurrences))
+	case "endDate":
+		end, err := time.ParseInLocation(dateLayout, r.Range.EndDate, loc)
+		if err != nil {
+			return "", errors.Errorf("invalid recurrence end date %q", r.Range.EndDate)
+		}
+		if allDay {
+			parts = append(parts, "UNTIL="+end.Format("20060102"))
+		} else {
+			// the range includes the occurrences of the end date
+			until := end.AddDate(0, 0, 1).Add(-time.Second)
+			parts = append(parts, "UNTIL="+until.UTC().Format("20060102T150405Z"))
+		}
+	}
+
+	return "RRULE:" + strings.Join(parts, ";"), nil
+}
+
+// formatDays formats week days as a BYDAY list in the week order.
+func formatDays(days []string) (string, error) {
+	if len(days) == 0 {
+		return "", errors.New("missing recurrence days")
+	}
+
+	order := map[string]int{"MO": 0, "TU": 1, "WE": 2, "TH": 3, "FR": 4, "SA": 5, "SU": 6}
+	var codes []string
+	for _, day := range days {
+		code := ""
+		for c, name := range weekdays {
+			if strings.EqualFold(name, day) {
+				code = c
+			}
+		}
+		if code == "" {
+			return "", errors.Errorf("invalid recurrence day %q", day)
+		}
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		return order[codes[i]] < order[codes[j]]
+	})
+	return strings.Join(codes, ","), nil
+}
@@ -0,0 +1,117 @@
+package ics
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maxLineLength is the maximum length of a content line in octets, longer
+// lines are folded.
+const maxLineLength = 75
+
+// Encode writes a component and its sub components as an iCalendar stream.
+func Encode(w io.Writer, c *Component) error {
+	bw := bufio.NewWriter(w)
+	encodeComponent(bw, c)
+	return errors.Wrap(bw.Flush(), "failed to write calendar")
+}
+
+// WriteFile encodes a calendar to a file. The calendar is written to a
+// temporary file that replaces the destination, so readers never see a
+// partially written calendar.
+func WriteFile(path string, c *Component) error {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return errors.Wrap(err, "failed to create calendar file")
+	}
+	defer os.Remove(file.Name())
+
+	if err := Encode(file, c); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return errors.Wrap(err, "failed to write calendar file")
+	}
+	if err := file.Close(); err != nil {
+		return errors.Wrap(err, "failed to write calendar file")
+	}
+	if err := os.Chmod(file.Name(), 0644); err != nil {
+		return errors.Wrap(err, "failed to write calendar file")
+	}
+	return errors.Wrap(os.Rename(file.Name(), path), "failed to replace calendar file")
+}
+
+// Escape encodes a value as an iCalendar TEXT value.
+func Escape(value string) string {
+	return textEscaper.Replace(value)
+}
+
+var textEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`;`, `\;`,
+	`,`, `\,`,
+	"\r\n", `\n`,
+	"\n", `\n`,
+)
+
+func encodeComponent(w *bufio.Writer, c *Component) {
+	writeLine(w, "BEGIN:"+c.Name)
+	for _, p := range c.Properties {
+		writeLine(w, encodeProperty(p))
+	}
+	for _, sub := range c.Components {
+		encodeComponent(w, sub)
+	}
+	writeLine(w, "END:"+c.Name)
+}
+
+func encodeProperty(p Property) string {
+	var b strings.Builder
+	b.WriteString(p.Name)
+
+	names := make([]string, 0, len(p.Params))
+	for name := range p.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b.WriteString(";" + name + "=")
+		value := p.Params[name]
+		if strings.ContainsAny(value, ",;:") {
+			value = `"` + value + `"`
+		}
+		b.WriteString(value)
+	}
+
+	b.WriteString(":" + p.Value)
+	return b.String()
+}
+
+// writeLine writes a content line, folding it without splitting UTF-8
+// sequences.
+func writeLine(w *bufio.Writer, line string) {
+	limit := maxLineLength
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && isContinuationByte(line[cut]) {
+			cut--
+		}
+		w.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		// the leading space counts towards the length of the next line
+		limit = maxLineLength - 1
+	}
+	w.WriteString(line + "\r\n")
+}
+
+func isContinuationByte(b byte) bool {
+	return b&0xC0 == 0x80
+}
@@ -0,0 +1,445 @@
+package ics
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/calendar/v3"
+)
+
+const (
+	dateLayout        = "20060102"
+	dateTimeLayout    = "20060102T150405"
+	dateTimeUTCLayout = "20060102T150405Z"
+
+	apiDateLayout = "2006-01-02"
+
+	statusCancelled = "CANCELLED"
+)
+
+// Events converts the VEVENT components of a calendar to the event model of
+// the calendar API. Event IDs are the UIDs, overrides of recurring event
+// instances get the ID of the recurring event followed by the original start
+// time, like the calendar API does. Recurring events are returned before their
+// instance overrides.
+func Events(cal *Component) ([]*calendar.Event, error) {
+	zones := newZoneResolver(cal)
+
+	var masters, instances []*calendar.Event
+	for _, c := range cal.ComponentsNamed(ComponentEvent) {
+		event, err := zones.event(c)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid event %s", c.Value("UID"))
+		}
+		if event.RecurringEventId != "" {
+			instances = append(instances, event)
+		} else {
+			masters = append(masters, event)
+		}
+	}
+
+	sort.SliceStable(masters, func(i, j int) bool {
+		return masters[i].Id < masters[j].Id
+	})
+	sort.SliceStable(instances, func(i, j int) bool {
+		return instances[i].Id < instances[j].Id
+	})
+
+	return append(masters, instances...), nil
+}
+
+// InstanceID builds the ID of a recurring event instance from the ID of the
+// recurring event and the original start of the instance.
+func InstanceID(recurringEventID string, originalStart *calendar.EventDateTime) string {
+	if originalStart.Date != "" {
+		return recurringEventID + "_" + strings.ReplaceAll(originalStart.Date, "-", "")
+	}
+	t, err := time.Parse(time.RFC3339, originalStart.DateTime)
+	if err != nil {
+		return recurringEventID + "_" + originalStart.DateTime
+	}
+	return recurringEventID + "_" + t.UTC().Format(dateTimeUTCLayout)
+}
+
+type zoneResolver struct {
+	zones map[string]*Component
+	// floating is used for date times without a time zone
+	floating string
+}
+
+func newZoneResolver(cal *Component) *zoneResolver {
+	z := &zoneResolver{
+		zones:    make(map[string]*Component),
+		floating: "UTC",
+	}
+	for _, c := range cal.ComponentsNamed(ComponentTimeZone) {
+		z.zones[c.Value("TZID")] = c
+	}
+	if name := cal.Value("X-WR-TIMEZONE"); name != "" {
+		if _, err := time.LoadLocation(name); err == nil {
+			z.floating = name
+		}
+	}
+	return z
+}
+
+func (z *zoneResolver) event(c *Component) (*calendar.Event, error) {
+	uid := c.Value("UID")
+	if uid == "" {
+		return nil, errors.New("missing UID")
+	}
+
+	start, err := z.dateTime(c.Property("DTSTART"))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid DTSTART")
+	}
+	if start == nil {
+		return nil, errors.New("missing DTSTART")
+	}
+
+	end, err := z.end(c, start)
+	if err != nil {
+		return nil, err
+	}
+
+	event := &calendar.Event{
+		Id:          uid,
+		ICalUID:     uid,
+		Summary:     c.Text("SUMMARY"),
+		Description: c.Text("DESCRIPTION"),
+		Location:    c.Text("LOCATION"),
+		Start:       start,
+		End:         end,
+		Status:      "confirmed",
+	}
+
+	if strings.EqualFold(c.Value("STATUS"), statusCancelled) {
+		event.Status = "cancelled"
+	}
+	if strings.EqualFold(c.Value("TRANSP"), "TRANSPARENT") {
+		event.Transparency = "transparent"
+	}
+	switch strings.ToUpper(c.Value("CLASS")) {
+	case "PUBLIC":
+		event.Visibility = "public"
+	case "PRIVATE":
+		event.Visibility = "private"
+	case "CONFIDENTIAL":
+		event.Visibility = "confidential"
+	}
+	if updated := firstNonEmpty(c.Value("LAST-MODIFIED"), c.Value("DTSTAMP")); updated != "" {
+		if t, err := time.Parse(dateTimeUTCLayout, updated); err == nil {
+			event.Updated = t.Format(time.RFC3339)
+		}
+	}
+
+	if p := c.Property("RECURRENCE-ID"); p != nil {
+		originalStart, err := z.dateTime(p)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid RECURRENCE-ID")
+		}
+		event.RecurringEventId = uid
+		event.OriginalStartTime = originalStart
+		event.Id = InstanceID(uid, originalStart)
+		return event, nil
+	}
+
+	recurrence, err := z.recurrence(c)
+	if err != nil {
+		return nil, err
+	}
+	if len(recurrence) > 0 {
+		event.Recurrence = recurrence
+		// the calendar API requires a time zone to expand recurring events
+		if event.Start.DateTime != "" && event.Start.TimeZone == "" {
+			event.Start.TimeZone = "UTC"
+			event.End.TimeZone = "UTC"
+		}
+	}
+
+	return event, nil
+}
+
+func (z *zoneResolver) end(c *Component, start *calendar.EventDateTime) (*calendar.EventDateTime, error) {
+	end, err := z.dateTime(c.Property("DTEND"))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid DTEND")
+	}
+	if end != nil {
+		return end, nil
+	}
+
+	duration := time.Duration(0)
+	if value := c.Value("DURATION"); value != "" {
+		duration, err = parseDuration(value)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid DURATION")
+		}
+	}
+
+	if start.Date != "" {
+		t, err := time.Parse(apiDateLayout, start.Date)
+		if err != nil {
+			return nil, err
+		}
+		// an all day event without an end lasts one day
+		if duration < 24*time.Hour {
+			duration = 24 * time.Hour
+		}
+		return &calendar.EventDateTime{Date: t.Add(duration).Format(apiDateLayout)}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, start.DateTime)
+	if err != nil {
+		return nil, err
+	}
+	return &calendar.EventDateTime{
+		DateTime: t.Add(duration).Format(time.RFC3339),
+		TimeZone: start.TimeZone,
+	}, nil
+}
+
+// dateTime converts a DATE or DATE-TIME property, nil properties are
+// returned as nil.
+func (z *zoneResolver) dateTime(p *Property) (*calendar.EventDateTime, error) {
+	if p == nil {
+		return nil, nil
+	}
+
+	if p.Params["VALUE"] == "DATE" || len(p.Value) == len(dateLayout) {
+		t, err := time.Parse(dateLayout, p.Value)
+		if err != nil {
+			return nil, err
+		}
+		return &calendar.EventDateTime{Date: t.Format(apiDateLayout)}, nil
+	}
+
+	t, zone, err := z.parseTime(p.Value, p.Params["TZID"])
+	if err != nil {
+		return nil, err
+	}
+	return &calendar.EventDateTime{
+		DateTime: t.Format(time.RFC3339),
+		TimeZone: zone,
+	}, nil
+}
+
+// parseTime parses a DATE-TIME value and returns the IANA name of its time
+// zone, which is empty when the time zone has no IANA equivalent.
+func (z *zoneResolver) parseTime(value, tzid string) (time.Time, string, error) {
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse(dateTimeUTCLayout, value)
+		return t, "UTC", err
+	}
+
+	name, location := z.location(tzid)
+	t, err := time.ParseInLocation(dateTimeLayout, value, location)
+	return t, name, err
+}
+
+// location resolves a TZID to a location. Well known names are used as is,
+// otherwise the VTIMEZONE definition is used to find an equivalent zone.
+func (z *zoneResolver) location(tzid string) (string, *time.Location) {
+	if tzid == "" {
+		tzid = z.floating
+	}
+
+	if name, location, ok := loadLocation(tzid); ok {
+		return name, location
+	}
+
+	zone, ok := z.zones[tzid]
+	if !ok {
+		return "UTC", time.UTC
+	}
+
+	if name, location, ok := loadLocation(zone.Value("X-LIC-LOCATION")); ok {
+		return name, location
+	}
+
+	standard := zone.ComponentsNamed("STANDARD")
+	if len(standard) == 0 {
+		standard = zone.ComponentsNamed("DAYLIGHT")
+	}
+	if len(standard) == 0 {
+		return "UTC", time.UTC
+	}
+
+	offset, err := parseOffset(standard[0].Value("TZOFFSETTO"))
+	if err != nil {
+		return "UTC", time.UTC
+	}
+
+	location := time.FixedZone(tzid, offset)
+	// Etc/GMT zones have inverted signs and only exist for whole hours
+	if offset%3600 == 0 {
+		hours := -offset / 3600
+		name := "Etc/GMT"
+		if hours > 0 {
+			name += "+" + strconv.Itoa(hours)
+		} else if hours < 0 {
+			name += strconv.Itoa(hours)
+		}
+		if _, etcLocation, ok := loadLocation(name); ok {
+			return name, etcLocation
+		}
+	}
+	return "", location
+}
+
+// loadLocation loads an IANA time zone. Prefixed names such as
+// /mozilla.org/20050126_1/Europe/Berlin are reduced to their IANA suffix.
+func loadLocation(name string) (string, *time.Location, bool) {
+	name = strings.Trim(name, "/")
+	if name == "" {
+		return "", nil, false
+	}
+	segments := strings.Split(name, "/")
+	for i := range segments {
+		candidate := strings.Join(segments[i:], "/")
+		if location, err := time.LoadLocation(candidate); err == nil && candidate != "Local" {
+			return candidate, location, true
+		}
+	}
+	return "", nil, false
+}
+
+// recurrence converts the recurrence properties to the lines expected by the
+// calendar API. Exception and extra dates are converted to UTC so they don't
+// depend on custom VTIMEZONE definitions.
+func (z *zoneResolver) recurrence(c *Component) ([]string, error) {
+	var lines []string
+	for _, p := range c.Properties {
+		switch p.Name {
+		case "RRULE", "EXRULE":
+			lines = append(lines, p.Name+":"+p.Value)
+		case "EXDATE", "RDATE":
+			if p.Params["VALUE"] == "PERIOD" {
+				continue
+			}
+			values, isDate, err := z.dateList(p)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid %s", p.Name)
+			}
+			if isDate {
+				lines = append(lines, p.Name+";VALUE=DATE:"+values)
+			} else {
+				lines = append(lines, p.Name+":"+values)
+			}
+		}
+	}
+	return lines, nil
+}
+
+func (z *zoneResolver) dateList(p Property) (string, bool, error) {
+	var values []string
+	isDate := p.Params["VALUE"] == "DATE"
+	for _, value := range strings.Split(p.Value, ",") {
+		if isDate || len(value) == len(dateLayout) {
+			isDate = true
+			values = append(values, value)
+			continue
+		}
+		t, _, err := z.parseTime(value, p.Params["TZID"])
+		if err != nil {
+			return "", false, err
+		}
+		values = append(values, t.UTC().Format(dateTimeUTCLayout))
+	}
+	return strings.Join(values, ","), isDate, nil
+}
+
+// parseOffset parses a UTC offset such as +0130 into seconds.
+func parseOffset(value string) (int, error) {
+	if len(value) != 5 && len(value) != 7 {
+		return 0, errors.Errorf("invalid offset: %s", value)
+	}
+	sign := 1
+	switch value[0] {
+	case '-':
+		sign = -1
+	case '+':
+	default:
+		return 0, errors.Errorf("invalid offset: %s", value)
+	}
+	hours, err := strconv.Atoi(value[1:3])
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid offset: %s", value)
+	}
+	minutes, err := strconv.Atoi(value[3:5])
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid offset: %s", value)
+	}
+	seconds := 0
+	if len(value) == 7 {
+		if seconds, err = strconv.Atoi(value[5:7]); err != nil {
+			return 0, errors.Wrapf(err, "invalid offset: %s", value)
+		}
+	}
+	return sign * (hours*3600 + minutes*60 + seconds), nil
+}
+
+// parseDuration parses an iCalendar duration such as P1DT2H or -PT15M.
+func parseDuration(value string) (time.Duration, error) {
+	s := value
+	sign := time.Duration(1)
+	if strings.HasPrefix(s, "-") {
+		sign = -1
+		s = s[1:]
+	}
+	s = strings.TrimPrefix(s, "+")
+	if !strings.HasPrefix(s, "P") {
+		return 0, errors.Errorf("invalid duration: %s", value)
+	}
+	s = s[1:]
+
+	var (
+		total  time.Duration
+		inTime bool
+		number string
+	)
+	for _, r := range s {
+		switch {
+		case r == 'T':
+			inTime = true
+		case r >= '0' && r <= '9':
+			number += string(r)
+		default:
+			n, err := strconv.Atoi(number)
+			if err != nil {
+				return 0, errors.Errorf("invalid duration: %s", value)
+			}
+			number = ""
+			switch {
+			case r == 'W' && !inTime:
+				total += time.Duration(n) * 7 * 24 * time.Hour
+			case r == 'D' && !inTime:
+				total += time.Duration(n) * 24 * time.Hour
+			case r == 'H' && inTime:
+				total += time.Duration(n) * time.Hour
+			case r == 'M' && inTime:
+				total += time.Duration(n) * time.Minute
+			case r == 'S' && inTime:
+				total += time.Duration(n) * time.Second
+			default:
+				return 0, errors.Errorf("invalid duration: %s", value)
+			}
+		}
+	}
+	if number != "" {
+		return 0, errors.Errorf("invalid duration: %s", value)
+	}
+	return sign * total, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
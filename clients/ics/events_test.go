@@ -0,0 +1,330 @@
+package ics
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+func parseEvents(t *testing.T, data string) []*calendar.Event {
+	t.Helper()
+	cal, err := Parse(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := Events(cal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func calendarWith(lines ...string) string {
+	return "BEGIN:VCALENDAR\r\n" + strings.Join(lines, "\r\n") + "\r\nEND:VCALENDAR\r\n"
+}
+
+func TestEventFields(t *testing.T) {
+	events := parseEvents(t, calendarWith(
+		"BEGIN:VEVENT",
+		"UID:meeting@example.com",
+		"DTSTART:20300101T100000Z",
+		"DTEND:20300101T110000Z",
+		"SUMMARY:Planning\\, budget\\; review",
+		"DESCRIPTION:First line\\nSecond line with a \\\\ backslash",
+		"LOCATION:Room 1\\, floor 2",
+		"STATUS:CANCELLED",
+		"TRANSP:TRANSPARENT",
+		"CLASS:PRIVATE",
+		"DTSTAMP:20291201T080000Z",
+		"LAST-MODIFIED:20291215T090000Z",
+		"END:VEVENT",
+	))
+
+	want := &calendar.Event{
+		Id:           "meeting@example.com",
+		ICalUID:      "meeting@example.com",
+		Summary:      "Planning, budget; review",
+		Description:  "First line\nSecond line with a \\ backslash",
+		Location:     "Room 1, floor 2",
+		Status:       "cancelled",
+		Transparency: "transparent",
+		Visibility:   "private",
+		Updated:      "2029-12-15T09:00:00Z",
+		Start:        &calendar.EventDateTime{DateTime: "2030-01-01T10:00:00Z", TimeZone: "UTC"},
+		End:          &calendar.EventDateTime{DateTime: "2030-01-01T11:00:00Z", TimeZone: "UTC"},
+	}
+	if len(events) != 1 || !reflect.DeepEqual(events[0], want) {
+		t.Errorf("events = %+v, want %+v", events, want)
+	}
+}
+
+func TestEventDefaults(t *testing.T) {
+	events := parseEvents(t, calendarWith(
+		"BEGIN:VEVENT",
+		"UID:a",
+		"DTSTART:20300101T100000Z",
+		"DTSTAMP:20291201T080000Z",
+		"END:VEVENT",
+	))
+	event := events[0]
+	if event.Status != "confirmed" || event.Transparency != "" || event.Visibility != "" {
+		t.Errorf("status, transparency, visibility = %q, %q, %q", event.Status, event.Transparency, event.Visibility)
+	}
+	if event.Updated != "2029-12-01T08:00:00Z" {
+		t.Errorf("updated = %q, want DTSTAMP", event.Updated)
+	}
+}
+
+func TestEventTimes(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		lines []string
+		start calendar.EventDateTime
+		end   calendar.EventDateTime
+	}{
+		{
+			name:  "all day",
+			lines: []string{"DTSTART;VALUE=DATE:20300101", "DTEND;VALUE=DATE:20300103"},
+			start: calendar.EventDateTime{Date: "2030-01-01"},
+			end:   calendar.EventDateTime{Date: "2030-01-03"},
+		},
+		{
+			name:  "all day without end",
+			lines: []string{"DTSTART;VALUE=DATE:20300101"},
+			start: calendar.EventDateTime{Date: "2030-01-01"},
+			end:   calendar.EventDateTime{Date: "2030-01-02"},
+		},
+		{
+			name:  "all day duration",
+			lines: []string{"DTSTART:20300101", "DURATION:P3D"},
+			start: calendar.EventDateTime{Date: "2030-01-01"},
+			end:   calendar.EventDateTime{Date: "2030-01-04"},
+		},
+		{
+			name:  "duration",
+			lines: []string{"DTSTART:20300101T100000Z", "DURATION:PT1H30M"},
+			start: calendar.EventDateTime{DateTime: "2030-01-01T10:00:00Z", TimeZone: "UTC"},
+			end:   calendar.EventDateTime{DateTime: "2030-01-01T11:30:00Z", TimeZone: "UTC"},
+		},
+		{
+			name:  "iana time zone",
+			lines: []string{"DTSTART;TZID=Europe/Berlin:20300701T100000", "DTEND;TZID=Europe/Berlin:20300701T110000"},
+			start: calendar.EventDateTime{DateTime: "2030-07-01T10:00:00+02:00", TimeZone: "Europe/Berlin"},
+			end:   calendar.EventDateTime{DateTime: "2030-07-01T11:00:00+02:00", TimeZone: "Europe/Berlin"},
+		},
+		{
+			name:  "prefixed time zone",
+			lines: []string{"DTSTART;TZID=/mozilla.org/20050126_1/America/New_York:20300101T100000"},
+			start: calendar.EventDateTime{DateTime: "2030-01-01T10:00:00-05:00", TimeZone: "America/New_York"},
+			end:   calendar.EventDateTime{DateTime: "2030-01-01T10:00:00-05:00", TimeZone: "America/New_York"},
+		},
+		{
+			name:  "vtimezone location",
+			lines: []string{"DTSTART;TZID=Custom Berlin:20300101T100000"},
+			start: calendar.EventDateTime{DateTime: "2030-01-01T10:00:00+01:00", TimeZone: "Europe/Berlin"},
+			end:   calendar.EventDateTime{DateTime: "2030-01-01T10:00:00+01:00", TimeZone: "Europe/Berlin"},
+		},
+		{
+			name:  "vtimezone offset",
+			lines: []string{"DTSTART;TZID=Windows Zone:20300101T100000", "DTEND;TZID=Windows Zone:20300101T120000"},
+			start: calendar.EventDateTime{DateTime: "2030-01-01T10:00:00-03:00", TimeZone: "Etc/GMT+3"},
+			end:   calendar.EventDateTime{DateTime: "2030-01-01T12:00:00-03:00", TimeZone: "Etc/GMT+3"},
+		},
+		{
+			name:  "vtimezone half hour offset",
+			lines: []string{"DTSTART;TZID=Half Hour Zone:20300101T100000"},
+			start: calendar.EventDateTime{DateTime: "2030-01-01T10:00:00+05:30"},
+			end:   calendar.EventDateTime{DateTime: "2030-01-01T10:00:00+05:30"},
+		},
+		{
+			name:  "unknown time zone",
+			lines: []string{"DTSTART;TZID=Nowhere:20300101T100000"},
+			start: calendar.EventDateTime{DateTime: "2030-01-01T10:00:00Z", TimeZone: "UTC"},
+			end:   calendar.EventDateTime{DateTime: "2030-01-01T10:00:00Z", TimeZone: "UTC"},
+		},
+		{
+			name:  "floating",
+			lines: []string{"DTSTART:20300101T100000"},
+			start: calendar.EventDateTime{DateTime: "2030-01-01T10:00:00+09:00", TimeZone: "Asia/Tokyo"},
+			end:   calendar.EventDateTime{DateTime: "2030-01-01T10:00:00+09:00", TimeZone: "Asia/Tokyo"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			lines := []string{
+				"X-WR-TIMEZONE:Asia/Tokyo",
+				"BEGIN:VTIMEZONE",
+				"TZID:Custom Berlin",
+				"X-LIC-LOCATION:Europe/Berlin",
+				"END:VTIMEZONE",
+				"BEGIN:VTIMEZONE",
+				"TZID:Windows Zone",
+				"BEGIN:STANDARD",
+				"DTSTART:16010101T000000",
+				"TZOFFSETFROM:-0300",
+				"TZOFFSETTO:-0300",
+				"END:STANDARD",
+				"END:VTIMEZONE",
+				"BEGIN:VTIMEZONE",
+				"TZID:Half Hour Zone",
+				"BEGIN:STANDARD",
+				"DTSTART:16010101T000000",
+				"TZOFFSETFROM:+0530",
+				"TZOFFSETTO:+0530",
+				"END:STANDARD",
+				"END:VTIMEZONE",
+				"BEGIN:VEVENT",
+				"UID:a",
+			}
+			lines = append(lines, test.lines...)
+			lines = append(lines, "END:VEVENT")
+
+			events := parseEvents(t, calendarWith(lines...))
+			if len(events) != 1 {
+				t.Fatalf("events = %d, want 1", len(events))
+			}
+			if !reflect.DeepEqual(*events[0].Start, test.start) {
+				t.Errorf("start = %+v, want %+v", *events[0].Start, test.start)
+			}
+			if !reflect.DeepEqual(*events[0].End, test.end) {
+				t.Errorf("end = %+v, want %+v", *events[0].End, test.end)
+			}
+		})
+	}
+}
+
+func TestEventRecurrence(t *testing.T) {
+	events := parseEvents(t, calendarWith(
+		"BEGIN:VTIMEZONE",
+		"TZID:Eastern",
+		"X-LIC-LOCATION:America/New_York",
+		"END:VTIMEZONE",
+		"BEGIN:VEVENT",
+		"UID:weekly",
+		"RECURRENCE-ID;TZID=Eastern:20300115T090000",
+		"DTSTART;TZID=Eastern:20300115T100000",
+		"DTEND;TZID=Eastern:20300115T110000",
+		"SUMMARY:Moved",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:weekly",
+		"DTSTART;TZID=Eastern:20300101T090000",
+		"DTEND;TZID=Eastern:20300101T100000",
+		"RRULE:FREQ=WEEKLY;COUNT=10",
+		"EXDATE;TZID=Eastern:20300108T090000,20300122T090000",
+		"RDATE;VALUE=PERIOD:20300201T090000Z/PT1H",
+		"SUMMARY:Weekly",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:daily",
+		"DTSTART:20300101T090000",
+		"RRULE:FREQ=DAILY",
+		"EXDATE:20300102T090000Z",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:birthday",
+		"DTSTART;VALUE=DATE:20300301",
+		"RRULE:FREQ=YEARLY",
+		"EXDATE;VALUE=DATE:20310301",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:birthday",
+		"RECURRENCE-ID;VALUE=DATE:20320301",
+		"DTSTART;VALUE=DATE:20320302",
+		"END:VEVENT",
+	))
+
+	var ids []string
+	for _, event := range events {
+		ids = append(ids, event.Id)
+	}
+	wantIDs := []string{"birthday", "daily", "weekly", "birthday_20320301", "weekly_20300115T140000Z"}
+	if !reflect.DeepEqual(ids, wantIDs) {
+		t.Fatalf("ids = %v, want %v", ids, wantIDs)
+	}
+
+	for _, test := range []struct {
+		event      *calendar.Event
+		recurrence []string
+	}{
+		{events[0], []string{"RRULE:FREQ=YEARLY", "EXDATE;VALUE=DATE:20310301"}},
+		{events[1], []string{"RRULE:FREQ=DAILY", "EXDATE:20300102T090000Z"}},
+		{events[2], []string{"RRULE:FREQ=WEEKLY;COUNT=10", "EXDATE:20300108T140000Z,20300122T140000Z"}},
+	} {
+		if !reflect.DeepEqual(test.event.Recurrence, test.recurrence) {
+			t.Errorf("recurrence of %s = %q, want %q", test.event.Id, test.event.Recurrence, test.recurrence)
+		}
+	}
+
+	// floating recurring events need a time zone to be expanded
+	if daily := events[1]; daily.Start.TimeZone != "UTC" || daily.End.TimeZone != "UTC" {
+		t.Errorf("daily time zones = %q, %q, want UTC", daily.Start.TimeZone, daily.End.TimeZone)
+	}
+
+	for _, test := range []struct {
+		event         *calendar.Event
+		originalStart calendar.EventDateTime
+	}{
+		{events[3], calendar.EventDateTime{Date: "2032-03-01"}},
+		{events[4], calendar.EventDateTime{DateTime: "2030-01-15T09:00:00-05:00", TimeZone: "America/New_York"}},
+	} {
+		if test.event.RecurringEventId != test.event.ICalUID || test.event.Recurrence != nil {
+			t.Errorf("override %s = %+v", test.event.Id, test.event)
+		}
+		if !reflect.DeepEqual(*test.event.OriginalStartTime, test.originalStart) {
+			t.Errorf("original start of %s = %+v, want %+v", test.event.Id, *test.event.OriginalStartTime, test.originalStart)
+		}
+	}
+	if events[4].Summary != "Moved" {
+		t.Errorf("override summary = %q", events[4].Summary)
+	}
+}
+
+func TestEventsMalformed(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		lines []string
+	}{
+		{"missing uid", []string{"DTSTART:20300101T100000Z"}},
+		{"missing start", []string{"UID:a"}},
+		{"invalid start", []string{"UID:a", "DTSTART:tomorrow"}},
+		{"invalid date", []string{"UID:a", "DTSTART;VALUE=DATE:2030-01-01"}},
+		{"invalid end", []string{"UID:a", "DTSTART:20300101T100000Z", "DTEND:20300101T1100"}},
+		{"invalid duration", []string{"UID:a", "DTSTART:20300101T100000Z", "DURATION:1H"}},
+		{"invalid duration unit", []string{"UID:a", "DTSTART:20300101T100000Z", "DURATION:PT1X"}},
+		{"invalid recurrence id", []string{"UID:a", "DTSTART:20300101T100000Z", "RECURRENCE-ID:soon"}},
+		{"invalid exdate", []string{"UID:a", "DTSTART:20300101T100000Z", "RRULE:FREQ=DAILY", "EXDATE:2030-01-02"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			lines := append([]string{"BEGIN:VEVENT"}, test.lines...)
+			lines = append(lines, "END:VEVENT")
+			cal, err := Parse(strings.NewReader(calendarWith(lines...)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Events(cal); err == nil {
+				t.Error("malformed event converted")
+			}
+		})
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	for value, want := range map[string]string{
+		"PT15M":    "15m0s",
+		"-PT15M":   "-15m0s",
+		"P1DT2H":   "26h0m0s",
+		"P1W":      "168h0m0s",
+		"PT1H2M3S": "1h2m3s",
+	} {
+		got, err := parseDuration(value)
+		if err != nil {
+			t.Errorf("parseDuration(%q) failed: %v", value, err)
+			continue
+		}
+		if got.String() != want {
+			t.Errorf("parseDuration(%q) = %s, want %s", value, got, want)
+		}
+	}
+}
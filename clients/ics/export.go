@@ -0,0 +1,250 @@
+package ics
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/calendar/v3"
+)
+
+const productID = "-//calendar-sync//calendar-sync//EN"
+
+// Calendar converts events of the calendar API to a VCALENDAR, the reverse
+// of Events. Instances of recurring events are written as overrides with the
+// UID of their recurring event and cancelled instances as exception dates of
+// their recurring event. Time zones used by the events are included.
+func Calendar(events []*calendar.Event) (*Component, error) {
+	b := &calendarBuilder{
+		zones: make(map[string]bool),
+		from:  time.Now(),
+	}
+
+	masters := make(map[string]*Component)
+	var ids []string
+	var instances []*calendar.Event
+	for _, event := range events {
+		if event.RecurringEventId != "" {
+			instances = append(instances, event)
+			continue
+		}
+		if event.Status == "cancelled" {
+			continue
+		}
+		c, err := b.event(event, event.Id)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid event %s", event.Id)
+		}
+		masters[event.Id] = c
+		ids = append(ids, event.Id)
+	}
+	sort.Strings(ids)
+
+	overrides := make(map[string][]*Component)
+	sort.SliceStable(instances, func(i, j int) bool {
+		return instances[i].Id < instances[j].Id
+	})
+	for _, event := range instances {
+		master, ok := masters[event.RecurringEventId]
+		if event.Status == "cancelled" {
+			if ok && event.OriginalStartTime != nil {
+				master.Properties = append(master.Properties, exceptionDate(event.OriginalStartTime))
+			}
+			continue
+		}
+
+		c, err := b.event(event, event.RecurringEventId)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid event %s", event.Id)
+		}
+		if !ok {
+			// the recurring event is not exported, the instance stands alone
+			ids = append(ids, event.Id)
+			masters[event.Id] = c
+			continue
+		}
+		overrides[event.RecurringEventId] = append(overrides[event.RecurringEventId], c)
+	}
+
+	cal := &Component{
+		Name: ComponentCalendar,
+		Properties: []Property{
+			{Name: "VERSION", Value: "2.0"},
+			{Name: "PRODID", Value: productID},
+			{Name: "CALSCALE", Value: "GREGORIAN"},
+		},
+	}
+
+	zoneNames := make([]string, 0, len(b.zones))
+	for name := range b.zones {
+		zoneNames = append(zoneNames, name)
+	}
+	sort.Strings(zoneNames)
+	for _, name := range zoneNames {
+		zone, err := timeZone(name, b.from)
+		if err != nil {
+			return nil, err
+		}
+		cal.Components = append(cal.Components, zone)
+	}
+
+	for _, id := range ids {
+		cal.Components = append(cal.Components, masters[id])
+		cal.Components = append(cal.Components, overrides[id]...)
+	}
+
+	return cal, nil
+}
+
+// calendarBuilder collects the time zones used by the events and the
+// earliest time they are needed for.
+type calendarBuilder struct {
+	zones map[string]bool
+	from  time.Time
+}
+
+func (b *calendarBuilder) event(event *calendar.Event, uid string) (*Component, error) {
+	if event.Start == nil {
+		return nil, errors.New("missing start")
+	}
+
+	c := &Component{Name: ComponentEvent}
+	add := func(name, value string) {
+		if value != "" {
+			c.Properties = append(c.Properties, Property{Name: name, Value: value})
+		}
+	}
+
+	add("UID", uid)
+	add("DTSTAMP", stamp(firstNonEmpty(event.Updated, event.Created)))
+
+	start, err := b.dateTime("DTSTART", event.Start)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid start")
+	}
+	c.Properties = append(c.Properties, start)
+
+	if event.End != nil {
+		end, err := b.dateTime("DTEND", event.End)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid end")
+		}
+		c.Properties = append(c.Properties, end)
+	}
+
+	if event.RecurringEventId != "" && event.OriginalStartTime != nil {
+		recurrenceID, err := b.dateTime("RECURRENCE-ID", event.OriginalStartTime)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid original start")
+		}
+		c.Properties = append(c.Properties, recurrenceID)
+	}
+
+	for _, line := range event.Recurrence {
+		p, err := parseLine(line)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid recurrence")
+		}
+		if tzid := p.Params["TZID"]; tzid != "" {
+			if _, err := time.LoadLocation(tzid); err != nil {
+				return nil, errors.Errorf("unknown time zone %s", tzid)
+			}
+			b.zones[tzid] = true
+		}
+		c.Properties = append(c.Properties, p)
+	}
+
+	add("SUMMARY", Escape(event.Summary))
+	add("DESCRIPTION", Escape(event.Description))
+	add("LOCATION", Escape(event.Location))
+	add("STATUS", strings.ToUpper(event.Status))
+	if event.Transparency == "transparent" {
+		add("TRANSP", "TRANSPARENT")
+	} else {
+		add("TRANSP", "OPAQUE")
+	}
+	switch event.Visibility {
+	case "public":
+		add("CLASS", "PUBLIC")
+	case "private":
+		add("CLASS", "PRIVATE")
+	case "confidential":
+		add("CLASS", "CONFIDENTIAL")
+	}
+	if event.Created != "" {
+		add("CREATED", stamp(event.Created))
+	}
+	if event.Updated != "" {
+		add("LAST-MODIFIED", stamp(event.Updated))
+	}
+
+	return c, nil
+}
+
+// dateTime converts a start, end or original start. Date times are written
+// in their time zone when they have one and in UTC otherwise.
+func (b *calendarBuilder) dateTime(name string, dt *calendar.EventDateTime) (Property, error) {
+	if dt.Date != "" {
+		t, err := time.Parse(apiDateLayout, dt.Date)
+		if err != nil {
+			return Property{}, err
+		}
+		b.include(t)
+		return Property{
+			Name:   name,
+			Params: map[string]string{"VALUE": "DATE"},
+			Value:  t.Format(dateLayout),
+		}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, dt.DateTime)
+	if err != nil {
+		return Property{}, err
+	}
+	b.include(t)
+
+	if dt.TimeZone != "" && dt.TimeZone != "UTC" {
+		if location, err := time.LoadLocation(dt.TimeZone); err == nil {
+			b.zones[dt.TimeZone] = true
+			return Property{
+				Name:   name,
+				Params: map[string]string{"TZID": dt.TimeZone},
+				Value:  t.In(location).Format(dateTimeLayout),
+			}, nil
+		}
+	}
+
+	return Property{Name: name, Value: t.UTC().Format(dateTimeUTCLayout)}, nil
+}
+
+func (b *calendarBuilder) include(t time.Time) {
+	if t.Before(b.from) {
+		b.from = t
+	}
+}
+
+func exceptionDate(originalStart *calendar.EventDateTime) Property {
+	if originalStart.Date != "" {
+		return Property{
+			Name:   "EXDATE",
+			Params: map[string]string{"VALUE": "DATE"},
+			Value:  strings.ReplaceAll(originalStart.Date, "-", ""),
+		}
+	}
+	value := originalStart.DateTime
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		value = t.UTC().Format(dateTimeUTCLayout)
+	}
+	return Property{Name: "EXDATE", Value: value}
+}
+
+// stamp converts an RFC 3339 timestamp of the calendar API to UTC, using the
+// current time when it is missing or invalid.
+func stamp(value string) string {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t = time.Now()
+	}
+	return t.UTC().Format(dateTimeUTCLayout)
+}
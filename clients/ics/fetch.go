@@ -0,0 +1,76 @@
+package ics
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// MaxFeedSize is the largest calendar feed that is downloaded.
+const MaxFeedSize = 32 << 20
+
+// FetchResult is the response to a conditional calendar request. Body is
+// empty when the calendar was not modified.
+type FetchResult struct {
+	Body         []byte
+	ETag         string
+	LastModified string
+	NotModified  bool
+}
+
+// Fetch downloads a calendar feed. The validators of a previous response
+// are sent so the server can reply that the calendar was not modified.
+func Fetch(ctx context.Context, client *http.Client, feedURL, etag, lastModified string) (FetchResult, error) {
+	// webcal is the same as http(s), it only signals calendar clients
+	if strings.HasPrefix(feedURL, "webcal://") {
+		feedURL = "https://" + strings.TrimPrefix(feedURL, "webcal://")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feedURL, nil)
+	if err != nil {
+		return FetchResult{}, errors.New("invalid calendar url")
+	}
+	req.Header.Set("Accept", "text/calendar")
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// the url can contain secrets, so it is left out of the error
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return FetchResult{}, errors.Wrap(err, "failed to fetch calendar")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return FetchResult{NotModified: true, ETag: etag, LastModified: lastModified}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return FetchResult{}, errors.Errorf("failed to fetch calendar: %s", resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, MaxFeedSize+1))
+	if err != nil {
+		return FetchResult{}, errors.Wrap(err, "failed to read calendar")
+	}
+	if len(body) > MaxFeedSize {
+		return FetchResult{}, errors.Errorf("calendar is larger than %d bytes", MaxFeedSize)
+	}
+
+	return FetchResult{
+		Body:         body,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}, nil
+}
@@ -0,0 +1,89 @@
+package ics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const feedBody = "BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n"
+
+func TestFetchConditional(t *testing.T) {
+	const (
+		etag         = `"v1"`
+		lastModified = "Mon, 02 Jan 2006 15:04:05 GMT"
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag && r.Header.Get("If-Modified-Since") == lastModified {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", lastModified)
+		w.Write([]byte(feedBody))
+	}))
+	defer server.Close()
+
+	first, err := Fetch(context.Background(), server.Client(), server.URL, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.NotModified || string(first.Body) != feedBody || first.ETag != etag || first.LastModified != lastModified {
+		t.Fatalf("first fetch = %+v", first)
+	}
+
+	second, err := Fetch(context.Background(), server.Client(), server.URL, first.ETag, first.LastModified)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !second.NotModified || second.Body != nil || second.ETag != etag || second.LastModified != lastModified {
+		t.Fatalf("conditional fetch = %+v", second)
+	}
+}
+
+func TestFetchWebcal(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/feed.ics" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(feedBody))
+	}))
+	defer server.Close()
+
+	url := "webcal://" + strings.TrimPrefix(server.URL, "https://") + "/feed.ics"
+	result, err := Fetch(context.Background(), server.Client(), url, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result.Body) != feedBody {
+		t.Fatalf("body = %q, want %q", result.Body, feedBody)
+	}
+}
+
+func TestFetchTooLarge(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, MaxFeedSize+1))
+	}))
+	defer server.Close()
+
+	if _, err := Fetch(context.Background(), server.Client(), server.URL, "", ""); err == nil {
+		t.Fatal("fetched a calendar larger than the limit")
+	}
+}
+
+func TestFetchErrorOmitsURL(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	url := server.URL + "/private-secret/basic.ics"
+	_, err := Fetch(context.Background(), http.DefaultClient, url, "", "")
+	if err == nil {
+		t.Fatal("fetched from a closed server")
+	}
+	if strings.Contains(err.Error(), "private-secret") {
+		t.Fatalf("error reveals the url: %v", err)
+	}
+}
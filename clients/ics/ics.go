@@ -0,0 +1,238 @@
+package ics
+
+import (
+	"bufio"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	ComponentCalendar = "VCALENDAR"
+	ComponentEvent    = "VEVENT"
+	ComponentTimeZone = "VTIMEZONE"
+)
+
+// Component is an iCalendar component such as VCALENDAR, VEVENT or VTIMEZONE.
+type Component struct {
+	Name       string
+	Properties []Property
+	Components []*Component
+}
+
+// Property is a content line. Value is kept as it appears in the file, text
+// values have to be unescaped with Unescape.
+type Property struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+// Parse reads an iCalendar stream and returns its VCALENDAR component.
+func Parse(r io.Reader) (*Component, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		root  *Component
+		stack []*Component
+	)
+	for i, line := range lines {
+		if line == "" {
+			continue
+		}
+
+		p, err := parseLine(line)
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d", i+1)
+		}
+
+		switch p.Name {
+		case "BEGIN":
+			c := &Component{Name: strings.ToUpper(p.Value)}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Components = append(parent.Components, c)
+			} else if root == nil {
+				root = c
+			}
+			stack = append(stack, c)
+		case "END":
+			if len(stack) == 0 || stack[len(stack)-1].Name != strings.ToUpper(p.Value) {
+				return nil, errors.Errorf("line %d: unexpected END:%s", i+1, p.Value)
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			if len(stack) == 0 {
+				return nil, errors.Errorf("line %d: property outside of a component", i+1)
+			}
+			c := stack[len(stack)-1]
+			c.Properties = append(c.Properties, p)
+		}
+	}
+
+	if root == nil || root.Name != ComponentCalendar {
+		return nil, errors.New("no VCALENDAR component found")
+	}
+	if len(stack) != 0 {
+		return nil, errors.Errorf("component %s not closed", stack[len(stack)-1].Name)
+	}
+	return root, nil
+}
+
+// Property returns the first property with the given name or nil.
+func (c *Component) Property(name string) *Property {
+	for i := range c.Properties {
+		if c.Properties[i].Name == name {
+			return &c.Properties[i]
+		}
+	}
+	return nil
+}
+
+// Value returns the value of the first property with the given name, or an
+// empty string if there is none.
+func (c *Component) Value(name string) string {
+	if p := c.Property(name); p != nil {
+		return p.Value
+	}
+	return ""
+}
+
+// Text returns the unescaped value of a text property.
+func (c *Component) Text(name string) string {
+	return Unescape(c.Value(name))
+}
+
+// PropertiesNamed returns all the properties with the given name.
+func (c *Component) PropertiesNamed(name string) []Property {
+	var result []Property
+	for _, p := range c.Properties {
+		if p.Name == name {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// ComponentsNamed returns the direct sub components with the given name.
+func (c *Component) ComponentsNamed(name string) []*Component {
+	var result []*Component
+	for _, sub := range c.Components {
+		if sub.Name == name {
+			result = append(result, sub)
+		}
+	}
+	return result
+}
+
+// Unescape decodes an iCalendar TEXT value.
+func Unescape(value string) string {
+	if !strings.Contains(value, "\\") {
+		return value
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i == len(value)-1 {
+			b.WriteByte(value[i])
+			continue
+		}
+		i++
+		switch value[i] {
+		case 'n', 'N':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(value[i])
+		}
+	}
+	return b.String()
+}
+
+// unfold joins the content lines split over multiple physical lines.
+func unfold(r io.Reader) ([]string, error) {
+	var lines []string
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read calendar")
+	}
+
+	return lines, nil
+}
+
+func parseLine(line string) (Property, error) {
+	p := Property{Params: make(map[string]string)}
+
+	end := strings.IndexAny(line, ";:")
+	if end <= 0 {
+		return p, errors.Errorf("invalid content line: %q", line)
+	}
+	p.Name = strings.ToUpper(line[:end])
+	rest := line[end:]
+
+	for strings.HasPrefix(rest, ";") {
+		rest = rest[1:]
+
+		eq := strings.IndexByte(rest, '=')
+		if eq <= 0 {
+			return p, errors.Errorf("invalid parameter in line: %q", line)
+		}
+		name := strings.ToUpper(rest[:eq])
+		rest = rest[eq+1:]
+
+		value, remaining, err := parseParamValue(rest)
+		if err != nil {
+			return p, errors.Wrapf(err, "invalid parameter %s", name)
+		}
+		p.Params[name] = value
+		rest = remaining
+	}
+
+	if !strings.HasPrefix(rest, ":") {
+		return p, errors.Errorf("missing value in line: %q", line)
+	}
+	p.Value = rest[1:]
+
+	return p, nil
+}
+
+// parseParamValue reads a possibly quoted parameter value, including
+// comma separated lists, and returns the rest of the line.
+func parseParamValue(s string) (string, string, error) {
+	var b strings.Builder
+	for {
+		if strings.HasPrefix(s, `"`) {
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				return "", "", errors.New("unterminated quoted value")
+			}
+			b.WriteString(s[1 : end+1])
+			s = s[end+2:]
+		} else {
+			end := strings.IndexAny(s, ",;:")
+			if end < 0 {
+				return "", "", errors.New("missing value")
+			}
+			b.WriteString(s[:end])
+			s = s[end:]
+		}
+
+		if !strings.HasPrefix(s, ",") {
+			return b.String(), s, nil
+		}
+		b.WriteByte(',')
+		s = s[1:]
+	}
+}
@@ -0,0 +1,104 @@
+package ics
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	data := "BEGIN:VCALENDAR\r\n" +
+		"VERSION:2.0\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"UID:event@example.com\r\n" +
+		"DESCRIPTION:A long description that was folded over\r\n" +
+		"  two lines\r\n" +
+		"\tand a tab\r\n" +
+		"ATTENDEE;CN=\"Doe, Jane\";ROLE=REQ-PARTICIPANT;DELEGATED-TO=\"a@example.com\",\"b@example.com\":mailto:jane@example.com\r\n" +
+		"summary:Lower case name\r\n" +
+		"END:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+
+	cal, err := Parse(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cal.Name != ComponentCalendar || cal.Value("VERSION") != "2.0" {
+		t.Fatalf("calendar = %+v", cal)
+	}
+	events := cal.ComponentsNamed(ComponentEvent)
+	if len(events) != 1 {
+		t.Fatalf("events = %d, want 1", len(events))
+	}
+	event := events[0]
+
+	if got, want := event.Value("DESCRIPTION"), "A long description that was folded over two linesand a tab"; got != want {
+		t.Errorf("unfolded description = %q, want %q", got, want)
+	}
+	if got := event.Value("SUMMARY"); got != "Lower case name" {
+		t.Errorf("summary = %q, property names are case insensitive", got)
+	}
+
+	attendee := event.Property("ATTENDEE")
+	if attendee == nil {
+		t.Fatal("attendee not parsed")
+	}
+	wantParams := map[string]string{
+		"CN":           "Doe, Jane",
+		"ROLE":         "REQ-PARTICIPANT",
+		"DELEGATED-TO": "a@example.com,b@example.com",
+	}
+	if !reflect.DeepEqual(attendee.Params, wantParams) || attendee.Value != "mailto:jane@example.com" {
+		t.Errorf("attendee = %+v", attendee)
+	}
+}
+
+func TestParseUnixLineEndings(t *testing.T) {
+	cal, err := Parse(strings.NewReader("BEGIN:VCALENDAR\nX-WR-CALNAME:Feed\nEND:VCALENDAR\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cal.Value("X-WR-CALNAME") != "Feed" {
+		t.Errorf("calendar name = %q", cal.Value("X-WR-CALNAME"))
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		data string
+	}{
+		{"empty", ""},
+		{"no calendar", "BEGIN:VEVENT\nEND:VEVENT\n"},
+		{"not closed", "BEGIN:VCALENDAR\nBEGIN:VEVENT\nEND:VEVENT\n"},
+		{"mismatched end", "BEGIN:VCALENDAR\nBEGIN:VEVENT\nEND:VCALENDAR\n"},
+		{"end without begin", "END:VCALENDAR\n"},
+		{"property outside", "VERSION:2.0\nBEGIN:VCALENDAR\nEND:VCALENDAR\n"},
+		{"no value", "BEGIN:VCALENDAR\nVERSION\nEND:VCALENDAR\n"},
+		{"no name", "BEGIN:VCALENDAR\n:2.0\nEND:VCALENDAR\n"},
+		{"parameter without value", "BEGIN:VCALENDAR\nX-A;PARAM:1\nEND:VCALENDAR\n"},
+		{"parameter at end", "BEGIN:VCALENDAR\nX-A;PARAM=1\nEND:VCALENDAR\n"},
+		{"unterminated quote", "BEGIN:VCALENDAR\nX-A;PARAM=\"1:2\nEND:VCALENDAR\n"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := Parse(strings.NewReader(test.data)); err == nil {
+				t.Error("malformed calendar parsed")
+			}
+		})
+	}
+}
+
+func TestUnescape(t *testing.T) {
+	for value, want := range map[string]string{
+		"plain":                   "plain",
+		`a\, b\; c`:               "a, b; c",
+		`line\nbreak\Nagain`:      "line\nbreak\nagain",
+		`back\\slash`:             `back\slash`,
+		`trailing\`:               `trailing\`,
+		`\\n is not a line break`: `\n is not a line break`,
+	} {
+		if got := Unescape(value); got != want {
+			t.Errorf("Unescape(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
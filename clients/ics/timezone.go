@@ -0,0 +1,133 @@
+package ics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// timeZone builds the VTIMEZONE of an IANA time zone. The offset changes
+// since the start of the year of from are written as single observances,
+// the ones of the current year repeat yearly so later occurrences of
+// recurring events are resolved too.
+func timeZone(name string, from time.Time) (*Component, error) {
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unknown time zone %s", name)
+	}
+
+	start := time.Date(from.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	lastYear := time.Now().Year()
+	end := time.Date(lastYear+1, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	zone := &Component{
+		Name: ComponentTimeZone,
+		Properties: []Property{
+			{Name: "TZID", Value: name},
+			{Name: "X-LIC-LOCATION", Value: name},
+		},
+	}
+
+	_, offset := start.In(location).Zone()
+	zone.Components = append(zone.Components, observance(start.In(location), offset))
+
+	transitions := offsetTransitions(location, start, end)
+	rules := make(map[bool]int)
+	for i, t := range transitions {
+		if t.Year() == lastYear {
+			rules[t.IsDST()] = i
+		}
+	}
+
+	for i, t := range transitions {
+		c := observance(t, offset)
+		if rules[t.IsDST()] == i && t.Year() == lastYear && len(rules) == 2 {
+			c.Properties = append(c.Properties, Property{Name: "RRULE", Value: yearlyRule(t, offset)})
+		}
+		zone.Components = append(zone.Components, c)
+		_, offset = t.Zone()
+	}
+
+	return zone, nil
+}
+
+// observance describes the offset in effect from t. Its start is in the
+// local time of the previous offset.
+func observance(t time.Time, previousOffset int) *Component {
+	abbreviation, offset := t.Zone()
+
+	name := "STANDARD"
+	if t.IsDST() {
+		name = "DAYLIGHT"
+	}
+
+	return &Component{
+		Name: name,
+		Properties: []Property{
+			{Name: "DTSTART", Value: localStart(t, previousOffset).Format(dateTimeLayout)},
+			{Name: "TZOFFSETFROM", Value: formatOffset(previousOffset)},
+			{Name: "TZOFFSETTO", Value: formatOffset(offset)},
+			{Name: "TZNAME", Value: Escape(abbreviation)},
+		},
+	}
+}
+
+// yearlyRule describes a transition as the nth or last weekday of its month,
+// which is how daylight saving time rules are defined.
+func yearlyRule(t time.Time, previousOffset int) string {
+	local := localStart(t, previousOffset)
+	daysInMonth := time.Date(local.Year(), local.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+
+	week := (local.Day()-1)/7 + 1
+	if local.Day()+7 > daysInMonth {
+		week = -1
+	}
+
+	weekday := strings.ToUpper(local.Weekday().String()[:2])
+	return fmt.Sprintf("FREQ=YEARLY;BYMONTH=%d;BYDAY=%d%s", local.Month(), week, weekday)
+}
+
+func localStart(t time.Time, offset int) time.Time {
+	return t.UTC().Add(time.Duration(offset) * time.Second)
+}
+
+// offsetTransitions returns the instants the UTC offset of a location changes
+// between start and end, with a precision of one minute.
+func offsetTransitions(location *time.Location, start, end time.Time) []time.Time {
+	var transitions []time.Time
+
+	_, previous := start.In(location).Zone()
+	for day := start; day.Before(end); day = day.Add(24 * time.Hour) {
+		next := day.Add(24 * time.Hour)
+		if _, offset := next.In(location).Zone(); offset == previous {
+			continue
+		}
+
+		minute := sort.Search(24*60, func(i int) bool {
+			_, offset := day.Add(time.Duration(i+1) * time.Minute).In(location).Zone()
+			return offset != previous
+		})
+		t := day.Add(time.Duration(minute+1) * time.Minute).In(location)
+		transitions = append(transitions, t)
+		_, previous = t.Zone()
+	}
+
+	return transitions
+}
+
+// formatOffset formats a UTC offset in seconds such as +0130.
+func formatOffset(offset int) string {
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+	value := fmt.Sprintf("%s%02d%02d", sign, offset/3600, offset%3600/60)
+	if seconds := offset % 60; seconds != 0 {
+		value += fmt.Sprintf("%02d", seconds)
+	}
+	return value
+}
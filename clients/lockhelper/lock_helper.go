@@ -2,7 +2,7 @@ package lockhelper
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 
 	"github.com/hashicorp/go-multierror"
@@ -13,7 +13,7 @@ import (
 func FilePath(filename string) string {
 	wd, err := os.Getwd()
 	if err != nil {
-		log.Println(err)
+		slog.Warn("failed to get work directory", "error", err)
 	}
 	return fmt.Sprintf("%s/%s", wd, filename)
 }
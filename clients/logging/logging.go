@@ -0,0 +1,70 @@
+package logging
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+type Options struct {
+	Level  string
+	Format string
+	// File is optional, logs are written to stderr when it is empty.
+	File       string
+	MaxSizeMB  int
+	MaxBackups int
+	MaxAgeDays int
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// Setup configures the default slog logger. The returned closer releases
+// the log file, if one is used.
+func Setup(options Options) (io.Closer, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(options.Level)); err != nil {
+		return nil, errors.Errorf("invalid log level: %s", options.Level)
+	}
+
+	var (
+		writer io.Writer = os.Stderr
+		closer io.Closer = nopCloser{}
+	)
+	if options.File != "" {
+		file := &lumberjack.Logger{
+			Filename:   options.File,
+			MaxSize:    options.MaxSizeMB,
+			MaxBackups: options.MaxBackups,
+			MaxAge:     options.MaxAgeDays,
+		}
+		writer, closer = file, file
+	}
+
+	handlerOptions := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	switch strings.ToLower(options.Format) {
+	case FormatText:
+		handler = slog.NewTextHandler(writer, handlerOptions)
+	case FormatJSON:
+		handler = slog.NewJSONHandler(writer, handlerOptions)
+	default:
+		return nil, errors.Errorf("invalid log format: %s", options.Format)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return closer, nil
+}
@@ -0,0 +1,158 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/api/googleapi"
+
+	"github.com/robertdolca/calendar-sync/clients/syncdb"
+)
+
+const namespace = "calendar_sync"
+
+const (
+	ResultSuccess = "success"
+	ResultError   = "error"
+)
+
+var (
+	registry = prometheus.NewRegistry()
+
+	events = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_total",
+		Help:      "Source events processed, by sync pair and outcome.",
+	}, []string{"pair", "outcome"})
+
+	operations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "operations_total",
+		Help:      "Write operations on destination calendars, by sync pair, operation and result.",
+	}, []string{"pair", "operation", "result"})
+
+	apiErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "api_errors_total",
+		Help:      "Calendar API errors, by HTTP status code.",
+	}, []string{"code"})
+
+	rateLimiterWait = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limiter_wait_seconds_total",
+		Help:      "Time spent waiting for the rate limiter, by sync pair.",
+	}, []string{"pair"})
+
+	runDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "run_duration_seconds",
+		Help:      "Sync run duration, by sync pair and result.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+	}, []string{"pair", "result"})
+
+	lastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Unix time of the last successful sync run, by sync pair.",
+	}, []string{"pair"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		events,
+		operations,
+		apiErrors,
+		rateLimiterWait,
+		runDuration,
+		lastSuccess,
+	)
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// RegisterSyncDB exposes the sync database record counts, read on every scrape.
+func RegisterSyncDB(db *syncdb.DB) error {
+	return errors.Wrap(registry.Register(&syncDBCollector{db: db}), "failed to register sync db metrics")
+}
+
+func Event(pair, outcome string) {
+	events.WithLabelValues(pair, outcome).Inc()
+}
+
+func Operation(pair, operation string, err error) {
+	result := ResultSuccess
+	if err != nil {
+		result = ResultError
+	}
+	operations.WithLabelValues(pair, operation, result).Inc()
+}
+
+func APIError(err error) {
+	code := "unknown"
+	switch cause := errors.Cause(err).(type) {
+	case *googleapi.Error:
+		code = strconv.Itoa(cause.Code)
+	case interface{ HTTPStatus() int }:
+		code = strconv.Itoa(cause.HTTPStatus())
+	}
+	apiErrors.WithLabelValues(code).Inc()
+}
+
+func RateLimiterWait(pair string, wait time.Duration) {
+	rateLimiterWait.WithLabelValues(pair).Add(wait.Seconds())
+}
+
+func Run(pair string, duration time.Duration, err error) {
+	result := ResultSuccess
+	if err != nil {
+		result = ResultError
+	}
+	runDuration.WithLabelValues(pair, result).Observe(duration.Seconds())
+	if err == nil {
+		lastSuccess.WithLabelValues(pair).SetToCurrentTime()
+	}
+}
+
+type syncDBCollector struct {
+	db *syncdb.DB
+}
+
+var (
+	syncDBRecordsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "syncdb", "records"),
+		"Sync records in the local database, by state.",
+		[]string{"state"}, nil,
+	)
+	syncDBUpDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "syncdb", "up"),
+		"Whether the local database could be read.",
+		nil, nil,
+	)
+)
+
+func (c *syncDBCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- syncDBRecordsDesc
+	ch <- syncDBUpDesc
+}
+
+func (c *syncDBCollector) Collect(ch chan<- prometheus.Metric) {
+	stats, err := c.db.Stats()
+	if err != nil {
+		ch <- prometheus.MustNewConstMetric(syncDBUpDesc, prometheus.GaugeValue, 0)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(syncDBUpDesc, prometheus.GaugeValue, 1)
+	ch <- prometheus.MustNewConstMetric(syncDBRecordsDesc, prometheus.GaugeValue, float64(stats.Records-stats.SoftDeleted), "active")
+	ch <- prometheus.MustNewConstMetric(syncDBRecordsDesc, prometheus.GaugeValue, float64(stats.SoftDeleted), "soft_deleted")
+	ch <- prometheus.MustNewConstMetric(syncDBRecordsDesc, prometheus.GaugeValue, float64(stats.Failures), "failed")
+}
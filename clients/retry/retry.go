@@ -0,0 +1,193 @@
+package retry
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/googleapi"
+
+	"github.com/robertdolca/calendar-sync/clients/metrics"
+)
+
+const (
+	reasonRateLimitExceeded     = "rateLimitExceeded"
+	reasonUserRateLimitExceeded = "userRateLimitExceeded"
+)
+
+var (
+	DefaultPolicy = Policy{
+		MaxAttempts:    6,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     32 * time.Second,
+		Budget:         100,
+	}
+)
+
+// StatusError is implemented by the errors of HTTP APIs other than the
+// Google ones, so they are retried the same way.
+type StatusError interface {
+	error
+	HTTPStatus() int
+	HTTPHeader() http.Header
+}
+
+// Policy controls how failed API calls are retried. Budget caps the total
+// number of retries a single Retrier performs across all calls, so a run
+// against a struggling API gives up instead of retrying every event.
+type Policy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Budget         int
+}
+
+type Retrier struct {
+	policy  Policy
+	onQuota func()
+
+	mutex  sync.Mutex
+	budget int
+	calls  int
+	random *rand.Rand
+}
+
+// New creates a retrier. onQuota is optional and is called every time a
+// quota error is encountered, before backing off.
+func New(policy Policy, onQuota func()) *Retrier {
+	return &Retrier{
+		policy:  policy,
+		onQuota: onQuota,
+		budget:  policy.Budget,
+		random:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Do runs call until it succeeds, returns a non retryable error, the
+// attempts or the budget are exhausted or the context is cancelled.
+func (r *Retrier) Do(ctx context.Context, call func() error) error {
+	for attempt := 0; ; attempt++ {
+		r.count()
+		err := call()
+		if err == nil {
+			return nil
+		}
+		metrics.APIError(err)
+
+		retryable, quota := Classify(err)
+		if !retryable {
+			return err
+		}
+		if quota && r.onQuota != nil {
+			r.onQuota()
+		}
+		if attempt+1 >= r.policy.MaxAttempts {
+			return err
+		}
+		if !r.take() {
+			return errors.Wrap(err, "retry budget exhausted")
+		}
+
+		timer := time.NewTimer(r.backoff(attempt, err))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Classify reports whether err is worth retrying and whether it was caused
+// by exceeding a quota.
+func Classify(err error) (retryable bool, quota bool) {
+	if statusErr, ok := errors.Cause(err).(StatusError); ok {
+		code := statusErr.HTTPStatus()
+		return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError, code == http.StatusTooManyRequests
+	}
+
+	apiErr, ok := errors.Cause(err).(*googleapi.Error)
+	if !ok {
+		return false, false
+	}
+	switch {
+	case apiErr.Code == http.StatusTooManyRequests:
+		return true, true
+	case apiErr.Code == http.StatusForbidden:
+		for _, item := range apiErr.Errors {
+			if item.Reason == reasonRateLimitExceeded || item.Reason == reasonUserRateLimitExceeded {
+				return true, true
+			}
+		}
+		return false, false
+	case apiErr.Code >= http.StatusInternalServerError:
+		return true, false
+	}
+	return false, false
+}
+
+// Calls returns the number of calls made, including retries.
+func (r *Retrier) Calls() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.calls
+}
+
+func (r *Retrier) count() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.calls++
+}
+
+func (r *Retrier) take() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.budget <= 0 {
+		return false
+	}
+	r.budget--
+	return true
+}
+
+// backoff returns a full jitter exponential delay, unless the server asked
+// for a specific delay through the Retry-After header. Neither is longer than
+// the maximum backoff of the policy.
+func (r *Retrier) backoff(attempt int, err error) time.Duration {
+	if delay, ok := retryAfter(err); ok {
+		if delay > r.policy.MaxBackoff {
+			return r.policy.MaxBackoff
+		}
+		return delay
+	}
+
+	ceiling := r.policy.InitialBackoff << uint(attempt)
+	if ceiling <= 0 || ceiling > r.policy.MaxBackoff {
+		ceiling = r.policy.MaxBackoff
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return time.Duration(r.random.Int63n(int64(ceiling) + 1))
+}
+
+func retryAfter(err error) (time.Duration, bool) {
+	var header http.Header
+	switch cause := errors.Cause(err).(type) {
+	case *googleapi.Error:
+		header = cause.Header
+	case StatusError:
+		header = cause.HTTPHeader()
+	}
+	if header == nil {
+		return 0, false
+	}
+	seconds, convErr := strconv.Atoi(header.Get("Retry-After"))
+	if convErr != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
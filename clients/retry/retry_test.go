@@ -0,0 +1,29 @@
+package retry
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+func TestBackoffCapsRetryAfter(t *testing.T) {
+	r := New(DefaultPolicy, nil)
+
+	for _, test := range []struct {
+		retryAfter string
+		want       time.Duration
+	}{
+		{"3", 3 * time.Second},
+		{"86400", DefaultPolicy.MaxBackoff},
+	} {
+		err := &googleapi.Error{
+			Code:   http.StatusServiceUnavailable,
+			Header: http.Header{"Retry-After": []string{test.retryAfter}},
+		}
+		if got := r.backoff(0, err); got != test.want {
+			t.Errorf("backoff with Retry-After %s = %v, want %v", test.retryAfter, got, test.want)
+		}
+	}
+}
@@ -1,7 +1,13 @@
 package syncdb
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/dgraph-io/badger/v2"
 	"github.com/nightlyone/lockfile"
@@ -12,6 +18,23 @@ import (
 
 var (
 	ErrNotFound = errors.New("record not found")
+
+	// reservedPrefix marks keys that do not hold sync records. Sync record
+	// keys start with an email address so they never begin with a zero byte.
+	reservedPrefix   = []byte{0}
+	failurePrefix    = append(append([]byte{}, reservedPrefix...), []byte("failure/")...)
+	checkpointPrefix = append(append([]byte{}, reservedPrefix...), []byte("checkpoint/")...)
+	feedPrefix       = append(append([]byte{}, reservedPrefix...), []byte("feed/")...)
+	cursorPrefix     = append(append([]byte{}, reservedPrefix...), []byte("cursor/")...)
+	calendarsPrefix  = append(append([]byte{}, reservedPrefix...), []byte("calendars/")...)
+	runPrefix        = append(append([]byte{}, reservedPrefix...), []byte("run/")...)
+)
+
+const (
+	RunSucceeded   = "succeeded"
+	RunPartial     = "partial"
+	RunFailed      = "failed"
+	RunInterrupted = "interrupted"
 )
 
 type DB struct {
@@ -19,10 +42,14 @@ type DB struct {
 	db        *badger.DB
 }
 
+// Record maps a source event to its copy. Start is when the source event
+// starts, zero for records saved before it was recorded.
 type Record struct {
-	Src     Event `json:"src"`
-	Dst     Event `json:"dst"`
-	Deleted bool  `json:"deleted"`
+	Src     Event     `json:"src"`
+	Dst     Event     `json:"dst"`
+	Deleted bool      `json:"deleted"`
+	Hash    string    `json:"hash,omitempty"`
+	Start   time.Time `json:"start,omitempty"`
 }
 
 type Event struct {
@@ -31,8 +58,103 @@ type Event struct {
 	CalendarID   string `json:"calendarId"`
 }
 
+// Failure is a source event that could not be synced to a destination
+// calendar. It is kept until a later run syncs the event successfully.
+type Failure struct {
+	Src             Event     `json:"src"`
+	DstAccountEmail string    `json:"dstAccountEmail"`
+	DstCalendarID   string    `json:"dstCalendarId"`
+	Attempts        int       `json:"attempts"`
+	LastError       string    `json:"lastError"`
+	LastAttempt     time.Time `json:"lastAttempt"`
+}
+
+// Checkpoint is the progress of an interrupted sync run. The list query
+// parameters are kept because page tokens are only valid for the query that
+// produced them, UpdateInterval is the request option UpdatedMin was derived
+// from.
+type Checkpoint struct {
+	UpdateInterval time.Duration `json:"updateInterval"`
+	UpdatedMin     string        `json:"updatedMin"`
+	TimeMin        string        `json:"timeMin"`
+	PageToken      string        `json:"pageToken"`
+	LastEventID    string        `json:"lastEventId"`
+	SavedAt        time.Time     `json:"savedAt"`
+}
+
+// Feed is the last fetched version of a remote calendar, kept to make
+// conditional requests and to sync from when it was not modified. Feeds are
+// stored by an ID derived from their URL, which can contain credentials.
+type Feed struct {
+	ETag         string    `json:"etag"`
+	LastModified string    `json:"lastModified"`
+	Body         []byte    `json:"body"`
+	FetchedAt    time.Time `json:"fetchedAt"`
+}
+
+// CalendarList is the last listed calendars of an account, kept to avoid
+// listing them on every run. Calendars is the JSON encoded list. Missing
+// are the calendars looked up in the list after it was fetched and not found,
+// so they don't make every run list the calendars again.
+type CalendarList struct {
+	AccountEmail string          `json:"accountEmail"`
+	Calendars    json.RawMessage `json:"calendars"`
+	FetchedAt    time.Time       `json:"fetchedAt"`
+	Missing      []string        `json:"missing,omitempty"`
+}
+
+// Cursor is where the next incremental run of a sync pair starts, for
+// sources that report their changes since a token. Resources maps the source
+// objects to the IDs of their events, so the events of removed objects can
+// be deleted. TimeMin and TimeMax bound the events covered by the token of
+// sources that only report changes within a time range.
+type Cursor struct {
+	Token     string              `json:"token"`
+	Resources map[string][]string `json:"resources,omitempty"`
+	TimeMin   time.Time           `json:"timeMin,omitempty"`
+	TimeMax   time.Time           `json:"timeMax,omitempty"`
+	SavedAt   time.Time           `json:"savedAt"`
+}
+
+// Run is the outcome of the last sync run of a pair. Partial runs finished
+// with events that failed to sync.
+type Run struct {
+	Src             Event     `json:"src"`
+	DstAccountEmail string    `json:"dstAccountEmail"`
+	DstCalendarID   string    `json:"dstCalendarId"`
+	StartedAt       time.Time `json:"startedAt"`
+	FinishedAt      time.Time `json:"finishedAt"`
+	Outcome         string    `json:"outcome"`
+	Error           string    `json:"error,omitempty"`
+}
+
+// Pair is a source and destination calendar pair.
+type Pair struct {
+	SrcAccountEmail string
+	SrcCalendarID   string
+	DstAccountEmail string
+	DstCalendarID   string
+}
+
+// PairStats counts the records of a sync pair. Records includes the soft
+// deleted ones. LastRun is nil for pairs synced before runs were recorded.
+type PairStats struct {
+	Pair
+	Records     int
+	SoftDeleted int
+	LastRun     *Run
+}
+
+// Stats counts the records in the database. Records includes the soft
+// deleted ones.
+type Stats struct {
+	Records     int
+	SoftDeleted int
+	Failures    int
+}
+
 func New() (*DB, error) {
-	db, err := badger.Open(badger.DefaultOptions("sync.db").WithLogger(nil))
+	db, err := badger.Open(badger.DefaultOptions("sync.db").WithLogger(badgerLogger{}))
 	if err != nil {
 		return nil, err
 	}
@@ -48,6 +170,30 @@ func New() (*DB, error) {
 	}, nil
 }
 
+// badgerLogger forwards the database logs to slog. Badger is chatty at the
+// info level, so its info messages are logged at debug level.
+type badgerLogger struct{}
+
+func (badgerLogger) Errorf(format string, args ...interface{}) {
+	slog.Error(badgerMessage(format, args), "component", "syncdb")
+}
+
+func (badgerLogger) Warningf(format string, args ...interface{}) {
+	slog.Warn(badgerMessage(format, args), "component", "syncdb")
+}
+
+func (badgerLogger) Infof(format string, args ...interface{}) {
+	slog.Debug(badgerMessage(format, args), "component", "syncdb")
+}
+
+func (badgerLogger) Debugf(format string, args ...interface{}) {
+	slog.Debug(badgerMessage(format, args), "component", "syncdb")
+}
+
+func badgerMessage(format string, args []interface{}) string {
+	return strings.TrimSpace(fmt.Sprintf(format, args...))
+}
+
 func (db *DB) Insert(r Record) error {
 	return db.db.Update(func(txn *badger.Txn) error {
 		key := buildKeyRecord(r)
@@ -106,6 +252,9 @@ func (db *DB) ListDst(accountEmail, calendarID string) ([]Record, error) {
 
 		for it.Rewind(); it.Valid(); it.Next() {
 			item := it.Item()
+			if bytes.HasPrefix(item.Key(), reservedPrefix) {
+				continue
+			}
 
 			data, err := item.ValueCopy(nil)
 			if err != nil {
@@ -129,6 +278,46 @@ func (db *DB) ListDst(accountEmail, calendarID string) ([]Record, error) {
 	return result, err
 }
 
+// ListPair returns the records of a sync pair, excluding soft deleted ones.
+// The source event ID is ignored.
+func (db *DB) ListPair(src Event, dstAccountEmail, dstCalendarID string) ([]Record, error) {
+	var result []Record
+
+	src.EventID = ""
+	prefix := buildKey(src, dstAccountEmail, dstCalendarID)
+
+	err := db.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			data, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return errors.Wrap(err, "failed to read record into buffer")
+			}
+
+			var record Record
+			if err := json.Unmarshal(data, &record); err != nil {
+				return errors.Wrap(err, "failed to serialize record")
+			}
+
+			// keys are not delimited so the prefix can match other pairs
+			if record.Src.AccountEmail != src.AccountEmail ||
+				record.Src.CalendarID != src.CalendarID ||
+				record.Dst.AccountEmail != dstAccountEmail ||
+				record.Dst.CalendarID != dstCalendarID ||
+				record.Deleted {
+				continue
+			}
+
+			result = append(result, record)
+		}
+		return nil
+	})
+
+	return result, err
+}
+
 func (db *DB) SoftDelete(r Record) error {
 	rg, err := db.Find(r.Src, r.Dst.AccountEmail, r.Dst.CalendarID, true)
 	if err != nil {
@@ -150,6 +339,437 @@ func (db *DB) Delete(r Record) error {
 	})
 }
 
+// Move replaces the source of a record in a single transaction.
+func (db *DB) Move(r Record, src Event) error {
+	return db.db.Update(func(txn *badger.Txn) error {
+		if err := txn.Delete(buildKeyRecord(r)); err != nil {
+			return errors.Wrapf(err, "failed to delete")
+		}
+
+		r.Src = src
+		value, err := json.Marshal(r)
+		if err != nil {
+			return errors.Wrap(err, "failed to serialize record")
+		}
+
+		if err := txn.SetEntry(badger.NewEntry(buildKeyRecord(r), value)); err != nil {
+			return errors.Wrapf(err, "failed to insert")
+		}
+		return nil
+	})
+}
+
+func (db *DB) Stats() (Stats, error) {
+	var stats Stats
+
+	err := db.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			if bytes.HasPrefix(item.Key(), failurePrefix) {
+				stats.Failures++
+				continue
+			}
+			if bytes.HasPrefix(item.Key(), reservedPrefix) {
+				continue
+			}
+
+			data, err := item.ValueCopy(nil)
+			if err != nil {
+				return errors.Wrap(err, "failed to read record into buffer")
+			}
+
+			var record Record
+			if err := json.Unmarshal(data, &record); err != nil {
+				return errors.Wrap(err, "failed to serialize record")
+			}
+
+			stats.Records++
+			if record.Deleted {
+				stats.SoftDeleted++
+			}
+		}
+		return nil
+	})
+
+	return stats, err
+}
+
+// PairStats returns the record counts and last run of every pair with
+// records or a recorded run, sorted by pair.
+func (db *DB) PairStats() ([]PairStats, error) {
+	stats := make(map[Pair]*PairStats)
+	get := func(pair Pair) *PairStats {
+		if _, ok := stats[pair]; !ok {
+			stats[pair] = &PairStats{Pair: pair}
+		}
+		return stats[pair]
+	}
+
+	err := db.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			isRun := bytes.HasPrefix(item.Key(), runPrefix)
+			if !isRun && bytes.HasPrefix(item.Key(), reservedPrefix) {
+				continue
+			}
+
+			data, err := item.ValueCopy(nil)
+			if err != nil {
+				return errors.Wrap(err, "failed to read record into buffer")
+			}
+
+			if isRun {
+				var run Run
+				if err := json.Unmarshal(data, &run); err != nil {
+					return errors.Wrap(err, "failed to deserialize run")
+				}
+				get(runPair(run)).LastRun = &run
+				continue
+			}
+
+			var record Record
+			if err := json.Unmarshal(data, &record); err != nil {
+				return errors.Wrap(err, "failed to serialize record")
+			}
+			pairStats := get(recordPair(record))
+			pairStats.Records++
+			if record.Deleted {
+				pairStats.SoftDeleted++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]PairStats, 0, len(stats))
+	for _, pairStats := range stats {
+		result = append(result, *pairStats)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Pair.less(result[j].Pair)
+	})
+	return result, nil
+}
+
+func (p Pair) less(other Pair) bool {
+	a := []string{p.SrcAccountEmail, p.SrcCalendarID, p.DstAccountEmail, p.DstCalendarID}
+	b := []string{other.SrcAccountEmail, other.SrcCalendarID, other.DstAccountEmail, other.DstCalendarID}
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
+
+func recordPair(r Record) Pair {
+	return Pair{
+		SrcAccountEmail: r.Src.AccountEmail,
+		SrcCalendarID:   r.Src.CalendarID,
+		DstAccountEmail: r.Dst.AccountEmail,
+		DstCalendarID:   r.Dst.CalendarID,
+	}
+}
+
+func runPair(r Run) Pair {
+	return Pair{
+		SrcAccountEmail: r.Src.AccountEmail,
+		SrcCalendarID:   r.Src.CalendarID,
+		DstAccountEmail: r.DstAccountEmail,
+		DstCalendarID:   r.DstCalendarID,
+	}
+}
+
+// SaveRun replaces the last run of the pair. The source event ID is ignored.
+func (db *DB) SaveRun(r Run) error {
+	return db.db.Update(func(txn *badger.Txn) error {
+		value, err := json.Marshal(r)
+		if err != nil {
+			return errors.Wrap(err, "failed to serialize run")
+		}
+
+		key := buildRunKey(r.Src, r.DstAccountEmail, r.DstCalendarID)
+		if err := txn.SetEntry(badger.NewEntry(key, value)); err != nil {
+			return errors.Wrap(err, "failed to save run")
+		}
+		return nil
+	})
+}
+
+// DeleteRun removes the last run of a sync pair. The source event ID is
+// ignored.
+func (db *DB) DeleteRun(src Event, dstAccountEmail, dstCalendarID string) error {
+	return db.db.Update(func(txn *badger.Txn) error {
+		if err := txn.Delete(buildRunKey(src, dstAccountEmail, dstCalendarID)); err != nil {
+			return errors.Wrap(err, "failed to delete run")
+		}
+		return nil
+	})
+}
+
+// RecordFailure stores a failed sync attempt, incrementing the attempt
+// count if the event already failed before.
+func (db *DB) RecordFailure(src Event, dstAccountEmail, dstCalendarID string, syncErr error) error {
+	return db.db.Update(func(txn *badger.Txn) error {
+		key := buildFailureKey(src, dstAccountEmail, dstCalendarID)
+
+		f := Failure{
+			Src:             src,
+			DstAccountEmail: dstAccountEmail,
+			DstCalendarID:   dstCalendarID,
+		}
+
+		item, err := txn.Get(key)
+		if err != nil && err != badger.ErrKeyNotFound {
+			return errors.Wrap(err, "failed to read failure")
+		}
+		if err == nil {
+			data, err := item.ValueCopy(nil)
+			if err != nil {
+				return errors.Wrap(err, "failed to read failure into buffer")
+			}
+			if err := json.Unmarshal(data, &f); err != nil {
+				return errors.Wrap(err, "failed to deserialize failure")
+			}
+		}
+
+		f.Attempts++
+		f.LastError = syncErr.Error()
+		f.LastAttempt = time.Now()
+
+		value, err := json.Marshal(f)
+		if err != nil {
+			return errors.Wrap(err, "failed to serialize failure")
+		}
+		if err := txn.SetEntry(badger.NewEntry(key, value)); err != nil {
+			return errors.Wrap(err, "failed to insert failure")
+		}
+		return nil
+	})
+}
+
+// ClearFailure removes the failure of a source event, if there is one.
+func (db *DB) ClearFailure(src Event, dstAccountEmail, dstCalendarID string) error {
+	return db.db.Update(func(txn *badger.Txn) error {
+		if err := txn.Delete(buildFailureKey(src, dstAccountEmail, dstCalendarID)); err != nil {
+			return errors.Wrap(err, "failed to delete failure")
+		}
+		return nil
+	})
+}
+
+// ListFailures returns the failures of all the sync pairs.
+func (db *DB) ListFailures() ([]Failure, error) {
+	var result []Failure
+
+	err := db.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(failurePrefix); it.ValidForPrefix(failurePrefix); it.Next() {
+			data, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return errors.Wrap(err, "failed to read failure into buffer")
+			}
+
+			var f Failure
+			if err := json.Unmarshal(data, &f); err != nil {
+				return errors.Wrap(err, "failed to deserialize failure")
+			}
+
+			result = append(result, f)
+		}
+		return nil
+	})
+
+	return result, err
+}
+
+// FindCheckpoint returns the checkpoint of a sync pair. The source event ID
+// is ignored.
+func (db *DB) FindCheckpoint(src Event, dstAccountEmail, dstCalendarID string) (Checkpoint, error) {
+	var c Checkpoint
+
+	err := db.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(buildCheckpointKey(src, dstAccountEmail, dstCalendarID))
+		if err == badger.ErrKeyNotFound {
+			return ErrNotFound
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read checkpoint")
+		}
+
+		data, err := item.ValueCopy(nil)
+		if err != nil {
+			return errors.Wrap(err, "failed to read checkpoint into buffer")
+		}
+
+		if err := json.Unmarshal(data, &c); err != nil {
+			return errors.Wrap(err, "failed to deserialize checkpoint")
+		}
+		return nil
+	})
+
+	return c, err
+}
+
+func (db *DB) SaveCheckpoint(src Event, dstAccountEmail, dstCalendarID string, c Checkpoint) error {
+	c.SavedAt = time.Now()
+	return db.db.Update(func(txn *badger.Txn) error {
+		value, err := json.Marshal(c)
+		if err != nil {
+			return errors.Wrap(err, "failed to serialize checkpoint")
+		}
+
+		key := buildCheckpointKey(src, dstAccountEmail, dstCalendarID)
+		if err := txn.SetEntry(badger.NewEntry(key, value)); err != nil {
+			return errors.Wrap(err, "failed to save checkpoint")
+		}
+		return nil
+	})
+}
+
+func (db *DB) DeleteCheckpoint(src Event, dstAccountEmail, dstCalendarID string) error {
+	return db.db.Update(func(txn *badger.Txn) error {
+		if err := txn.Delete(buildCheckpointKey(src, dstAccountEmail, dstCalendarID)); err != nil {
+			return errors.Wrap(err, "failed to delete checkpoint")
+		}
+		return nil
+	})
+}
+
+// FindCursor returns the cursor of a sync pair. The source event ID is
+// ignored.
+func (db *DB) FindCursor(src Event, dstAccountEmail, dstCalendarID string) (Cursor, error) {
+	var c Cursor
+
+	err := db.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(buildCursorKey(src, dstAccountEmail, dstCalendarID))
+		if err == badger.ErrKeyNotFound {
+			return ErrNotFound
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read cursor")
+		}
+
+		data, err := item.ValueCopy(nil)
+		if err != nil {
+			return errors.Wrap(err, "failed to read cursor into buffer")
+		}
+
+		if err := json.Unmarshal(data, &c); err != nil {
+			return errors.Wrap(err, "failed to deserialize cursor")
+		}
+		return nil
+	})
+
+	return c, err
+}
+
+func (db *DB) SaveCursor(src Event, dstAccountEmail, dstCalendarID string, c Cursor) error {
+	c.SavedAt = time.Now()
+	return db.db.Update(func(txn *badger.Txn) error {
+		value, err := json.Marshal(c)
+		if err != nil {
+			return errors.Wrap(err, "failed to serialize cursor")
+		}
+
+		key := buildCursorKey(src, dstAccountEmail, dstCalendarID)
+		if err := txn.SetEntry(badger.NewEntry(key, value)); err != nil {
+			return errors.Wrap(err, "failed to save cursor")
+		}
+		return nil
+	})
+}
+
+func (db *DB) FindFeed(id string) (Feed, error) {
+	var f Feed
+
+	err := db.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(buildFeedKey(id))
+		if err == badger.ErrKeyNotFound {
+			return ErrNotFound
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read feed")
+		}
+
+		data, err := item.ValueCopy(nil)
+		if err != nil {
+			return errors.Wrap(err, "failed to read feed into buffer")
+		}
+
+		if err := json.Unmarshal(data, &f); err != nil {
+			return errors.Wrap(err, "failed to deserialize feed")
+		}
+		return nil
+	})
+
+	return f, err
+}
+
+func (db *DB) SaveFeed(id string, f Feed) error {
+	return db.db.Update(func(txn *badger.Txn) error {
+		value, err := json.Marshal(f)
+		if err != nil {
+			return errors.Wrap(err, "failed to serialize feed")
+		}
+
+		if err := txn.SetEntry(badger.NewEntry(buildFeedKey(id), value)); err != nil {
+			return errors.Wrap(err, "failed to save feed")
+		}
+		return nil
+	})
+}
+
+func (db *DB) FindCalendarList(accountEmail string) (CalendarList, error) {
+	var l CalendarList
+
+	err := db.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(buildCalendarListKey(accountEmail))
+		if err == badger.ErrKeyNotFound {
+			return ErrNotFound
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read calendar list")
+		}
+
+		data, err := item.ValueCopy(nil)
+		if err != nil {
+			return errors.Wrap(err, "failed to read calendar list into buffer")
+		}
+
+		if err := json.Unmarshal(data, &l); err != nil {
+			return errors.Wrap(err, "failed to deserialize calendar list")
+		}
+		return nil
+	})
+
+	return l, err
+}
+
+func (db *DB) SaveCalendarList(l CalendarList) error {
+	return db.db.Update(func(txn *badger.Txn) error {
+		value, err := json.Marshal(l)
+		if err != nil {
+			return errors.Wrap(err, "failed to serialize calendar list")
+		}
+
+		if err := txn.SetEntry(badger.NewEntry(buildCalendarListKey(l.AccountEmail), value)); err != nil {
+			return errors.Wrap(err, "failed to save calendar list")
+		}
+		return nil
+	})
+}
+
 func (db *DB) Close() error {
 	return db.db.Close()
 }
@@ -165,3 +785,42 @@ func buildKey(event Event, dstAccountEmail, dstCalendarId string) []byte {
 func buildKeyRecord(r Record) []byte {
 	return buildKey(r.Src, r.Dst.AccountEmail, r.Dst.CalendarID)
 }
+
+func buildCheckpointKey(src Event, dstAccountEmail, dstCalendarId string) []byte {
+	src.EventID = ""
+	return append(
+		append([]byte{}, checkpointPrefix...),
+		buildKey(src, dstAccountEmail, dstCalendarId)...,
+	)
+}
+
+func buildCursorKey(src Event, dstAccountEmail, dstCalendarId string) []byte {
+	src.EventID = ""
+	return append(
+		append([]byte{}, cursorPrefix...),
+		buildKey(src, dstAccountEmail, dstCalendarId)...,
+	)
+}
+
+func buildRunKey(src Event, dstAccountEmail, dstCalendarId string) []byte {
+	src.EventID = ""
+	return append(
+		append([]byte{}, runPrefix...),
+		buildKey(src, dstAccountEmail, dstCalendarId)...,
+	)
+}
+
+func buildFeedKey(id string) []byte {
+	return append(append([]byte{}, feedPrefix...), []byte(id)...)
+}
+
+func buildCalendarListKey(accountEmail string) []byte {
+	return append(append([]byte{}, calendarsPrefix...), []byte(accountEmail)...)
+}
+
+func buildFailureKey(src Event, dstAccountEmail, dstCalendarId string) []byte {
+	return append(
+		append([]byte{}, failurePrefix...),
+		buildKey(src, dstAccountEmail, dstCalendarId)...,
+	)
+}
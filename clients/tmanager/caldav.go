@@ -0,0 +1,125 @@
+package tmanager
+
+import (
+	"encoding/json"
+	"log/slog"
+
+	"github.com/nightlyone/lockfile"
+	"github.com/pkg/errors"
+
+	"github.com/robertdolca/calendar-sync/clients/lockhelper"
+)
+
+const caldavPath = "caldav.json"
+
+// CalDAVAccount holds the credentials of a CalDAV server account. The name
+// is used as the account email in sync requests.
+type CalDAVAccount struct {
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func (m *Manager) CalDAVAccounts() []CalDAVAccount {
+	return m.caldavAccounts
+}
+
+// CalDAVAccount returns the CalDAV account with the given name.
+func (m *Manager) CalDAVAccount(name string) (CalDAVAccount, bool) {
+	for _, account := range m.caldavAccounts {
+		if account.Name == name {
+			return account, true
+		}
+	}
+	return CalDAVAccount{}, false
+}
+
+// AddCalDAV saves a CalDAV account, replacing the account with the same name.
+func (m *Manager) AddCalDAV(account CalDAVAccount) error {
+	accounts := make([]CalDAVAccount, 0, len(m.caldavAccounts)+1)
+	for _, existing := range m.caldavAccounts {
+		if existing.Name != account.Name {
+			accounts = append(accounts, existing)
+		}
+	}
+	accounts = append(accounts, account)
+
+	if err := saveCalDAVAccounts(m.store, m.tokensMutex, accounts); err != nil {
+		return err
+	}
+	m.caldavAccounts = accounts
+	return nil
+}
+
+// RemoveCalDAV deletes a CalDAV account.
+func (m *Manager) RemoveCalDAV(name string) error {
+	accounts := make([]CalDAVAccount, 0, len(m.caldavAccounts))
+	for _, existing := range m.caldavAccounts {
+		if existing.Name != name {
+			accounts = append(accounts, existing)
+		}
+	}
+	if len(accounts) == len(m.caldavAccounts) {
+		return errors.Errorf("caldav account %s not found", name)
+	}
+
+	if err := saveCalDAVAccounts(m.store, m.tokensMutex, accounts); err != nil {
+		return err
+	}
+	m.caldavAccounts = accounts
+	return nil
+}
+
+func readCalDAVAccounts(store Store, mutex lockfile.Lockfile) ([]CalDAVAccount, error) {
+	err := mutex.TryLock()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to acquire tokens file lock")
+	}
+
+	accounts, err := readCalDAVAccountsUnsafe(store)
+	return accounts, lockhelper.MutexUnlock(mutex, err)
+}
+
+func readCalDAVAccountsUnsafe(store Store) ([]CalDAVAccount, error) {
+	accounts := make([]CalDAVAccount, 0)
+
+	data, err := store.Read(caldavPath)
+	if isNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(data, &accounts); err != nil {
+		return nil, errors.Wrap(err, "unable to read caldav accounts")
+	}
+
+	slog.Debug("read caldav accounts", "component", "tmanager", "count", len(accounts))
+
+	return accounts, nil
+}
+
+func saveCalDAVAccounts(store Store, mutex lockfile.Lockfile, accounts []CalDAVAccount) error {
+	err := mutex.TryLock()
+	if err != nil {
+		return errors.Wrapf(err, "failed to acquire tokens file lock")
+	}
+	return lockhelper.MutexUnlock(mutex, saveCalDAVAccountsUnsafe(store, accounts))
+}
+
+func saveCalDAVAccountsUnsafe(store Store, accounts []CalDAVAccount) error {
+	data, err := json.Marshal(accounts)
+	if err != nil {
+		return errors.Wrapf(err, "unable to encode caldav accounts")
+	}
+
+	if err := store.Write(caldavPath, data); err != nil {
+		return errors.Wrapf(err, "unable to save caldav accounts")
+	}
+
+	slog.Debug("saved caldav accounts", "component", "tmanager", "count", len(accounts))
+
+	return nil
+}
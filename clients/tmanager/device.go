@@ -0,0 +1,48 @@
+package tmanager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+// deviceAuth runs the OAuth device authorization grant: the user enters the
+// printed code on another device while the token endpoint is polled, slowing
+// down when the server asks to.
+func deviceAuth(ctx context.Context, config *oauth2.Config) (*oauth2.Token, error) {
+	response, err := config.DeviceAuth(ctx, oauth2.AccessTypeOffline)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to start device authorization")
+	}
+
+	if response.VerificationURIComplete != "" {
+		fmt.Printf("Go to %v on any device and confirm the code %v\n", response.VerificationURIComplete, response.UserCode)
+	} else {
+		fmt.Printf("Go to %v on any device and enter the code %v\n", response.VerificationURI, response.UserCode)
+	}
+
+	token, err := config.DeviceAccessToken(ctx, response)
+	if err != nil {
+		return nil, errors.Wrap(err, "device authorization failed")
+	}
+	return token, nil
+}
+
+// isInvalidScope reports whether the authorization server refused a scope,
+// which Google does for the calendar scopes in the device flow.
+func isInvalidScope(err error) bool {
+	var retrieveErr *oauth2.RetrieveError
+	if !errors.As(err, &retrieveErr) {
+		return false
+	}
+	if retrieveErr.ErrorCode != "" {
+		return retrieveErr.ErrorCode == "invalid_scope"
+	}
+	var body struct {
+		Error string `json:"error"`
+	}
+	return json.Unmarshal(retrieveErr.Body, &body) == nil && body.Error == "invalid_scope"
+}
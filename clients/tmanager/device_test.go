@@ -0,0 +1,25 @@
+package tmanager
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+func TestIsInvalidScope(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want bool
+	}{
+		{&oauth2.RetrieveError{Body: []byte(`{"error":"invalid_scope","error_description":"Invalid device flow scope"}`)}, true},
+		{errors.Wrap(&oauth2.RetrieveError{ErrorCode: "invalid_scope"}, "device"), true},
+		{&oauth2.RetrieveError{Body: []byte(`{"error":"invalid_client"}`)}, false},
+		{&oauth2.RetrieveError{Body: []byte(`not json`)}, false},
+		{errors.New("connection reset"), false},
+	} {
+		if got := isInvalidScope(test.err); got != test.want {
+			t.Errorf("isInvalidScope(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
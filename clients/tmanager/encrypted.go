@@ -0,0 +1,252 @@
+package tmanager
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+const (
+	// PassphraseEnv is read instead of the passphrase file or prompt.
+	PassphraseEnv = "CALENDAR_SYNC_PASSPHRASE"
+
+	encryptedSuffix  = ".enc"
+	encryptedVersion = 1
+	keySize          = 32
+	saltSize         = 16
+	// scrypt parameters recommended for interactive logins
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+	// maxScryptN bounds the memory used to read files written with other
+	// parameters
+	maxScryptN = 1 << 20
+)
+
+// encryptedFile is the content of an encrypted file. The key is derived from
+// the passphrase with scrypt and the data is sealed with AES-GCM, with the
+// file name as additional data so files can't be swapped.
+type encryptedFile struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// encryptedStore keeps the files encrypted in the work directory, next to
+// where the plaintext files would be.
+type encryptedStore struct {
+	files      fileStore
+	passphrase []byte
+
+	mutex sync.Mutex
+	// keys caches the keys derived for each salt, key derivation is slow on
+	// purpose
+	keys map[string][]byte
+	salt []byte
+}
+
+func newEncryptedStore(options Options, confirm bool) (*encryptedStore, error) {
+	passphrase, err := readPassphrase(options.PassphraseFile, confirm)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedStore{
+		passphrase: passphrase,
+		keys:       make(map[string][]byte),
+	}, nil
+}
+
+func (s *encryptedStore) Read(name string) ([]byte, error) {
+	data, err := s.files.Read(name + encryptedSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	var file encryptedFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, errors.Wrapf(err, "unable to parse encrypted file %s", name)
+	}
+	if file.Version != encryptedVersion || file.KDF != "scrypt" {
+		return nil, errors.Errorf("unsupported encrypted file %s version %d", name, file.Version)
+	}
+	if file.N > maxScryptN {
+		return nil, errors.Errorf("encrypted file %s has an unreasonable scrypt cost", name)
+	}
+
+	key, err := s.key(file.Salt, file.N, file.R, file.P)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, file.Nonce, file.Ciphertext, []byte(name))
+	if err != nil {
+		return nil, errors.Errorf("unable to decrypt %s, the passphrase may be wrong", name)
+	}
+	return plaintext, nil
+}
+
+func (s *encryptedStore) Write(name string, data []byte) error {
+	salt, err := s.writeSalt()
+	if err != nil {
+		return err
+	}
+	key, err := s.key(salt, scryptN, scryptR, scryptP)
+	if err != nil {
+		return err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return errors.Wrap(err, "failed to generate nonce")
+	}
+
+	file := encryptedFile{
+		Version:    encryptedVersion,
+		KDF:        "scrypt",
+		N:          scryptN,
+		R:          scryptR,
+		P:          scryptP,
+		Salt:       salt,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, data, []byte(name)),
+	}
+	encoded, err := json.Marshal(file)
+	if err != nil {
+		return errors.Wrapf(err, "unable to encode encrypted file %s", name)
+	}
+	return s.files.Write(name+encryptedSuffix, encoded)
+}
+
+func (s *encryptedStore) Remove(name string) error {
+	return s.files.Remove(name + encryptedSuffix)
+}
+
+// writeSalt returns the salt of the files written by this process. Every
+// file gets its own nonce, so sharing the salt only saves key derivations.
+func (s *encryptedStore) writeSalt() ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.salt == nil {
+		salt := make([]byte, saltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, errors.Wrap(err, "failed to generate salt")
+		}
+		s.salt = salt
+	}
+	return s.salt, nil
+}
+
+func (s *encryptedStore) key(salt []byte, n, r, p int) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	id := fmt.Sprintf("%x/%d/%d/%d", salt, n, r, p)
+	if key, ok := s.keys[id]; ok {
+		return key, nil
+	}
+	key, err := scrypt.Key(s.passphrase, salt, n, r, p, keySize)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to derive key")
+	}
+	s.keys[id] = key
+	return key, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create cipher")
+	}
+	return cipher.NewGCM(block)
+}
+
+func encryptedFilesExist() bool {
+	for _, name := range secretPaths {
+		if _, err := os.Stat(name + encryptedSuffix); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// readPassphrase reads the passphrase from the environment, the passphrase
+// file or, as a last resort, prompts for it. A new passphrase is prompted
+// twice to catch typos.
+func readPassphrase(path string, confirm bool) ([]byte, error) {
+	if passphrase := os.Getenv(PassphraseEnv); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+
+	var passphrase string
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read passphrase file")
+		}
+		passphrase = strings.TrimRight(string(data), "\r\n")
+	} else {
+		reader := bufio.NewReader(os.Stdin)
+		var err error
+		passphrase, err = promptPassphrase(reader, "Token store passphrase: ")
+		if err != nil {
+			return nil, err
+		}
+		if confirm {
+			repeated, err := promptPassphrase(reader, "Repeat the passphrase: ")
+			if err != nil {
+				return nil, err
+			}
+			if repeated != passphrase {
+				return nil, errors.New("the passphrases don't match")
+			}
+		}
+	}
+
+	if passphrase == "" {
+		return nil, errors.New("empty passphrase")
+	}
+	return []byte(passphrase), nil
+}
+
+// promptPassphrase reads a line from stdin, without echoing it when stdin is
+// a terminal.
+func promptPassphrase(reader *bufio.Reader, prompt string) (string, error) {
+	fmt.Print(prompt)
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		passphrase, err := term.ReadPassword(fd)
+		fmt.Println()
+		if err != nil {
+			return "", errors.Wrap(err, "unable to read passphrase")
+		}
+		return string(passphrase), nil
+	}
+
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", errors.Wrap(err, "unable to read passphrase")
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
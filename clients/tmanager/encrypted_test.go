@@ -0,0 +1,111 @@
+package tmanager
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func newTestEncryptedStore(passphrase string) *encryptedStore {
+	return &encryptedStore{
+		passphrase: []byte(passphrase),
+		keys:       make(map[string][]byte),
+	}
+}
+
+func TestEncryptedStoreRoundTrip(t *testing.T) {
+	useTempDir(t)
+	data := []byte(`{"user@example.com":{"access_token":"secret"}}`)
+
+	if err := newTestEncryptedStore("right").Write(tokensPath, data); err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := os.ReadFile(tokensPath + encryptedSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(encrypted, []byte("secret")) {
+		t.Error("the encrypted file contains the plaintext")
+	}
+
+	read, err := newTestEncryptedStore("right").Read(tokensPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(read, data) {
+		t.Errorf("read %s, want %s", read, data)
+	}
+
+	if _, err := newTestEncryptedStore("wrong").Read(tokensPath); err == nil {
+		t.Error("decrypted with a wrong passphrase")
+	}
+}
+
+func TestEncryptedStoreSwappedFiles(t *testing.T) {
+	useTempDir(t)
+	store := newTestEncryptedStore("right")
+	if err := store.Write(tokensPath, []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Write(caldavPath, []byte(`[]`)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Rename(caldavPath+encryptedSuffix, tokensPath+encryptedSuffix); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Read(tokensPath); err == nil {
+		t.Error("read a file encrypted under another name")
+	}
+}
+
+// corruptingStore returns other data than what was written.
+type corruptingStore struct {
+	fileStore
+}
+
+func (corruptingStore) Read(string) ([]byte, error) {
+	return []byte("corrupted"), nil
+}
+
+func TestMoveSecretsKeepsPlaintextWhenVerificationFails(t *testing.T) {
+	useTempDir(t)
+	data := []byte(`{"user@example.com":{"access_token":"secret"}}`)
+	if err := os.WriteFile(tokensPath, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := moveSecretsUnsafe(fileStore{}, corruptingStore{}); err == nil {
+		t.Fatal("moved secrets that could not be verified")
+	}
+	kept, err := os.ReadFile(tokensPath)
+	if err != nil {
+		t.Fatalf("plaintext file removed: %v", err)
+	}
+	if !bytes.Equal(kept, data) {
+		t.Errorf("plaintext file changed to %s", kept)
+	}
+}
+
+func TestMoveSecretsToEncryptedStore(t *testing.T) {
+	useTempDir(t)
+	data := []byte(`{"user@example.com":{"access_token":"secret"}}`)
+	if err := os.WriteFile(tokensPath, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	store := newTestEncryptedStore("right")
+	if err := moveSecretsUnsafe(fileStore{}, store); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(tokensPath); !os.IsNotExist(err) {
+		t.Error("plaintext file kept after moving it")
+	}
+	read, err := store.Read(tokensPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(read, data) {
+		t.Errorf("read %s, want %s", read, data)
+	}
+}
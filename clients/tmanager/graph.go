@@ -0,0 +1,216 @@
+package tmanager
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"strings"
+
+	"github.com/nightlyone/lockfile"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+
+	"github.com/robertdolca/calendar-sync/clients/graph"
+	"github.com/robertdolca/calendar-sync/clients/lockhelper"
+)
+
+const (
+	graphAccountsPath    = "graph.json"
+	graphCredentialsPath = "graph_credentials.json"
+	defaultAuthority     = "https://login.microsoftonline.com"
+)
+
+var graphScope = []string{
+	"offline_access",
+	"User.Read",
+	"Calendars.ReadWrite",
+}
+
+// GraphAccount is a Microsoft 365 or Outlook.com account authorized to use
+// the Microsoft Graph API.
+type GraphAccount struct {
+	Email string       `json:"email"`
+	Token oauth2.Token `json:"token"`
+}
+
+// GraphCredentials identifies the app registered in Microsoft Entra ID.
+// Authority and Endpoint are only needed for national clouds.
+type GraphCredentials struct {
+	ClientID  string `json:"client_id"`
+	Tenant    string `json:"tenant"`
+	Authority string `json:"authority"`
+	Endpoint  string `json:"endpoint"`
+}
+
+func (m *Manager) GraphAccounts() []GraphAccount {
+	return m.graphAccounts
+}
+
+// GraphAccount returns the Microsoft account with the given email.
+func (m *Manager) GraphAccount(email string) (GraphAccount, bool) {
+	for _, account := range m.graphAccounts {
+		if strings.EqualFold(account.Email, email) {
+			return account, true
+		}
+	}
+	return GraphAccount{}, false
+}
+
+// AddGraph saves a Microsoft account, replacing the account with the same
+// email.
+func (m *Manager) AddGraph(account GraphAccount) error {
+	accounts := make([]GraphAccount, 0, len(m.graphAccounts)+1)
+	for _, existing := range m.graphAccounts {
+		if !strings.EqualFold(existing.Email, account.Email) {
+			accounts = append(accounts, existing)
+		}
+	}
+	accounts = append(accounts, account)
+
+	if err := saveGraphAccounts(m.store, m.tokensMutex, accounts); err != nil {
+		return err
+	}
+	m.graphAccounts = accounts
+	return nil
+}
+
+// GraphConfig returns the OAuth config of the Microsoft app and the Graph
+// API endpoint.
+func (m *Manager) GraphConfig() (*oauth2.Config, string, error) {
+	data, err := ioutil.ReadFile(graphCredentialsPath)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "unable to read microsoft app credentials file")
+	}
+
+	var credentials GraphCredentials
+	if err := json.Unmarshal(data, &credentials); err != nil {
+		return nil, "", errors.Wrap(err, "unable to parse microsoft app credentials file")
+	}
+	if credentials.ClientID == "" {
+		return nil, "", errors.New("microsoft app credentials file has no client_id")
+	}
+	if credentials.Tenant == "" {
+		credentials.Tenant = "common"
+	}
+	if credentials.Authority == "" {
+		credentials.Authority = defaultAuthority
+	}
+
+	base := strings.TrimSuffix(credentials.Authority, "/") + "/" + credentials.Tenant + "/oauth2/v2.0"
+	config := &oauth2.Config{
+		ClientID: credentials.ClientID,
+		Scopes:   graphScope,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:       base + "/authorize",
+			TokenURL:      base + "/token",
+			DeviceAuthURL: base + "/devicecode",
+			// public clients have no secret
+			AuthStyle: oauth2.AuthStyleInParams,
+		},
+	}
+	return config, credentials.Endpoint, nil
+}
+
+// RemoveGraph deletes a Microsoft account.
+func (m *Manager) RemoveGraph(email string) error {
+	accounts := make([]GraphAccount, 0, len(m.graphAccounts))
+	for _, existing := range m.graphAccounts {
+		if !strings.EqualFold(existing.Email, email) {
+			accounts = append(accounts, existing)
+		}
+	}
+	if len(accounts) == len(m.graphAccounts) {
+		return errors.Errorf("microsoft account %s not found", email)
+	}
+
+	if err := saveGraphAccounts(m.store, m.tokensMutex, accounts); err != nil {
+		return err
+	}
+	m.graphAccounts = accounts
+	return nil
+}
+
+// AuthGraph signs in a Microsoft account with the device code flow, the user
+// enters the printed code on another device, and saves the account under the
+// email of the signed in user. If an email is expected, another account is
+// refused.
+func (m *Manager) AuthGraph(ctx context.Context, expected string) (string, error) {
+	config, endpoint, err := m.GraphConfig()
+	if err != nil {
+		return "", err
+	}
+
+	token, err := deviceAuth(ctx, config)
+	if err != nil {
+		return "", err
+	}
+
+	client, err := graph.New(oauth2.NewClient(ctx, config.TokenSource(ctx, token)), endpoint)
+	if err != nil {
+		return "", err
+	}
+	user, err := client.Me(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	email := user.Email()
+	if expected != "" && !strings.EqualFold(email, expected) {
+		return "", errors.Errorf("signed in as %s instead of %s, the token was not saved", email, expected)
+	}
+	return email, m.AddGraph(GraphAccount{Email: email, Token: *token})
+}
+
+func readGraphAccounts(store Store, mutex lockfile.Lockfile) ([]GraphAccount, error) {
+	err := mutex.TryLock()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to acquire tokens file lock")
+	}
+
+	accounts, err := readGraphAccountsUnsafe(store)
+	return accounts, lockhelper.MutexUnlock(mutex, err)
+}
+
+func readGraphAccountsUnsafe(store Store) ([]GraphAccount, error) {
+	accounts := make([]GraphAccount, 0)
+
+	data, err := store.Read(graphAccountsPath)
+	if isNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(data, &accounts); err != nil {
+		return nil, errors.Wrap(err, "unable to read microsoft accounts")
+	}
+
+	slog.Debug("read microsoft accounts", "component", "tmanager", "count", len(accounts))
+
+	return accounts, nil
+}
+
+func saveGraphAccounts(store Store, mutex lockfile.Lockfile, accounts []GraphAccount) error {
+	err := mutex.TryLock()
+	if err != nil {
+		return errors.Wrapf(err, "failed to acquire tokens file lock")
+	}
+	return lockhelper.MutexUnlock(mutex, saveGraphAccountsUnsafe(store, accounts))
+}
+
+func saveGraphAccountsUnsafe(store Store, accounts []GraphAccount) error {
+	data, err := json.Marshal(accounts)
+	if err != nil {
+		return errors.Wrapf(err, "unable to encode microsoft accounts")
+	}
+
+	if err := store.Write(graphAccountsPath, data); err != nil {
+		return errors.Wrapf(err, "unable to save microsoft accounts")
+	}
+
+	slog.Debug("saved microsoft accounts", "component", "tmanager", "count", len(accounts))
+
+	return nil
+}
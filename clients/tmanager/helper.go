@@ -0,0 +1,99 @@
+package tmanager
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// helperStore delegates keeping the files to an external command, in the
+// style of git credential helpers. The command is run by the shell, like git
+// does, with the action, get, store or erase, as its last argument and reads
+// key=value lines ending with an empty line from stdin:
+//
+//	name=tokens.json
+//	data=<base64 content, store only>
+//
+// For get it prints the data line, or nothing when it has no such file.
+type helperStore struct {
+	command string
+}
+
+func newHelperStore(command string) (*helperStore, error) {
+	command = strings.TrimSpace(command)
+	if command == "" {
+		return nil, errors.New("credential helper command not specified")
+	}
+	return &helperStore{command: command}, nil
+}
+
+func (s *helperStore) Read(name string) ([]byte, error) {
+	output, err := s.run("get", map[string]string{"name": name})
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, ok := output["data"]
+	if !ok {
+		return nil, errors.Wrapf(os.ErrNotExist, "credential helper has no %s", name)
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.Wrapf(err, "credential helper returned invalid data for %s", name)
+	}
+	return data, nil
+}
+
+func (s *helperStore) Write(name string, data []byte) error {
+	_, err := s.run("store", map[string]string{
+		"name": name,
+		"data": base64.StdEncoding.EncodeToString(data),
+	})
+	return err
+}
+
+func (s *helperStore) Remove(name string) error {
+	_, err := s.run("erase", map[string]string{"name": name})
+	return err
+}
+
+func (s *helperStore) run(action string, input map[string]string) (map[string]string, error) {
+	var stdin, stdout, stderr bytes.Buffer
+	for _, key := range []string{"name", "data"} {
+		if value, ok := input[key]; ok {
+			fmt.Fprintf(&stdin, "%s=%s\n", key, value)
+		}
+	}
+	stdin.WriteString("\n")
+
+	cmd := exec.Command("sh", "-c", s.command+` "$@"`, s.command, action)
+	cmd.Stdin = &stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			err = errors.Wrap(err, message)
+		}
+		return nil, errors.Wrapf(err, "credential helper %s %s failed", action, input["name"])
+	}
+
+	output := make(map[string]string)
+	scanner := bufio.NewScanner(&stdout)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+		if key, value, ok := strings.Cut(line, "="); ok {
+			output[key] = value
+		}
+	}
+	return output, errors.Wrap(scanner.Err(), "unable to read credential helper output")
+}
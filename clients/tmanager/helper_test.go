@@ -0,0 +1,88 @@
+package tmanager
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testHelper keeps the files in the directory given as its first argument.
+const testHelper = `#!/bin/sh
+dir=$1
+while IFS='=' read -r key value; do
+  [ -z "$key" ] && break
+  case $key in name) name=$value ;; data) data=$value ;; esac
+done
+case $2 in
+  get) [ -f "$dir/$name" ] && printf 'data=%s\n' "$(cat "$dir/$name")" ;;
+  store) printf '%s' "$data" > "$dir/$name" ;;
+  erase) rm -f "$dir/$name" ;;
+esac
+exit 0
+`
+
+func newTestHelperStore(t *testing.T) (*helperStore, string) {
+	// spaces in the paths check that the command is run by the shell
+	root := filepath.Join(t.TempDir(), "with space")
+	dir := filepath.Join(root, "secrets")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	script := filepath.Join(root, "helper.sh")
+	if err := os.WriteFile(script, []byte(testHelper), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := newHelperStore(`"` + script + `" '` + dir + `'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, dir
+}
+
+func TestHelperStore(t *testing.T) {
+	store, dir := newTestHelperStore(t)
+	data := []byte("{\"user@example.com\":{}}\n")
+
+	if err := store.Write(tokensPath, data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, tokensPath)); err != nil {
+		t.Fatalf("helper didn't store the file: %v", err)
+	}
+
+	read, err := store.Read(tokensPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(read, data) {
+		t.Errorf("read %q, want %q", read, data)
+	}
+
+	if err := store.Remove(tokensPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Read(tokensPath); !isNotExist(err) {
+		t.Errorf("read after erase = %v, want not exist", err)
+	}
+}
+
+func TestHelperStoreMissing(t *testing.T) {
+	store, _ := newTestHelperStore(t)
+
+	_, err := store.Read(caldavPath)
+	if !isNotExist(err) {
+		t.Errorf("read missing = %v, want not exist", err)
+	}
+}
+
+func TestHelperStoreFailure(t *testing.T) {
+	store, err := newHelperStore("echo 'locked' >&2; false")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Read(tokensPath); err == nil {
+		t.Error("failing helper ignored")
+	}
+}
@@ -0,0 +1,120 @@
+package tmanager
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+// loopbackTimeout is how long the user has to authorize access in the browser.
+const loopbackTimeout = 5 * time.Minute
+
+const loopbackDone = `<html><body>Authorization complete, you can close this window.</body></html>`
+
+// loopbackAuth runs the OAuth flow for installed apps: the browser is
+// redirected to a temporary local server with the authorization code. The
+// state protects against forged redirects and PKCE against intercepted codes.
+func loopbackAuth(ctx context.Context, config oauth2.Config) (*oauth2.Token, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to listen for the authorization redirect")
+	}
+	config.RedirectURL = fmt.Sprintf("http://%s/", listener.Addr())
+
+	state, err := randomState()
+	if err != nil {
+		return nil, err
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	codes := make(chan string, 1)
+	failures := make(chan error, 1)
+	server := &http.Server{
+		ReadHeaderTimeout: 10 * time.Second,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.Query()
+			if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(state)) != 1 {
+				http.Error(w, "invalid state", http.StatusBadRequest)
+				return
+			}
+			if reason := query.Get("error"); reason != "" {
+				http.Error(w, "authorization failed: "+reason, http.StatusForbidden)
+				sendError(failures, errors.Errorf("authorization failed: %s", reason))
+				return
+			}
+			code := query.Get("code")
+			if code == "" {
+				http.Error(w, "missing authorization code", http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, loopbackDone)
+			select {
+			case codes <- code:
+			default:
+			}
+		}),
+	}
+	go server.Serve(listener)
+	defer server.Close()
+
+	authURL := config.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.S256ChallengeOption(verifier))
+	fmt.Printf("Authorize access in your browser, if it doesn't open go to the following link: \n%v\n", authURL)
+	if err := openBrowser(authURL); err != nil {
+		fmt.Printf("Unable to open the browser: %v\n", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, loopbackTimeout)
+	defer cancel()
+
+	select {
+	case code := <-codes:
+		token, err := config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to retrieve token from web")
+		}
+		return token, nil
+	case err := <-failures:
+		return nil, err
+	case <-ctx.Done():
+		return nil, errors.Wrap(ctx.Err(), "authorization not completed")
+	}
+}
+
+func sendError(errs chan<- error, err error) {
+	select {
+	case errs <- err:
+	default:
+	}
+}
+
+func randomState() (string, error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", errors.Wrap(err, "failed to generate oauth state")
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func openBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	return cmd.Start()
+}
@@ -0,0 +1,88 @@
+package tmanager
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/nightlyone/lockfile"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+
+	"github.com/robertdolca/calendar-sync/clients/lockhelper"
+	"github.com/robertdolca/calendar-sync/clients/userinfo"
+)
+
+const legacyTokensBackupPath = "tokens.json.bak"
+
+// migrateTokens keys the tokens of the old anonymous list by account email.
+// Authorizing the same account more than once used to add another token, so
+// only the most recent token of each account is kept. Tokens the provider
+// refuses to refresh are dropped; the old file is kept as a backup. The lock
+// is held from reading the old list to writing the new file, so concurrent
+// processes migrate it once. When the accounts can't be identified, for
+// example offline, the file is left as is and no Google accounts are
+// returned, so commands that don't need them still run.
+func migrateTokens(ctx context.Context, config *oauth2.Config, store Store, mutex lockfile.Lockfile) (map[string]oauth2.Token, error) {
+	if err := mutex.TryLock(); err != nil {
+		return nil, errors.Wrapf(err, "failed to acquire tokens file lock")
+	}
+
+	tokens, err := migrateTokensUnsafe(ctx, config, store)
+	if err := lockhelper.MutexUnlock(mutex, err); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func migrateTokensUnsafe(ctx context.Context, config *oauth2.Config, store Store) (map[string]oauth2.Token, error) {
+	// another process may have migrated the file since it was read
+	current, legacy, err := readTokenUnsafe(store)
+	if err != nil || legacy == nil {
+		return current, err
+	}
+
+	tokens := make(map[string]oauth2.Token, len(legacy))
+	for i := range legacy {
+		source := config.TokenSource(ctx, &legacy[i])
+		email, err := userinfo.Email(ctx, source)
+		var retrieveErr *oauth2.RetrieveError
+		if errors.As(err, &retrieveErr) {
+			slog.Warn("dropping token that no longer works", "component", "tmanager", "error", err)
+			continue
+		}
+		if err != nil {
+			slog.Warn("unable to migrate tokens, google accounts are unavailable until it succeeds", "component", "tmanager", "error", err)
+			return map[string]oauth2.Token{}, nil
+		}
+		token, err := source.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		if existing, ok := tokens[email]; ok {
+			slog.Info("merging duplicate token", "component", "tmanager", "account", email)
+			if existing.Expiry.After(token.Expiry) {
+				continue
+			}
+		}
+		tokens[email] = *token
+	}
+
+	if err := saveMigratedTokensUnsafe(store, tokens); err != nil {
+		return nil, err
+	}
+
+	slog.Info("migrated tokens", "component", "tmanager", "tokens", len(legacy), "accounts", len(tokens), "backup", legacyTokensBackupPath)
+	return tokens, nil
+}
+
+func saveMigratedTokensUnsafe(store Store, tokens map[string]oauth2.Token) error {
+	data, err := store.Read(tokensPath)
+	if err != nil {
+		return errors.Wrap(err, "unable to read legacy tokens")
+	}
+	if err := store.Write(legacyTokensBackupPath, data); err != nil {
+		return errors.Wrap(err, "unable to back up legacy tokens")
+	}
+	return saveTokensUnsafe(store, tokens)
+}
@@ -0,0 +1,109 @@
+package tmanager
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nightlyone/lockfile"
+	"golang.org/x/oauth2"
+
+	"github.com/robertdolca/calendar-sync/clients/lockhelper"
+)
+
+const legacyTokens = `[{"access_token":"expired","refresh_token":"refresh","expiry":"2020-01-01T00:00:00Z"}]`
+
+// useTempDir runs the test in a temporary work directory, where the stores
+// keep their files.
+func useTempDir(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(wd)
+	})
+}
+
+func migrateTestTokens(t *testing.T, tokenURL string) (map[string]oauth2.Token, error) {
+	mutex, err := lockfile.New(lockhelper.FilePath("tokens.lock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &oauth2.Config{Endpoint: oauth2.Endpoint{TokenURL: tokenURL}}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return migrateTokens(ctx, config, fileStore{}, mutex)
+}
+
+func TestMigrateTokensOffline(t *testing.T) {
+	useTempDir(t)
+	if err := os.WriteFile(tokensPath, []byte(legacyTokens), 0600); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	tokens, err := migrateTestTokens(t, server.URL)
+	if err != nil {
+		t.Fatalf("migration failed offline: %v", err)
+	}
+	if len(tokens) != 0 {
+		t.Errorf("tokens = %v, want none", tokens)
+	}
+	if data, _ := os.ReadFile(tokensPath); string(data) != legacyTokens {
+		t.Errorf("tokens file changed to %s", data)
+	}
+	if _, err := os.Stat(legacyTokensBackupPath); !os.IsNotExist(err) {
+		t.Error("backup written without migrating")
+	}
+}
+
+func TestMigrateTokensDropsRefusedTokens(t *testing.T) {
+	useTempDir(t)
+	if err := os.WriteFile(tokensPath, []byte(legacyTokens), 0600); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant"}`))
+	}))
+	defer server.Close()
+
+	tokens, err := migrateTestTokens(t, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 0 {
+		t.Errorf("tokens = %v, want none", tokens)
+	}
+	if data, _ := os.ReadFile(tokensPath); string(data) != "{}" {
+		t.Errorf("tokens file = %s, want an empty map", data)
+	}
+	if data, _ := os.ReadFile(legacyTokensBackupPath); string(data) != legacyTokens {
+		t.Errorf("backup = %s, want the legacy tokens", data)
+	}
+}
+
+func TestMigrateTokensAlreadyMigrated(t *testing.T) {
+	useTempDir(t)
+	const migrated = `{"user@example.com":{"access_token":"token"}}`
+	if err := os.WriteFile(tokensPath, []byte(migrated), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := migrateTestTokens(t, "http://127.0.0.1:1/token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token, ok := tokens["user@example.com"]; !ok || token.AccessToken != "token" {
+		t.Errorf("tokens = %v, want the migrated file", tokens)
+	}
+}
@@ -0,0 +1,251 @@
+package tmanager
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"sort"
+
+	"github.com/nightlyone/lockfile"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/calendar/v3"
+
+	"github.com/robertdolca/calendar-sync/clients/lockhelper"
+)
+
+const serviceAccountsPath = "service_accounts.json"
+
+var serviceAccountScope = []string{
+	calendar.CalendarReadonlyScope,
+	calendar.CalendarEventsScope,
+}
+
+// ServiceAccount is a Google service account, acting as a Workspace user
+// through domain-wide delegation when Subject is set. Email is the account
+// email in sync requests: the subject, or the service account itself.
+type ServiceAccount struct {
+	Email   string          `json:"email"`
+	Subject string          `json:"subject,omitempty"`
+	Key     json.RawMessage `json:"key"`
+}
+
+// ClientEmail returns the email of the service account from its key.
+func (a ServiceAccount) ClientEmail() string {
+	var key struct {
+		ClientEmail string `json:"client_email"`
+	}
+	if err := json.Unmarshal(a.Key, &key); err != nil {
+		return ""
+	}
+	return key.ClientEmail
+}
+
+func (a ServiceAccount) tokenSource(ctx context.Context) (oauth2.TokenSource, error) {
+	config, err := google.JWTConfigFromJSON(a.Key, serviceAccountScope...)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse service account key")
+	}
+	config.Subject = a.Subject
+	return config.TokenSource(ctx), nil
+}
+
+func (m *Manager) ServiceAccounts() []ServiceAccount {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.serviceAccounts
+}
+
+// ServiceAccount returns the service account registered with the given email.
+func (m *Manager) ServiceAccount(email string) (ServiceAccount, bool) {
+	for _, account := range m.ServiceAccounts() {
+		if account.Email == email {
+			return account, true
+		}
+	}
+	return ServiceAccount{}, false
+}
+
+// AuthServiceAccount registers a service account key, acting as the subject
+// when one is given. A token is requested first, which fails when the domain
+// administrator hasn't delegated the calendar scopes to the service account.
+func (m *Manager) AuthServiceAccount(ctx context.Context, keyPath, subject string) (string, error) {
+	key, err := os.ReadFile(keyPath)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to read service account key")
+	}
+
+	account := ServiceAccount{Subject: subject, Key: key}
+	account.Email = subject
+	if account.Email == "" {
+		account.Email = account.ClientEmail()
+	}
+	if account.Email == "" {
+		return "", errors.New("service account key has no client_email")
+	}
+	if _, ok := m.GoogleAccount(account.Email); ok {
+		return "", errOAuthAccount(account.Email)
+	}
+
+	source, err := account.tokenSource(ctx)
+	if err != nil {
+		return "", err
+	}
+	if _, err := source.Token(); err != nil {
+		return "", errors.Wrap(err, "unable to get a service account token")
+	}
+
+	return account.Email, m.updateServiceAccounts(func(existing []ServiceAccount) ([]ServiceAccount, error) {
+		// another process may have authorized the email with oauth meanwhile
+		tokens, _, err := readTokenUnsafe(m.store)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := tokens[account.Email]; ok {
+			return nil, errOAuthAccount(account.Email)
+		}
+
+		accounts := make([]ServiceAccount, 0, len(existing)+1)
+		for _, other := range existing {
+			if other.Email != account.Email {
+				accounts = append(accounts, other)
+			}
+		}
+		return append(accounts, account), nil
+	})
+}
+
+// RemoveServiceAccount deletes a service account. The key stays valid until
+// it is deleted in the Google Cloud console.
+func (m *Manager) RemoveServiceAccount(email string) error {
+	return m.updateServiceAccounts(func(existing []ServiceAccount) ([]ServiceAccount, error) {
+		accounts := make([]ServiceAccount, 0, len(existing))
+		for _, other := range existing {
+			if other.Email != email {
+				accounts = append(accounts, other)
+			}
+		}
+		if len(accounts) == len(existing) {
+			return nil, errors.Errorf("service account %s not found", email)
+		}
+		return accounts, nil
+	})
+}
+
+// updateServiceAccounts applies a change to the service accounts file, read
+// again under the lock like the tokens file in updateTokens.
+func (m *Manager) updateServiceAccounts(update func(accounts []ServiceAccount) ([]ServiceAccount, error)) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err := m.tokensMutex.TryLock(); err != nil {
+		return errors.Wrapf(err, "failed to acquire tokens file lock")
+	}
+
+	accounts, err := readServiceAccountsUnsafe(m.store)
+	if err == nil {
+		accounts, err = update(accounts)
+	}
+	if err == nil {
+		err = saveServiceAccountsUnsafe(m.store, accounts)
+	}
+	if err := lockhelper.MutexUnlock(m.tokensMutex, err); err != nil {
+		return err
+	}
+
+	m.serviceAccounts = accounts
+	return nil
+}
+
+// hasServiceAccountUnsafe reports whether the email is registered as a
+// service account in the store. The tokens file lock must be held.
+func hasServiceAccountUnsafe(store Store, email string) (bool, error) {
+	accounts, err := readServiceAccountsUnsafe(store)
+	if err != nil {
+		return false, err
+	}
+	for _, account := range accounts {
+		if account.Email == email {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func errOAuthAccount(email string) error {
+	return errors.Errorf("%s is already authorized with oauth, remove it first", email)
+}
+
+// GoogleEmails returns the emails of the Google accounts, authorized with
+// OAuth or with a service account, sorted.
+func (m *Manager) GoogleEmails() []string {
+	var emails []string
+	for _, account := range m.List() {
+		emails = append(emails, account.Email)
+	}
+	for _, account := range m.ServiceAccounts() {
+		emails = append(emails, account.Email)
+	}
+	sort.Strings(emails)
+	return emails
+}
+
+// GoogleTokenSource returns the token source of a Google account authorized
+// with OAuth or with a service account.
+func (m *Manager) GoogleTokenSource(ctx context.Context, email string) (oauth2.TokenSource, error) {
+	if account, ok := m.GoogleAccount(email); ok {
+		return m.TokenSource(ctx, account), nil
+	}
+	if account, ok := m.ServiceAccount(email); ok {
+		return account.tokenSource(ctx)
+	}
+	return nil, errors.Errorf("account %s not authenticated", email)
+}
+
+func readServiceAccounts(store Store, mutex lockfile.Lockfile) ([]ServiceAccount, error) {
+	err := mutex.TryLock()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to acquire tokens file lock")
+	}
+
+	accounts, err := readServiceAccountsUnsafe(store)
+	return accounts, lockhelper.MutexUnlock(mutex, err)
+}
+
+func readServiceAccountsUnsafe(store Store) ([]ServiceAccount, error) {
+	accounts := make([]ServiceAccount, 0)
+
+	data, err := store.Read(serviceAccountsPath)
+	if isNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(data, &accounts); err != nil {
+		return nil, errors.Wrap(err, "unable to read service accounts")
+	}
+
+	slog.Debug("read service accounts", "component", "tmanager", "count", len(accounts))
+
+	return accounts, nil
+}
+
+func saveServiceAccountsUnsafe(store Store, accounts []ServiceAccount) error {
+	data, err := json.Marshal(accounts)
+	if err != nil {
+		return errors.Wrapf(err, "unable to encode service accounts")
+	}
+
+	if err := store.Write(serviceAccountsPath, data); err != nil {
+		return errors.Wrapf(err, "unable to save service accounts")
+	}
+
+	slog.Debug("saved service accounts", "component", "tmanager", "count", len(accounts))
+
+	return nil
+}
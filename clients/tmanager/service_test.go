@@ -0,0 +1,143 @@
+package tmanager
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/nightlyone/lockfile"
+	"golang.org/x/oauth2"
+
+	"github.com/robertdolca/calendar-sync/clients/lockhelper"
+)
+
+func newTestManager(t *testing.T) *Manager {
+	useTempDir(t)
+	mutex, err := lockfile.New(lockhelper.FilePath("tokens.lock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Manager{
+		tokens:      make(map[string]oauth2.Token),
+		store:       fileStore{},
+		tokensMutex: mutex,
+	}
+}
+
+// writeTestServiceAccountKey writes a service account key whose tokens are
+// requested from tokenURL.
+func writeTestServiceAccountKey(t *testing.T, tokenURL string) string {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "robot@project.iam.gserviceaccount.com",
+		"private_key": string(pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(private),
+		})),
+		"token_uri": tokenURL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := "key.json"
+	if err := os.WriteFile(path, key, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func writeTestServiceAccounts(t *testing.T, emails ...string) {
+	accounts := make([]ServiceAccount, 0, len(emails))
+	for _, email := range emails {
+		accounts = append(accounts, ServiceAccount{Email: email, Key: json.RawMessage(`{}`)})
+	}
+	if err := saveServiceAccountsUnsafe(fileStore{}, accounts); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuthServiceAccountRefusesOAuthAccount(t *testing.T) {
+	m := newTestManager(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"at","token_type":"Bearer","expires_in":3600}`))
+	}))
+	defer server.Close()
+	keyPath := writeTestServiceAccountKey(t, server.URL)
+
+	// another process authorized the email with oauth after m was created
+	tokens := map[string]oauth2.Token{"user@example.com": {AccessToken: "at"}}
+	if err := saveTokensUnsafe(fileStore{}, tokens); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.AuthServiceAccount(context.Background(), keyPath, "user@example.com"); err == nil {
+		t.Fatal("registered a service account for an oauth account")
+	}
+	if _, err := os.Stat(serviceAccountsPath); !os.IsNotExist(err) {
+		t.Error("service accounts file written")
+	}
+
+	email, err := m.AuthServiceAccount(context.Background(), keyPath, "other@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.ServiceAccount(email); !ok || email != "other@example.com" {
+		t.Errorf("service account %s not registered", email)
+	}
+}
+
+func TestRemoveServiceAccountKeepsConcurrentChanges(t *testing.T) {
+	m := newTestManager(t)
+	// another process registered the accounts after m was created
+	writeTestServiceAccounts(t, "a@example.com", "b@example.com")
+
+	if err := m.RemoveServiceAccount("a@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	accounts, err := readServiceAccountsUnsafe(fileStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 1 || accounts[0].Email != "b@example.com" {
+		t.Errorf("saved accounts = %+v, want b@example.com", accounts)
+	}
+	if _, ok := m.ServiceAccount("b@example.com"); !ok {
+		t.Error("accounts read under the lock not kept")
+	}
+
+	if err := m.RemoveServiceAccount("a@example.com"); err == nil {
+		t.Error("removed a missing service account")
+	}
+}
+
+func TestSaveTokenRefusesServiceAccount(t *testing.T) {
+	m := newTestManager(t)
+	// another process registered the account after m was created
+	writeTestServiceAccounts(t, "user@example.com")
+
+	if err := m.saveToken("user@example.com", &oauth2.Token{AccessToken: "at"}); err == nil {
+		t.Fatal("saved an oauth token for a service account")
+	}
+	if _, err := os.Stat(tokensPath); !os.IsNotExist(err) {
+		t.Error("tokens file written")
+	}
+
+	if err := m.saveToken("other@example.com", &oauth2.Token{AccessToken: "at"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.GoogleAccount("other@example.com"); !ok {
+		t.Error("token not saved")
+	}
+}
@@ -0,0 +1,135 @@
+package tmanager
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+
+	"github.com/robertdolca/calendar-sync/clients/lockhelper"
+)
+
+// persistingTokenSource saves the token whenever the underlying source
+// refreshes it, so the next run reuses the access token and a rotated refresh
+// token is not lost.
+type persistingTokenSource struct {
+	mutex  sync.Mutex
+	source oauth2.TokenSource
+	last   oauth2.Token
+	save   func(token oauth2.Token) error
+}
+
+func (s *persistingTokenSource) Token() (*oauth2.Token, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	token, err := s.source.Token()
+	if err != nil {
+		return nil, err
+	}
+	if token.AccessToken == s.last.AccessToken && token.RefreshToken == s.last.RefreshToken {
+		return token, nil
+	}
+
+	// a failed save only costs another refresh on the next run
+	if err := s.save(*token); err != nil {
+		slog.Warn("failed to save refreshed token", "component", "tmanager", "error", err)
+	} else {
+		slog.Debug("saved refreshed token", "component", "tmanager", "expiry", token.Expiry)
+	}
+	s.last = *token
+	return token, nil
+}
+
+// TokenSource returns a token source for a Google account that saves
+// refreshed tokens to the tokens file.
+func (m *Manager) TokenSource(ctx context.Context, account GoogleAccount) oauth2.TokenSource {
+	return &persistingTokenSource{
+		source: m.config.TokenSource(ctx, &account.Token),
+		last:   account.Token,
+		save: func(token oauth2.Token) error {
+			return m.updateTokens(func(tokens map[string]oauth2.Token) error {
+				if _, ok := tokens[account.Email]; !ok {
+					return errors.Errorf("google account %s not found", account.Email)
+				}
+				tokens[account.Email] = token
+				return nil
+			})
+		},
+	}
+}
+
+// GraphTokenSource returns a token source for a Microsoft account that saves
+// refreshed tokens to the accounts file.
+func (m *Manager) GraphTokenSource(ctx context.Context, config *oauth2.Config, account GraphAccount) oauth2.TokenSource {
+	return &persistingTokenSource{
+		source: config.TokenSource(ctx, &account.Token),
+		last:   account.Token,
+		save: func(token oauth2.Token) error {
+			return m.updateGraphToken(account.Email, token)
+		},
+	}
+}
+
+// CheckToken gets a token for the account, refreshing it when it expired.
+// CalDAV accounts have no token and are only checked to exist.
+func (m *Manager) CheckToken(ctx context.Context, email string) error {
+	if _, ok := m.CalDAVAccount(email); ok {
+		return nil
+	}
+
+	var source oauth2.TokenSource
+	if account, ok := m.GraphAccount(email); ok {
+		config, _, err := m.GraphConfig()
+		if err != nil {
+			return err
+		}
+		source = m.GraphTokenSource(ctx, config, account)
+	} else {
+		var err error
+		source, err = m.GoogleTokenSource(ctx, email)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := source.Token()
+	return err
+}
+
+func (m *Manager) updateGraphToken(email string, token oauth2.Token) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err := m.tokensMutex.TryLock(); err != nil {
+		return errors.Wrapf(err, "failed to acquire tokens file lock")
+	}
+
+	accounts, err := readGraphAccountsUnsafe(m.store)
+	if err == nil {
+		if !replaceGraphToken(accounts, email, token) {
+			err = errors.Errorf("microsoft account %s not found", email)
+		} else {
+			err = saveGraphAccountsUnsafe(m.store, accounts)
+		}
+	}
+	if err := lockhelper.MutexUnlock(m.tokensMutex, err); err != nil {
+		return err
+	}
+
+	replaceGraphToken(m.graphAccounts, email, token)
+	return nil
+}
+
+func replaceGraphToken(accounts []GraphAccount, email string, token oauth2.Token) bool {
+	for i, account := range accounts {
+		if strings.EqualFold(account.Email, email) {
+			accounts[i].Token = token
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+package tmanager
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/robertdolca/calendar-sync/clients/lockhelper"
+)
+
+const (
+	StoreAuto      = "auto"
+	StoreFile      = "file"
+	StoreEncrypted = "encrypted"
+	StoreHelper    = "helper"
+)
+
+// secretPaths are the files holding secrets, moved between stores by Encrypt
+// and Import.
+var secretPaths = []string{
+	tokensPath,
+	graphAccountsPath,
+	caldavPath,
+	serviceAccountsPath,
+	legacyTokensBackupPath,
+	credentialsPath,
+}
+
+// Options selects where the account secrets are stored.
+type Options struct {
+	// Store is one of StoreAuto, StoreFile, StoreEncrypted or StoreHelper.
+	// Auto uses the credential helper when one is set, otherwise the
+	// encrypted store when encrypted files exist.
+	Store string
+	// PassphraseFile holds the passphrase of the encrypted store, used when
+	// the passphrase environment variable is not set.
+	PassphraseFile string
+	// Helper is the credential helper command.
+	Helper string
+}
+
+// Store reads and writes the files holding account secrets. Reading a file
+// that doesn't exist returns an error matching os.ErrNotExist.
+type Store interface {
+	Read(name string) ([]byte, error)
+	Write(name string, data []byte) error
+	Remove(name string) error
+}
+
+// NewStore creates the store selected by the options.
+func NewStore(options Options) (Store, error) {
+	switch options.Store {
+	case StoreFile:
+		return fileStore{}, nil
+	case StoreEncrypted:
+		return newEncryptedStore(options, false)
+	case StoreHelper:
+		return newHelperStore(options.Helper)
+	case StoreAuto, "":
+		if options.Helper != "" {
+			return newHelperStore(options.Helper)
+		}
+		if encryptedFilesExist() {
+			return newEncryptedStore(options, false)
+		}
+		return fileStore{}, nil
+	default:
+		return nil, errors.Errorf("unknown token store %q", options.Store)
+	}
+}
+
+// fileStore keeps the files in plaintext in the work directory, readable
+// only by the owner.
+type fileStore struct{}
+
+func (fileStore) Read(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+func (fileStore) Write(name string, data []byte) error {
+	return os.WriteFile(name, data, 0600)
+}
+
+func (fileStore) Remove(name string) error {
+	err := os.Remove(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func isNotExist(err error) bool {
+	return errors.Is(err, os.ErrNotExist)
+}
+
+// Encrypt moves the secrets from plaintext files to the encrypted store.
+func (m *Manager) Encrypt() error {
+	if _, ok := m.store.(fileStore); !ok {
+		return errors.New("only plaintext files can be encrypted")
+	}
+	store, err := newEncryptedStore(m.options, true)
+	if err != nil {
+		return err
+	}
+	return m.moveSecrets(store)
+}
+
+// Import moves the secrets from plaintext files to the configured store, so
+// no secrets are left in the work directory.
+func (m *Manager) Import() error {
+	if _, ok := m.store.(fileStore); ok {
+		return errors.New("the plaintext files are already the configured store")
+	}
+	return m.moveSecrets(m.store)
+}
+
+// moveSecrets moves the plaintext files to the store. Each file is read back
+// before the plaintext file is removed.
+func (m *Manager) moveSecrets(store Store) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err := m.tokensMutex.TryLock(); err != nil {
+		return errors.Wrapf(err, "failed to acquire tokens file lock")
+	}
+	err := lockhelper.MutexUnlock(m.tokensMutex, moveSecretsUnsafe(fileStore{}, store))
+	if err != nil {
+		return err
+	}
+
+	m.store = store
+	return nil
+}
+
+func moveSecretsUnsafe(from, to Store) error {
+	for _, name := range secretPaths {
+		data, err := from.Read(name)
+		if isNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "unable to read %s", name)
+		}
+
+		if err := to.Write(name, data); err != nil {
+			return errors.Wrapf(err, "unable to write %s", name)
+		}
+		written, err := to.Read(name)
+		if err != nil || !bytes.Equal(written, data) {
+			return errors.Errorf("unable to verify %s, the plaintext file was kept", name)
+		}
+
+		if err := from.Remove(name); err != nil {
+			return errors.Wrapf(err, "unable to remove plaintext %s", name)
+		}
+		slog.Info("moved file", "component", "tmanager", "file", name)
+	}
+	return nil
+}
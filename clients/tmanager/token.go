@@ -1,11 +1,17 @@
 package tmanager
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
-	"fmt"
-	"io/ioutil"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
 	"os"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/nightlyone/lockfile"
 	"github.com/pkg/errors"
@@ -15,11 +21,13 @@ import (
 	goauth2 "google.golang.org/api/oauth2/v2"
 
 	"github.com/robertdolca/calendar-sync/clients/lockhelper"
+	"github.com/robertdolca/calendar-sync/clients/userinfo"
 )
 
 const (
 	tokensPath      = "tokens.json"
 	credentialsPath = "credentials.json"
+	googleRevokeURL = "https://oauth2.googleapis.com/revoke"
 )
 
 var (
@@ -31,44 +39,174 @@ var (
 )
 
 type Manager struct {
-	tokens      []oauth2.Token
-	config      oauth2.Config
-	tokensMutex lockfile.Lockfile
+	// mutex guards the accounts updated by token sources
+	mutex          sync.Mutex
+	tokens         map[string]oauth2.Token
+	caldavAccounts []CalDAVAccount
+	graphAccounts  []GraphAccount
+	// serviceAccounts are Google accounts authorized with service account
+	// keys
+	serviceAccounts []ServiceAccount
+	config          oauth2.Config
+	store           Store
+	options         Options
+	tokensMutex     lockfile.Lockfile
 }
 
-func New() (*Manager, error) {
+// GoogleAccount is a Google account authorized with OAuth.
+type GoogleAccount struct {
+	Email string
+	Token oauth2.Token
+}
+
+func New(options Options) (*Manager, error) {
 	tokensMutex, err := lockfile.New(lockhelper.FilePath("tokens.lock"))
 	if err != nil {
 		return nil, err
 	}
 
-	tokens, err := readTokens(tokensMutex)
+	store, err := NewStore(options)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := readConfig(store)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, legacy, err := readTokens(store, tokensMutex)
+	if err != nil {
+		return nil, err
+	}
+	if legacy != nil {
+		tokens, err = migrateTokens(context.Background(), config, store, tokensMutex)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	caldavAccounts, err := readCalDAVAccounts(store, tokensMutex)
+	if err != nil {
+		return nil, err
+	}
+
+	graphAccounts, err := readGraphAccounts(store, tokensMutex)
 	if err != nil {
 		return nil, err
 	}
 
-	config, err := readConfig()
+	serviceAccounts, err := readServiceAccounts(store, tokensMutex)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Manager{
-		tokens:      tokens,
-		config:      *config,
-		tokensMutex: tokensMutex,
+		tokens:          tokens,
+		caldavAccounts:  caldavAccounts,
+		graphAccounts:   graphAccounts,
+		serviceAccounts: serviceAccounts,
+		config:          *config,
+		store:           store,
+		options:         options,
+		tokensMutex:     tokensMutex,
 	}, nil
 }
 
-func (m *Manager) List() []oauth2.Token {
-	return m.tokens
+// List returns the Google accounts sorted by email.
+func (m *Manager) List() []GoogleAccount {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	accounts := make([]GoogleAccount, 0, len(m.tokens))
+	for email, token := range m.tokens {
+		accounts = append(accounts, GoogleAccount{Email: email, Token: token})
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].Email < accounts[j].Email
+	})
+	return accounts
+}
+
+// GoogleAccount returns the Google account with the given email.
+func (m *Manager) GoogleAccount(email string) (GoogleAccount, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	token, ok := m.tokens[email]
+	return GoogleAccount{Email: email, Token: token}, ok
+}
+
+// add saves the token under the email of the account it authorizes,
+// replacing the previous token of the account. If an email is expected, a
+// token of another account is refused.
+func (m *Manager) add(ctx context.Context, token *oauth2.Token, expected string) (string, error) {
+	email, err := userinfo.Email(ctx, m.config.TokenSource(ctx, token))
+	if err != nil {
+		return "", err
+	}
+	if expected != "" && email != expected {
+		return "", errors.Errorf("authorized %s instead of %s, the token was not saved", email, expected)
+	}
+
+	return email, m.saveToken(email, token)
+}
+
+// saveToken saves the token of a Google account, unless the email is
+// registered as a service account.
+func (m *Manager) saveToken(email string, token *oauth2.Token) error {
+	return m.updateTokens(func(tokens map[string]oauth2.Token) error {
+		exists, err := hasServiceAccountUnsafe(m.store, email)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return errors.Errorf("%s is already authorized with a service account, remove it first", email)
+		}
+		tokens[email] = *token
+		return nil
+	})
 }
 
-func (m *Manager) add(token oauth2.Token) error {
-	tokens := append([]oauth2.Token{}, m.tokens...)
-	tokens = append(tokens, token)
-	if err := saveTokens(m.tokensMutex, tokens); err != nil {
+// RemoveGoogle deletes the token of a Google account. The access granted by
+// the token is not revoked, see Revoke.
+func (m *Manager) RemoveGoogle(email string) error {
+	return m.updateTokens(func(tokens map[string]oauth2.Token) error {
+		if _, ok := tokens[email]; !ok {
+			return errors.Errorf("google account %s not found", email)
+		}
+		delete(tokens, email)
+		return nil
+	})
+}
+
+// updateTokens applies a change to the tokens file. The file is read again
+// under the lock so changes made by other processes in the meantime are kept.
+func (m *Manager) updateTokens(update func(tokens map[string]oauth2.Token) error) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err := m.tokensMutex.TryLock(); err != nil {
+		return errors.Wrapf(err, "failed to acquire tokens file lock")
+	}
+
+	tokens, legacy, err := readTokenUnsafe(m.store)
+	if err == nil && legacy != nil {
+		err = errors.New("tokens file has not been migrated")
+	}
+	if err == nil {
+		if tokens == nil {
+			tokens = make(map[string]oauth2.Token)
+		}
+		err = update(tokens)
+	}
+	if err == nil {
+		err = saveTokensUnsafe(m.store, tokens)
+	}
+	if err := lockhelper.MutexUnlock(m.tokensMutex, err); err != nil {
 		return err
 	}
+
 	m.tokens = tokens
 	return nil
 }
@@ -77,28 +215,87 @@ func (m *Manager) Config() *oauth2.Config {
 	return &m.config
 }
 
-// GetTokenFromWeb requests a token from the web, then returns the retrieved token.
-func (m *Manager) Auth(ctx context.Context) error {
-	config := m.Config()
+// Auth authorizes access to a Google account in the browser and saves the
+// token. It returns the email of the account.
+func (m *Manager) Auth(ctx context.Context) (string, error) {
+	return m.auth(ctx, false, "")
+}
+
+// AuthDevice authorizes access to a Google account from another device, for
+// machines without a browser, and saves the token. The OAuth client has to be
+// of the "TVs and Limited Input devices" type, and Google only grants the
+// calendar scopes in this flow to some clients.
+func (m *Manager) AuthDevice(ctx context.Context) (string, error) {
+	return m.auth(ctx, true, "")
+}
+
+// Reauth authorizes access to a Google account again, replacing its token.
+func (m *Manager) Reauth(ctx context.Context, email string, device bool) error {
+	if _, ok := m.GoogleAccount(email); !ok {
+		return errors.Errorf("google account %s not found", email)
+	}
+	_, err := m.auth(ctx, device, email)
+	return err
+}
+
+func (m *Manager) auth(ctx context.Context, device bool, email string) (string, error) {
+	config := *m.Config()
+
+	var token *oauth2.Token
+	var err error
+	if device {
+		if config.Endpoint.DeviceAuthURL == "" {
+			config.Endpoint.DeviceAuthURL = google.Endpoint.DeviceAuthURL
+		}
+		token, err = deviceAuth(ctx, &config)
+		if isInvalidScope(err) {
+			return "", errors.New("google doesn't allow calendar access with the device flow for this OAuth client, " +
+				"run auth without -device on a machine with a browser and copy the tokens, or use a service account")
+		}
+	} else {
+		token, err = loopbackAuth(ctx, config)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return m.add(ctx, token, email)
+}
 
-	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
-	fmt.Printf("Go to the following link in your browser then type the authorization code: \n%v\n", authURL)
+// Revoke revokes the access granted to the app by a Google account. Revoking
+// the refresh token also revokes the access tokens issued with it.
+func (m *Manager) Revoke(ctx context.Context, account GoogleAccount) error {
+	token := account.Token.RefreshToken
+	if token == "" {
+		token = account.Token.AccessToken
+	}
 
-	var authCode string
-	if _, err := fmt.Scan(&authCode); err != nil {
-		return errors.Wrap(err, "unable to readTokens authorization code")
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, googleRevokeURL, strings.NewReader(url.Values{"token": {token}}.Encode()))
+	if err != nil {
+		return errors.Wrap(err, "unable to create revoke request")
 	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	token, err := config.Exchange(ctx, authCode)
+	response, err := http.DefaultClient.Do(request)
 	if err != nil {
-		return errors.Wrap(err, "Unable to retrieve token from web")
+		return errors.Wrap(err, "revoke request failed")
 	}
+	defer response.Body.Close()
 
-	return m.add(*token)
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return errors.Errorf("revoke request failed: %s: %s", response.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
 }
 
-func readConfig() (*oauth2.Config, error) {
-	credentials, err := ioutil.ReadFile(credentialsPath)
+// readConfig reads the Google API app credentials from the store, falling
+// back to the file in the work directory.
+func readConfig(store Store) (*oauth2.Config, error) {
+	credentials, err := store.Read(credentialsPath)
+	if isNotExist(err) {
+		credentials, err = os.ReadFile(credentialsPath)
+	}
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to readTokens client secret file")
 	}
@@ -112,53 +309,57 @@ func readConfig() (*oauth2.Config, error) {
 	return config, nil
 }
 
-// Retrieves a token from a local file.
-func readTokens(mutex lockfile.Lockfile) ([]oauth2.Token, error) {
+// Retrieves the tokens from a local file. Files in the format used before
+// tokens were keyed by email are returned as legacy tokens.
+func readTokens(store Store, mutex lockfile.Lockfile) (map[string]oauth2.Token, []oauth2.Token, error) {
 	err := mutex.TryLock()
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to acquire tokens file lock")
+		return nil, nil, errors.Wrapf(err, "failed to acquire tokens file lock")
 	}
 
-	tokens, err := readTokenUnsafe()
-	return tokens, lockhelper.MutexUnlock(mutex, err)
+	tokens, legacy, err := readTokenUnsafe(store)
+	return tokens, legacy, lockhelper.MutexUnlock(mutex, err)
 }
 
-func readTokenUnsafe() ([]oauth2.Token, error) {
-	tokens := make([]oauth2.Token, 0)
-
-	file, err := os.Open(tokensPath)
-	if os.IsNotExist(err) {
-		return nil, nil
+func readTokenUnsafe(store Store) (map[string]oauth2.Token, []oauth2.Token, error) {
+	data, err := store.Read(tokensPath)
+	if isNotExist(err) {
+		return nil, nil, nil
 	}
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	if err = json.NewDecoder(file).Decode(&tokens); err != nil {
-		return nil, err
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		var legacy []oauth2.Token
+		if err := json.Unmarshal(trimmed, &legacy); err != nil {
+			return nil, nil, errors.Wrap(err, "unable to read legacy tokens")
+		}
+		return nil, legacy, nil
 	}
 
-	return tokens, file.Close()
-}
-
-// Saves a token to a file tokensPath.
-func saveTokens(mutex lockfile.Lockfile, tokens []oauth2.Token) error {
-	err := mutex.TryLock()
-	if err != nil {
-		return errors.Wrapf(err, "failed to acquire tokens file lock")
+	tokens := make(map[string]oauth2.Token)
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, nil, errors.Wrap(err, "unable to read tokens")
 	}
-	return lockhelper.MutexUnlock(mutex, saveTokensUnsafe(tokens))
+
+	slog.Debug("read tokens", "component", "tmanager", "count", len(tokens))
+
+	return tokens, nil, nil
 }
 
-func saveTokensUnsafe(tokens []oauth2.Token) error {
-	file, err := os.OpenFile(tokensPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+// Saves the tokens to the file tokensPath.
+func saveTokensUnsafe(store Store, tokens map[string]oauth2.Token) error {
+	data, err := json.Marshal(tokens)
 	if err != nil {
-		return errors.Wrapf(err, "unable to open tokens file in write mode")
+		return errors.Wrapf(err, "unable to encode oauth tokens")
 	}
 
-	if err := json.NewEncoder(file).Encode(tokens); err != nil {
-		return errors.Wrapf(err, "unable to save oauth token")
+	if err := store.Write(tokensPath, data); err != nil {
+		return errors.Wrapf(err, "unable to save oauth tokens")
 	}
 
-	return file.Close()
+	slog.Debug("saved tokens", "component", "tmanager", "count", len(tokens))
+
+	return nil
 }
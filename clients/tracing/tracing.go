@@ -0,0 +1,106 @@
+package tracing
+
+import (
+	"context"
+	"os"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	ExporterNone = "none"
+	ExporterOTLP = "otlp"
+	ExporterFile = "file"
+
+	serviceName     = "calendar-sync"
+	instrumentation = "github.com/robertdolca/calendar-sync"
+)
+
+type Options struct {
+	Exporter string
+	// Endpoint is the OTLP HTTP collector address, eg. localhost:4318.
+	Endpoint string
+	// Insecure disables TLS when talking to the collector.
+	Insecure bool
+	// File receives the spans as JSON when the file exporter is used.
+	File string
+}
+
+// Setup installs the global tracer provider. Tracing is a no-op unless an
+// exporter is selected. The returned function flushes the pending spans.
+func Setup(ctx context.Context, options Options) (func(context.Context) error, error) {
+	var (
+		exporter sdktrace.SpanExporter
+		closeFn  = func() error { return nil }
+	)
+
+	switch options.Exporter {
+	case ExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		clientOptions := []otlptracehttp.Option{}
+		if options.Endpoint != "" {
+			clientOptions = append(clientOptions, otlptracehttp.WithEndpoint(options.Endpoint))
+		}
+		if options.Insecure {
+			clientOptions = append(clientOptions, otlptracehttp.WithInsecure())
+		}
+		otlpExporter, err := otlptracehttp.New(ctx, clientOptions...)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create otlp exporter")
+		}
+		exporter = otlpExporter
+	case ExporterFile:
+		if options.File == "" {
+			return nil, errors.New("trace file not specified")
+		}
+		file, err := os.OpenFile(options.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to open trace file")
+		}
+		fileExporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+		if err != nil {
+			_ = file.Close()
+			return nil, errors.Wrap(err, "failed to create file exporter")
+		}
+		exporter, closeFn = fileExporter, file.Close
+	default:
+		return nil, errors.Errorf("invalid trace exporter: %s", options.Exporter)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(serviceName))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		if err := provider.Shutdown(ctx); err != nil {
+			return errors.Wrap(err, "failed to flush spans")
+		}
+		return closeFn()
+	}, nil
+}
+
+// Start creates a span using the global tracer provider.
+func Start(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentation).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// End records the error, if any, and ends the span.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -7,24 +7,11 @@ import (
 	"golang.org/x/oauth2"
 	goauth2 "google.golang.org/api/oauth2/v2"
 	"google.golang.org/api/option"
-
-	"github.com/robertdolca/calendar-sync/clients/tmanager"
 )
 
-type Manager struct {
-	tokenManager *tmanager.Manager
-}
-
-func New(tokenManager *tmanager.Manager) *Manager {
-	return &Manager{
-		tokenManager: tokenManager,
-	}
-}
-
-func (i *Manager) Email(ctx context.Context, token *oauth2.Token) (string, error) {
-	config := i.tokenManager.Config()
-
-	email, err := userEmail(ctx, config, token)
+// Email returns the email of the Google account the token source authorizes.
+func Email(ctx context.Context, tokenSource oauth2.TokenSource) (string, error) {
+	email, err := userEmail(ctx, tokenSource)
 	if err != nil {
 		return "", errors.Wrap(err, "failed to get user email")
 	}
@@ -32,8 +19,8 @@ func (i *Manager) Email(ctx context.Context, token *oauth2.Token) (string, error
 	return email, nil
 }
 
-func userEmail(ctx context.Context, config *oauth2.Config, token *oauth2.Token) (string, error) {
-	authService, err := goauth2.NewService(ctx, option.WithTokenSource(config.TokenSource(ctx, token)))
+func userEmail(ctx context.Context, tokenSource oauth2.TokenSource) (string, error) {
+	authService, err := goauth2.NewService(ctx, option.WithTokenSource(tokenSource))
 	if err != nil {
 		return "", errors.Wrap(err, "failed to create ouath2 client")
 	}
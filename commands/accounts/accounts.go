@@ -0,0 +1,334 @@
+package accounts
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/google/subcommands"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/pkg/errors"
+
+	"github.com/robertdolca/calendar-sync/clients/tmanager"
+	"github.com/robertdolca/calendar-sync/commands/output"
+)
+
+const (
+	googlePermissionsURL    = "https://myaccount.google.com/permissions"
+	microsoftPermissionsURL = "https://myapps.microsoft.com"
+)
+
+type accounts struct {
+	tokenManager *tmanager.Manager
+}
+
+func New(tokenManager *tmanager.Manager) subcommands.Command {
+	return &accounts{
+		tokenManager: tokenManager,
+	}
+}
+
+func (*accounts) Name() string {
+	return "accounts"
+}
+
+func (*accounts) Synopsis() string {
+	return "List, remove and re-authenticate accounts"
+}
+
+func (*accounts) Usage() string {
+	return `accounts list [-output table|json|csv|yaml]
+accounts remove <email>
+accounts reauth [-device] <email>
+accounts encrypt
+accounts import
+`
+}
+
+func (*accounts) SetFlags(*flag.FlagSet) {}
+
+func (a *accounts) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	flags := flag.NewFlagSet("accounts", flag.ContinueOnError)
+	if err := flags.Parse(f.Args()); err != nil {
+		return subcommands.ExitUsageError
+	}
+
+	commander := subcommands.NewCommander(flags, "accounts")
+	commander.Register(&list{tokenManager: a.tokenManager}, "")
+	commander.Register(&remove{tokenManager: a.tokenManager}, "")
+	commander.Register(&reauth{tokenManager: a.tokenManager}, "")
+	commander.Register(&encrypt{tokenManager: a.tokenManager}, "")
+	commander.Register(&importFiles{tokenManager: a.tokenManager}, "")
+	return commander.Execute(ctx)
+}
+
+type list struct {
+	tokenManager *tmanager.Manager
+	output       string
+}
+
+// accountInfo is an account in the list output. Details is the service
+// account of delegated Google accounts and the server of CalDAV accounts.
+type accountInfo struct {
+	Account  string `json:"account"`
+	Provider string `json:"provider"`
+	Details  string `json:"details,omitempty"`
+}
+
+type accountList []accountInfo
+
+func (accountList) Header() table.Row {
+	return table.Row{"Account", "Provider", "Details"}
+}
+
+func (l accountList) Rows() []table.Row {
+	rows := make([]table.Row, 0, len(l))
+	for _, account := range l {
+		rows = append(rows, table.Row{account.Account, account.Provider, account.Details})
+	}
+	return rows
+}
+
+func (accountList) Fields() []string {
+	return []string{"account", "provider", "details"}
+}
+
+func (l accountList) Records() [][]string {
+	records := make([][]string, 0, len(l))
+	for _, account := range l {
+		records = append(records, []string{account.Account, account.Provider, account.Details})
+	}
+	return records
+}
+
+func (*list) Name() string {
+	return "list"
+}
+
+func (*list) Synopsis() string {
+	return "List authenticated accounts"
+}
+
+func (*list) Usage() string {
+	return "accounts list [-output table|json|csv|yaml]\n"
+}
+
+func (l *list) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&l.output, "output", output.Table, output.Usage)
+}
+
+func (l *list) Execute(context.Context, *flag.FlagSet, ...interface{}) subcommands.ExitStatus {
+	if err := output.Validate(l.output); err != nil {
+		fmt.Println(err)
+		return subcommands.ExitUsageError
+	}
+
+	accounts := make(accountList, 0)
+	for _, account := range l.tokenManager.List() {
+		accounts = append(accounts, accountInfo{Account: account.Email, Provider: "google"})
+	}
+	for _, account := range l.tokenManager.ServiceAccounts() {
+		accounts = append(accounts, accountInfo{Account: account.Email, Provider: "google-service-account", Details: account.ClientEmail()})
+	}
+	for _, account := range l.tokenManager.GraphAccounts() {
+		accounts = append(accounts, accountInfo{Account: account.Email, Provider: "microsoft"})
+	}
+	for _, account := range l.tokenManager.CalDAVAccounts() {
+		accounts = append(accounts, accountInfo{Account: account.Name, Provider: "caldav", Details: account.URL})
+	}
+
+	if err := output.Print(os.Stdout, l.output, accounts); err != nil {
+		fmt.Println(err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+type remove struct {
+	tokenManager *tmanager.Manager
+}
+
+func (*remove) Name() string {
+	return "remove"
+}
+
+func (*remove) Synopsis() string {
+	return "Remove an account and revoke its access"
+}
+
+func (*remove) Usage() string {
+	return "accounts remove <email>\n"
+}
+
+func (*remove) SetFlags(*flag.FlagSet) {}
+
+func (r *remove) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	if f.NArg() != 1 {
+		fmt.Print(r.Usage())
+		return subcommands.ExitUsageError
+	}
+
+	if err := r.remove(ctx, f.Arg(0)); err != nil {
+		fmt.Println(err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+// remove revokes the access of Google accounts before deleting them. A
+// failed revocation doesn't keep the account, the access can still be
+// removed from the account settings.
+func (r *remove) remove(ctx context.Context, email string) error {
+	if account, ok := r.tokenManager.GoogleAccount(email); ok {
+		if err := r.tokenManager.Revoke(ctx, account); err != nil {
+			fmt.Printf("Unable to revoke access, remove it at %s: %v\n", googlePermissionsURL, err)
+		}
+		if err := r.tokenManager.RemoveGoogle(email); err != nil {
+			return err
+		}
+		fmt.Printf("Removed %s\n", email)
+		return nil
+	}
+
+	if account, ok := r.tokenManager.ServiceAccount(email); ok {
+		if err := r.tokenManager.RemoveServiceAccount(email); err != nil {
+			return err
+		}
+		fmt.Printf("Removed %s, the key of %s stays valid until it is deleted in the Google Cloud console\n", email, account.ClientEmail())
+		return nil
+	}
+
+	if _, ok := r.tokenManager.GraphAccount(email); ok {
+		if err := r.tokenManager.RemoveGraph(email); err != nil {
+			return err
+		}
+		fmt.Printf("Removed %s, the app access can be removed at %s\n", email, microsoftPermissionsURL)
+		return nil
+	}
+
+	if _, ok := r.tokenManager.CalDAVAccount(email); ok {
+		if err := r.tokenManager.RemoveCalDAV(email); err != nil {
+			return err
+		}
+		fmt.Printf("Removed %s\n", email)
+		return nil
+	}
+
+	return errors.Errorf("account %s not found", email)
+}
+
+type reauth struct {
+	tokenManager *tmanager.Manager
+	device       bool
+}
+
+func (*reauth) Name() string {
+	return "reauth"
+}
+
+func (*reauth) Synopsis() string {
+	return "Authorize an account again, replacing its token"
+}
+
+func (*reauth) Usage() string {
+	return "accounts reauth [-device] <email>\n"
+}
+
+func (r *reauth) SetFlags(f *flag.FlagSet) {
+	f.BoolVar(&r.device, "device", false, "Authorize the Google account by entering a code on another device, which Google refuses for the calendar scopes with most OAuth clients (default: false)")
+}
+
+func (r *reauth) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	if f.NArg() != 1 {
+		fmt.Print(r.Usage())
+		return subcommands.ExitUsageError
+	}
+
+	if err := r.reauth(ctx, f.Arg(0)); err != nil {
+		fmt.Println(err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+func (r *reauth) reauth(ctx context.Context, email string) error {
+	if _, ok := r.tokenManager.GraphAccount(email); ok {
+		if _, err := r.tokenManager.AuthGraph(ctx, email); err != nil {
+			return err
+		}
+		fmt.Printf("Updated %s\n", email)
+		return nil
+	}
+
+	if _, ok := r.tokenManager.ServiceAccount(email); ok {
+		return errors.New("service accounts have no token, add the account again with auth -service-account to change the key")
+	}
+
+	if _, ok := r.tokenManager.CalDAVAccount(email); ok {
+		return errors.New("caldav accounts have no token, add the account again with auth -caldav to change the password")
+	}
+
+	if _, ok := r.tokenManager.GoogleAccount(email); !ok {
+		return errors.Errorf("account %s not found", email)
+	}
+	if err := r.tokenManager.Reauth(ctx, email, r.device); err != nil {
+		return err
+	}
+	fmt.Printf("Updated %s\n", email)
+	return nil
+}
+
+type encrypt struct {
+	tokenManager *tmanager.Manager
+}
+
+func (*encrypt) Name() string {
+	return "encrypt"
+}
+
+func (*encrypt) Synopsis() string {
+	return "Encrypt the stored tokens with a passphrase"
+}
+
+func (*encrypt) Usage() string {
+	return "accounts encrypt\n"
+}
+
+func (*encrypt) SetFlags(*flag.FlagSet) {}
+
+func (e *encrypt) Execute(context.Context, *flag.FlagSet, ...interface{}) subcommands.ExitStatus {
+	if err := e.tokenManager.Encrypt(); err != nil {
+		fmt.Println(err)
+		return subcommands.ExitFailure
+	}
+	fmt.Println("Encrypted the tokens, the plaintext files were removed")
+	return subcommands.ExitSuccess
+}
+
+type importFiles struct {
+	tokenManager *tmanager.Manager
+}
+
+func (*importFiles) Name() string {
+	return "import"
+}
+
+func (*importFiles) Synopsis() string {
+	return "Move the tokens and credentials files to the configured token store"
+}
+
+func (*importFiles) Usage() string {
+	return "accounts import\n"
+}
+
+func (*importFiles) SetFlags(*flag.FlagSet) {}
+
+func (i *importFiles) Execute(context.Context, *flag.FlagSet, ...interface{}) subcommands.ExitStatus {
+	if err := i.tokenManager.Import(); err != nil {
+		fmt.Println(err)
+		return subcommands.ExitFailure
+	}
+	fmt.Println("Moved the tokens and credentials, the plaintext files were removed")
+	return subcommands.ExitSuccess
+}
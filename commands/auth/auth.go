@@ -1,17 +1,32 @@
 package auth
 
 import (
+	"bufio"
 	"context"
 	"flag"
 	"fmt"
+	"os"
+	"strings"
 
 	"github.com/google/subcommands"
+	"github.com/pkg/errors"
 
+	"github.com/robertdolca/calendar-sync/clients/calendar/ccommon"
 	"github.com/robertdolca/calendar-sync/clients/tmanager"
 )
 
+// caldavPasswordEnv is read instead of prompting for the CalDAV password.
+const caldavPasswordEnv = "CALDAV_PASSWORD"
+
 type auth struct {
-	tokenManager *tmanager.Manager
+	tokenManager   *tmanager.Manager
+	caldavURL      string
+	caldavUsername string
+	caldavName     string
+	microsoft      bool
+	device         bool
+	serviceAccount string
+	subject        string
 }
 
 func New(tokenManager *tmanager.Manager) subcommands.Command {
@@ -32,12 +47,117 @@ func (*auth) Usage() string {
 	return ``
 }
 
-func (a *auth) SetFlags(*flag.FlagSet) {}
+func (a *auth) SetFlags(f *flag.FlagSet) {
+	f.BoolVar(&a.device, "device", false, "Authorize the Google account by entering a code on another device, for machines without a browser. Google refuses the calendar scopes in this flow for most OAuth clients (default: false)")
+	f.StringVar(&a.serviceAccount, "service-account", "", "Add a Google service account from this JSON key file instead of authorizing with OAuth (optional)")
+	f.StringVar(&a.subject, "subject", "", "Workspace user the service account acts as, using domain-wide delegation (optional)")
+	f.BoolVar(&a.microsoft, "microsoft", false, "Add a Microsoft 365 or Outlook.com account instead of a Google account (default: false)")
+	f.StringVar(&a.caldavURL, "caldav", "", "Add a CalDAV account on the server with this URL instead of a Google account (optional)")
+	f.StringVar(&a.caldavUsername, "username", "", "CalDAV username")
+	f.StringVar(&a.caldavName, "name", "", "Name of the CalDAV account in sync requests (default: the username)")
+}
 
 func (a *auth) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
-	if err := a.tokenManager.Auth(ctx); err != nil {
+	if a.microsoft {
+		if err := a.authGraph(ctx); err != nil {
+			fmt.Println(err)
+			return subcommands.ExitFailure
+		}
+		return subcommands.ExitSuccess
+	}
+
+	if a.serviceAccount != "" {
+		email, err := a.tokenManager.AuthServiceAccount(ctx, a.serviceAccount, a.subject)
+		if err != nil {
+			fmt.Println(err)
+			return subcommands.ExitFailure
+		}
+		fmt.Printf("Added %s\n", email)
+		return subcommands.ExitSuccess
+	}
+
+	if a.caldavURL != "" {
+		if err := a.authCalDAV(ctx); err != nil {
+			fmt.Println(err)
+			return subcommands.ExitFailure
+		}
+		return subcommands.ExitSuccess
+	}
+
+	authorize := a.tokenManager.Auth
+	if a.device {
+		authorize = a.tokenManager.AuthDevice
+	}
+	email, err := authorize(ctx)
+	if err != nil {
 		fmt.Println(err)
 		return subcommands.ExitFailure
 	}
+
+	fmt.Printf("Added %s\n", email)
 	return subcommands.ExitSuccess
 }
+
+// authGraph signs in with a device code and identifies the account by the
+// email of the signed in user.
+func (a *auth) authGraph(ctx context.Context) error {
+	email, err := a.tokenManager.AuthGraph(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Added %s\n", email)
+	return nil
+}
+
+// authCalDAV checks that the credentials can list calendars before saving
+// the account.
+func (a *auth) authCalDAV(ctx context.Context) error {
+	if a.caldavUsername == "" {
+		return errors.New("caldav username not specified")
+	}
+
+	password, err := caldavPassword()
+	if err != nil {
+		return err
+	}
+
+	account := tmanager.CalDAVAccount{
+		Name:     a.caldavName,
+		URL:      a.caldavURL,
+		Username: a.caldavUsername,
+		Password: password,
+	}
+	if account.Name == "" {
+		account.Name = account.Username
+	}
+
+	client, err := ccommon.CalDAVClient(account)
+	if err != nil {
+		return err
+	}
+	calendars, err := client.Calendars(ctx)
+	if err != nil {
+		return errors.Wrap(err, "unable to list caldav calendars")
+	}
+
+	if err := a.tokenManager.AddCalDAV(account); err != nil {
+		return err
+	}
+
+	fmt.Printf("Added %s with %d calendars\n", account.Name, len(calendars))
+	return nil
+}
+
+func caldavPassword() (string, error) {
+	if password := os.Getenv(caldavPasswordEnv); password != "" {
+		return password, nil
+	}
+
+	fmt.Print("CalDAV password: ")
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return "", errors.Wrap(err, "unable to read caldav password")
+	}
+	return strings.TrimRight(password, "\r\n"), nil
+}
@@ -1,20 +1,35 @@
 package clear
 
 import (
+	"bufio"
 	"context"
 	"flag"
 	"fmt"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/google/subcommands"
 	"github.com/pkg/errors"
 
 	"github.com/robertdolca/calendar-sync/clients/calendar"
+	"github.com/robertdolca/calendar-sync/clients/calendar/sync"
+	"github.com/robertdolca/calendar-sync/clients/syncdb"
 )
 
+// progressInterval is the number of events between progress lines.
+const progressInterval = 25
+
 type clearCmd struct {
-	sync     *calendar.Manager
-	account  string
-	calendar string
+	sync            *calendar.Manager
+	account         string
+	calendar        string
+	srcAccount      string
+	srcCalendar     string
+	startAfter      string
+	startBefore     string
+	onlySoftDeleted bool
+	yes             bool
 }
 
 func New(sync *calendar.Manager) subcommands.Command {
@@ -28,7 +43,7 @@ func (*clearCmd) Name() string {
 }
 
 func (*clearCmd) Synopsis() string {
-	return "Remove all synced events from a calendar"
+	return "Remove synced events from a calendar"
 }
 
 func (*clearCmd) Usage() string {
@@ -36,30 +51,122 @@ func (*clearCmd) Usage() string {
 }
 
 func (p *clearCmd) SetFlags(f *flag.FlagSet) {
-	f.StringVar(&p.account, "account", "", "Account email address")
-	f.StringVar(&p.calendar, "calendar", "", "Calendar id")
+	f.StringVar(&p.account, "account", "", "Account email address (required unless the calendar is an alias)")
+	f.StringVar(&p.calendar, "calendar", "", "Calendar id, name or alias")
+	f.StringVar(&p.srcAccount, "src-account", "", "Only remove events synced from this source account (optional)")
+	f.StringVar(&p.srcCalendar, "src-calendar", "", "Only remove events synced from this source calendar id, name, alias or iCalendar feed URL (optional)")
+	f.StringVar(&p.startAfter, "start-after", "", "Only remove events that start after the specified date and time, recurring events by their first occurrence (eg. 2006-01-02T15:04:05Z07:00)")
+	f.StringVar(&p.startBefore, "start-before", "", "Only remove events that start before the specified date and time, recurring events by their first occurrence (eg. 2006-01-02T15:04:05Z07:00)")
+	f.BoolVar(&p.onlySoftDeleted, "only-soft-deleted", false, "Only remove the records of events that were already deleted (default: false)")
+	f.BoolVar(&p.yes, "yes", false, "Don't ask for confirmation (default: false)")
 }
 
 func (p *clearCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
-	if err := p.validateInput(); err != nil {
+	filter, err := p.validateInput()
+	if err != nil {
 		fmt.Println(err)
 		return subcommands.ExitUsageError
 	}
 
-	if err := p.sync.Clear(ctx, p.account, p.calendar); err != nil {
+	account, calendarID, err := p.sync.ResolveCalendar(ctx, p.account, p.calendar)
+	if err != nil {
+		fmt.Println(err)
+		return subcommands.ExitUsageError
+	}
+
+	if p.srcCalendar != "" && p.srcAccount != sync.ICSAccount {
+		filter.SrcAccountEmail, filter.SrcCalendarID, err = p.sync.ResolveCalendar(ctx, p.srcAccount, p.srcCalendar)
+		if err != nil {
+			fmt.Println(errors.Wrap(err, "invalid source calendar"))
+			return subcommands.ExitUsageError
+		}
+	}
+	if p.srcAccount == sync.ICSAccount && strings.Contains(p.srcCalendar, "://") {
+		filter.SrcCalendarID = sync.FeedCalendarID(p.srcCalendar)
+	}
+
+	records, unknownStart, err := p.sync.ClearRecords(account, calendarID, filter)
+	if err != nil {
 		fmt.Println(err)
 		return subcommands.ExitFailure
 	}
+	if unknownStart > 0 {
+		fmt.Printf("Skipped %d synced events whose start is not recorded yet, run sync to record it\n", unknownStart)
+	}
+	if len(records) == 0 {
+		fmt.Println("No synced events match")
+		return subcommands.ExitSuccess
+	}
 
+	if !p.yes {
+		confirmed, err := confirm(fmt.Sprintf("Delete %d synced events from %s of %s? [y/N] ", len(records), calendarID, account))
+		if err != nil {
+			fmt.Println(err)
+			return subcommands.ExitFailure
+		}
+		if !confirmed {
+			fmt.Println("Nothing deleted")
+			return subcommands.ExitSuccess
+		}
+	}
+
+	done := 0
+	failed, err := p.sync.Clear(ctx, account, calendarID, records, func(record syncdb.Record, err error) {
+		done++
+		if err != nil {
+			fmt.Printf("Failed to delete event %s: %v\n", record.Dst.EventID, err)
+		}
+		if done%progressInterval == 0 && done < len(records) {
+			fmt.Printf("Processed %d/%d events\n", done, len(records))
+		}
+	})
+	fmt.Printf("Deleted %d of %d events, %d failed\n", done-failed, len(records), failed)
+	if err != nil {
+		fmt.Println(err)
+		return subcommands.ExitFailure
+	}
+	if failed > 0 {
+		return subcommands.ExitFailure
+	}
 	return subcommands.ExitSuccess
 }
 
-func (p *clearCmd) validateInput() error {
-	if p.account == "" {
-		return errors.New("account email not specified")
+func (p *clearCmd) validateInput() (calendar.ClearFilter, error) {
+	filter := calendar.ClearFilter{
+		SrcAccountEmail: p.srcAccount,
+		SrcCalendarID:   p.srcCalendar,
+		OnlySoftDeleted: p.onlySoftDeleted,
 	}
+
 	if p.calendar == "" {
-		return errors.New("calendar id not specified")
+		return filter, errors.New("calendar not specified")
+	}
+
+	var err error
+	if p.startAfter != "" {
+		filter.StartAfter, err = time.Parse(time.RFC3339, p.startAfter)
+		if err != nil {
+			return filter, errors.Wrap(err, "failed to parse start after date and time")
+		}
+	}
+	if p.startBefore != "" {
+		filter.StartBefore, err = time.Parse(time.RFC3339, p.startBefore)
+		if err != nil {
+			return filter, errors.Wrap(err, "failed to parse start before date and time")
+		}
+	}
+	if !filter.StartAfter.IsZero() && !filter.StartBefore.IsZero() && !filter.StartAfter.Before(filter.StartBefore) {
+		return filter, errors.New("start after must be before start before")
+	}
+	return filter, nil
+}
+
+func confirm(prompt string) (bool, error) {
+	fmt.Print(prompt)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false, errors.Wrap(err, "unable to read confirmation")
 	}
-	return nil
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "y" || answer == "yes", nil
 }
@@ -0,0 +1,126 @@
+package failures
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/subcommands"
+	"github.com/jedib0t/go-pretty/v6/table"
+
+	"github.com/robertdolca/calendar-sync/clients/calendar"
+	"github.com/robertdolca/calendar-sync/clients/syncdb"
+	"github.com/robertdolca/calendar-sync/commands/output"
+)
+
+type failures struct {
+	calendarManager *calendar.Manager
+	account         string
+	calendar        string
+	output          string
+}
+
+func New(calendarManager *calendar.Manager) subcommands.Command {
+	return &failures{
+		calendarManager: calendarManager,
+	}
+}
+
+func (*failures) Name() string {
+	return "failures"
+}
+
+func (*failures) Synopsis() string {
+	return "List events that failed to sync and are queued for retry"
+}
+
+func (*failures) Usage() string {
+	return "calendar failures\n"
+}
+
+func (p *failures) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&p.account, "dst-account", "", "Only show failures for this destination account email address (optional)")
+	f.StringVar(&p.calendar, "dst-calendar", "", "Only show failures for this destination calendar id (optional)")
+	f.StringVar(&p.output, "output", output.Table, output.Usage)
+}
+
+func (p *failures) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	if err := output.Validate(p.output); err != nil {
+		fmt.Println(err)
+		return subcommands.ExitUsageError
+	}
+
+	failures, err := p.calendarManager.Failures()
+	if err != nil {
+		fmt.Println(err)
+		return subcommands.ExitFailure
+	}
+
+	result := make(failureList, 0, len(failures))
+	for _, failure := range failures {
+		if p.account != "" && failure.DstAccountEmail != p.account {
+			continue
+		}
+		if p.calendar != "" && failure.DstCalendarID != p.calendar {
+			continue
+		}
+		result = append(result, failure)
+	}
+
+	if err := output.Print(os.Stdout, p.output, result); err != nil {
+		fmt.Println(err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+type failureList []syncdb.Failure
+
+func (failureList) Header() table.Row {
+	return table.Row{"Source", "Destination", "Event ID", "Attempts", "Last Attempt", "Last Error"}
+}
+
+func (l failureList) Rows() []table.Row {
+	rows := make([]table.Row, 0, 2*len(l))
+	for i, failure := range l {
+		if i > 0 {
+			rows = append(rows, nil)
+		}
+		rows = append(rows, table.Row{
+			failure.Src.AccountEmail + "\n" + failure.Src.CalendarID,
+			failure.DstAccountEmail + "\n" + failure.DstCalendarID,
+			failure.Src.EventID,
+			failure.Attempts,
+			failure.LastAttempt.Format(time.RFC3339),
+			failure.LastError,
+		})
+	}
+	return rows
+}
+
+func (failureList) Fields() []string {
+	return []string{
+		"src.eventId", "src.accountEmail", "src.calendarId", "dstAccountEmail", "dstCalendarId",
+		"attempts", "lastError", "lastAttempt",
+	}
+}
+
+func (l failureList) Records() [][]string {
+	records := make([][]string, 0, len(l))
+	for _, failure := range l {
+		records = append(records, []string{
+			failure.Src.EventID,
+			failure.Src.AccountEmail,
+			failure.Src.CalendarID,
+			failure.DstAccountEmail,
+			failure.DstCalendarID,
+			strconv.Itoa(failure.Attempts),
+			failure.LastError,
+			failure.LastAttempt.Format(time.RFC3339Nano),
+		})
+	}
+	return records
+}
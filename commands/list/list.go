@@ -5,15 +5,88 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/google/subcommands"
 	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/pkg/errors"
 
 	"github.com/robertdolca/calendar-sync/clients/calendar"
+	"github.com/robertdolca/calendar-sync/clients/calendar/ccommon"
+	"github.com/robertdolca/calendar-sync/commands/output"
 )
 
+var accessRoles = []string{
+	ccommon.AccessRoleOwner,
+	ccommon.AccessRoleWriter,
+	ccommon.AccessRoleReader,
+	ccommon.AccessRoleFreeBusyReader,
+}
+
 type list struct {
 	calendarManager *calendar.Manager
+	refresh         bool
+	output          string
+	account         string
+	accessRoles     string
+	includeHidden   bool
+	includeDeleted  bool
+}
+
+// calendarInfo is the stable schema of a listed calendar in the json, yaml
+// and csv output.
+type calendarInfo struct {
+	Account    string `json:"account"`
+	ID         string `json:"id"`
+	Summary    string `json:"summary"`
+	AccessRole string `json:"accessRole"`
+	Primary    bool   `json:"primary"`
+	TimeZone   string `json:"timeZone"`
+	Color      string `json:"color"`
+	Hidden     bool   `json:"hidden"`
+	Deleted    bool   `json:"deleted"`
+	Selected   bool   `json:"selected"`
+}
+
+type calendarList []calendarInfo
+
+func (calendarList) Header() table.Row {
+	return table.Row{"Account", "Summary", "ID", "Access", "Primary"}
+}
+
+func (l calendarList) Rows() []table.Row {
+	rows := make([]table.Row, 0, len(l))
+	for i, cal := range l {
+		if i > 0 && cal.Account != l[i-1].Account {
+			rows = append(rows, nil)
+		}
+		rows = append(rows, table.Row{cal.Account, cal.Summary, cal.ID, cal.AccessRole, cal.Primary})
+	}
+	return rows
+}
+
+func (calendarList) Fields() []string {
+	return []string{"account", "id", "summary", "accessRole", "primary", "timeZone", "color", "hidden", "deleted", "selected"}
+}
+
+func (l calendarList) Records() [][]string {
+	records := make([][]string, 0, len(l))
+	for _, cal := range l {
+		records = append(records, []string{
+			cal.Account,
+			cal.ID,
+			cal.Summary,
+			cal.AccessRole,
+			strconv.FormatBool(cal.Primary),
+			cal.TimeZone,
+			cal.Color,
+			strconv.FormatBool(cal.Hidden),
+			strconv.FormatBool(cal.Deleted),
+			strconv.FormatBool(cal.Selected),
+		})
+	}
+	return records
 }
 
 func New(calendarManager *calendar.Manager) subcommands.Command {
@@ -34,32 +107,98 @@ func (*list) Usage() string {
 	return ``
 }
 
-func (p *list) SetFlags(*flag.FlagSet) {}
+func (p *list) SetFlags(f *flag.FlagSet) {
+	f.BoolVar(&p.refresh, "refresh", false, "List the calendars again instead of using the ones listed in the last day (default: false)")
+	f.StringVar(&p.output, "output", output.Table, output.Usage)
+	f.StringVar(&p.account, "account", "", "Only list the calendars of this account (optional)")
+	f.StringVar(&p.accessRoles, "access-role", "", "Only list calendars with these comma separated access roles (options: owner / writer / reader / freeBusyReader)")
+	f.BoolVar(&p.includeHidden, "include-hidden", false, "List calendars hidden from the calendar list (default: false)")
+	f.BoolVar(&p.includeDeleted, "include-deleted", false, "List deleted calendars (default: false)")
+}
 
 func (p *list) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
-	usersCalendars, err := p.calendarManager.UsersCalendars(ctx)
+	roles, err := p.validateInput()
+	if err != nil {
+		fmt.Println(err)
+		return subcommands.ExitUsageError
+	}
+
+	calendars, err := p.calendars(ctx, roles)
 	if err != nil {
 		fmt.Println(err)
 		return subcommands.ExitFailure
 	}
-	for _, userCalendars := range usersCalendars {
-		fmt.Println(userCalendars.Email)
-		fmt.Println()
 
-		t := table.NewWriter()
-		t.SetOutputMirror(os.Stdout)
-		t.AppendHeader(table.Row{"Summary", "ID"})
+	if err := output.Print(os.Stdout, p.output, calendars); err != nil {
+		fmt.Println(err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
 
-		for _, cal := range userCalendars.Calendars {
-			if cal.Deleted {
+func (p *list) calendars(ctx context.Context, roles map[string]bool) (calendarList, error) {
+	accounts := p.calendarManager.Accounts()
+	if p.account != "" {
+		if !contains(accounts, p.account) {
+			return nil, errors.Errorf("account %s not found", p.account)
+		}
+		accounts = []string{p.account}
+	}
+
+	result := make(calendarList, 0)
+	for _, account := range accounts {
+		calendars, err := p.calendarManager.AccountCalendars(ctx, account, p.refresh)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list calendars of %s", account)
+		}
+		for _, cal := range calendars {
+			if cal.Deleted && !p.includeDeleted || cal.Hidden && !p.includeHidden {
 				continue
 			}
-			t.AppendRow([]interface{}{cal.Summary, cal.Id})
-			t.AppendSeparator()
+			if len(roles) > 0 && !roles[cal.AccessRole] {
+				continue
+			}
+			result = append(result, calendarInfo{
+				Account:    account,
+				ID:         cal.Id,
+				Summary:    cal.Summary,
+				AccessRole: cal.AccessRole,
+				Primary:    cal.Primary,
+				TimeZone:   cal.TimeZone,
+				Color:      cal.Color,
+				Hidden:     cal.Hidden,
+				Deleted:    cal.Deleted,
+				Selected:   cal.Selected,
+			})
 		}
+	}
+	return result, nil
+}
 
-		t.Render()
-		fmt.Println()
+func (p *list) validateInput() (map[string]bool, error) {
+	if err := output.Validate(p.output); err != nil {
+		return nil, err
 	}
-	return subcommands.ExitSuccess
+
+	roles := make(map[string]bool)
+	if p.accessRoles == "" {
+		return roles, nil
+	}
+	for _, role := range strings.Split(p.accessRoles, ",") {
+		role = strings.TrimSpace(role)
+		if !contains(accessRoles, role) {
+			return nil, errors.Errorf("invalid access role: %s", role)
+		}
+		roles[role] = true
+	}
+	return roles, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
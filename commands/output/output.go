@@ -0,0 +1,121 @@
+// Package output prints command results as a table or in a machine-readable
+// format.
+package output
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	Table = "table"
+	JSON  = "json"
+	CSV   = "csv"
+	YAML  = "yaml"
+)
+
+var formats = []string{Table, JSON, CSV, YAML}
+
+// Usage describes the output flag.
+const Usage = "Output format (options: table / json / csv / yaml)"
+
+// Result is what a command prints. The result itself is encoded for json
+// and yaml, its rows are printed for table. A nil row separates groups of
+// rows in the table. For csv it is flattened to records with one column per
+// json field, nested fields are named by their path, e.g. lastRun.outcome.
+type Result interface {
+	Header() table.Row
+	Rows() []table.Row
+	Fields() []string
+	Records() [][]string
+}
+
+// Validate returns an error for unknown formats.
+func Validate(format string) error {
+	for _, f := range formats {
+		if f == format {
+			return nil
+		}
+	}
+	return errors.Errorf("invalid output: %s", format)
+}
+
+// Print writes the result in the format.
+func Print(w io.Writer, format string, result Result) error {
+	switch format {
+	case JSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return errors.Wrap(encoder.Encode(result), "failed to encode json")
+	case YAML:
+		return printYAML(w, result)
+	case CSV:
+		return printCSV(w, result)
+	case Table, "":
+		printTable(w, result)
+		return nil
+	default:
+		return errors.Errorf("invalid output: %s", format)
+	}
+}
+
+func printTable(w io.Writer, result Result) {
+	t := table.NewWriter()
+	t.SetOutputMirror(w)
+	t.AppendHeader(result.Header())
+	for _, row := range result.Rows() {
+		if row == nil {
+			t.AppendSeparator()
+			continue
+		}
+		t.AppendRow(row)
+	}
+	t.Render()
+}
+
+func printCSV(w io.Writer, result Result) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(result.Fields()); err != nil {
+		return errors.Wrap(err, "failed to write csv")
+	}
+	if err := writer.WriteAll(result.Records()); err != nil {
+		return errors.Wrap(err, "failed to write csv")
+	}
+	return nil
+}
+
+// printYAML converts the json encoding, so both formats share the same field
+// names and order.
+func printYAML(w io.Writer, result Result) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode json")
+	}
+
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return errors.Wrap(err, "failed to convert json to yaml")
+	}
+	resetStyle(&node)
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&node); err != nil {
+		return errors.Wrap(err, "failed to encode yaml")
+	}
+	return errors.Wrap(encoder.Close(), "failed to encode yaml")
+}
+
+// resetStyle drops the json flow style and quoting. The encoder still quotes
+// strings that would be read back as another type.
+func resetStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		resetStyle(child)
+	}
+}
@@ -0,0 +1,187 @@
+package status
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/subcommands"
+	"github.com/jedib0t/go-pretty/v6/table"
+
+	"github.com/robertdolca/calendar-sync/clients/calendar"
+	"github.com/robertdolca/calendar-sync/commands/output"
+)
+
+// maxTableError is the length token errors are cut to in the table.
+const maxTableError = 60
+
+type status struct {
+	calendarManager *calendar.Manager
+	output          string
+}
+
+// pairStatus is the stable schema of a sync pair in the json, yaml and csv
+// output. The token health is "ok", the error getting a token, or empty for
+// iCalendar files.
+type pairStatus struct {
+	SrcAccount     string   `json:"srcAccount"`
+	SrcCalendar    string   `json:"srcCalendar"`
+	DstAccount     string   `json:"dstAccount"`
+	DstCalendar    string   `json:"dstCalendar"`
+	Records        int      `json:"records"`
+	SoftDeleted    int      `json:"softDeleted"`
+	Failures       int      `json:"failures"`
+	PendingRetries int      `json:"pendingRetries"`
+	LastRun        *lastRun `json:"lastRun"`
+	SrcToken       string   `json:"srcToken"`
+	DstToken       string   `json:"dstToken"`
+}
+
+type lastRun struct {
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	Outcome    string    `json:"outcome"`
+	Error      string    `json:"error,omitempty"`
+}
+
+type statusList []pairStatus
+
+func (statusList) Header() table.Row {
+	return table.Row{"Source", "Destination", "Records", "Soft Deleted", "Pending Retries", "Last Run", "Tokens"}
+}
+
+func (l statusList) Rows() []table.Row {
+	rows := make([]table.Row, 0, 2*len(l))
+	for i, pair := range l {
+		if i > 0 {
+			rows = append(rows, nil)
+		}
+
+		run := "never"
+		if pair.LastRun != nil {
+			run = pair.LastRun.FinishedAt.Format(time.RFC3339) + "\n" + pair.LastRun.Outcome
+		}
+
+		rows = append(rows, table.Row{
+			pair.SrcAccount + "\n" + pair.SrcCalendar,
+			pair.DstAccount + "\n" + pair.DstCalendar,
+			pair.Records,
+			pair.SoftDeleted,
+			pair.PendingRetries,
+			run,
+			tokenCell(pair.SrcToken) + "\n" + tokenCell(pair.DstToken),
+		})
+	}
+	return rows
+}
+
+func (statusList) Fields() []string {
+	return []string{
+		"srcAccount", "srcCalendar", "dstAccount", "dstCalendar",
+		"records", "softDeleted", "failures", "pendingRetries",
+		"lastRun.startedAt", "lastRun.finishedAt", "lastRun.outcome", "lastRun.error",
+		"srcToken", "dstToken",
+	}
+}
+
+func (l statusList) Records() [][]string {
+	records := make([][]string, 0, len(l))
+	for _, pair := range l {
+		run := make([]string, 4)
+		if pair.LastRun != nil {
+			run = []string{
+				pair.LastRun.StartedAt.Format(time.RFC3339Nano),
+				pair.LastRun.FinishedAt.Format(time.RFC3339Nano),
+				pair.LastRun.Outcome,
+				pair.LastRun.Error,
+			}
+		}
+
+		record := []string{
+			pair.SrcAccount, pair.SrcCalendar, pair.DstAccount, pair.DstCalendar,
+			strconv.Itoa(pair.Records), strconv.Itoa(pair.SoftDeleted), strconv.Itoa(pair.Failures), strconv.Itoa(pair.PendingRetries),
+		}
+		record = append(record, run...)
+		records = append(records, append(record, pair.SrcToken, pair.DstToken))
+	}
+	return records
+}
+
+func tokenCell(health string) string {
+	if health == "" {
+		return "-"
+	}
+	if runes := []rune(health); len(runes) > maxTableError {
+		return string(runes[:maxTableError]) + "..."
+	}
+	return health
+}
+
+func New(calendarManager *calendar.Manager) subcommands.Command {
+	return &status{
+		calendarManager: calendarManager,
+	}
+}
+
+func (*status) Name() string {
+	return "status"
+}
+
+func (*status) Synopsis() string {
+	return "Summarise the synced calendar pairs, their last run and the health of their tokens"
+}
+
+func (*status) Usage() string {
+	return "calendar status\n"
+}
+
+func (p *status) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&p.output, "output", output.Table, output.Usage)
+}
+
+func (p *status) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	if err := output.Validate(p.output); err != nil {
+		fmt.Println(err)
+		return subcommands.ExitUsageError
+	}
+
+	pairs, err := p.calendarManager.Status(ctx)
+	if err != nil {
+		fmt.Println(err)
+		return subcommands.ExitFailure
+	}
+
+	result := make(statusList, 0, len(pairs))
+	for _, pair := range pairs {
+		status := pairStatus{
+			SrcAccount:     pair.SrcAccountEmail,
+			SrcCalendar:    pair.SrcCalendarID,
+			DstAccount:     pair.DstAccountEmail,
+			DstCalendar:    pair.DstCalendarID,
+			Records:        pair.Records,
+			SoftDeleted:    pair.SoftDeleted,
+			Failures:       pair.Failures,
+			PendingRetries: pair.PendingRetries,
+			SrcToken:       pair.SrcToken,
+			DstToken:       pair.DstToken,
+		}
+		if run := pair.LastRun; run != nil {
+			status.LastRun = &lastRun{
+				StartedAt:  run.StartedAt,
+				FinishedAt: run.FinishedAt,
+				Outcome:    run.Outcome,
+				Error:      run.Error,
+			}
+		}
+		result = append(result, status)
+	}
+
+	if err := output.Print(os.Stdout, p.output, result); err != nil {
+		fmt.Println(err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
@@ -0,0 +1,80 @@
+package status
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/robertdolca/calendar-sync/commands/output"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func testStatusList() statusList {
+	started := time.Date(2024, 3, 10, 9, 0, 0, 0, time.UTC)
+	return statusList{
+		{
+			SrcAccount:     "src@example.com",
+			SrcCalendar:    "primary",
+			DstAccount:     "dst@example.com",
+			DstCalendar:    "work",
+			Records:        12,
+			SoftDeleted:    1,
+			Failures:       2,
+			PendingRetries: 1,
+			LastRun: &lastRun{
+				StartedAt:  started,
+				FinishedAt: started.Add(3 * time.Second),
+				Outcome:    "failed",
+				Error:      "googleapi: Error 403: Forbidden, \"quota\"",
+			},
+			SrcToken: "ok",
+			DstToken: "oauth2: token expired and refresh token is not set",
+		},
+		{
+			SrcAccount:  "",
+			SrcCalendar: "feed.example.com/8b1a9953c4611296",
+			DstAccount:  "dst@example.com",
+			DstCalendar: "feed",
+			SrcToken:    "",
+			DstToken:    "ok",
+		},
+	}
+}
+
+func TestPrintGolden(t *testing.T) {
+	for _, format := range []string{output.JSON, output.CSV, output.YAML} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := output.Print(&buf, format, testStatusList()); err != nil {
+				t.Fatal(err)
+			}
+
+			golden := filepath.Join("testdata", "status."+format)
+			if *update {
+				if err := os.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), want) {
+				t.Errorf("%s output:\n%s\nwant:\n%s", format, buf.Bytes(), want)
+			}
+		})
+	}
+}
+
+func TestRecordsMatchFields(t *testing.T) {
+	l := testStatusList()
+	for _, record := range l.Records() {
+		if len(record) != len(l.Fields()) {
+			t.Errorf("record has %d values for %d fields: %q", len(record), len(l.Fields()), record)
+		}
+	}
+}
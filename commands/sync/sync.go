@@ -4,22 +4,37 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/google/subcommands"
+	"github.com/jedib0t/go-pretty/v6/table"
 	"github.com/pkg/errors"
 
 	"github.com/robertdolca/calendar-sync/clients/calendar"
 	"github.com/robertdolca/calendar-sync/clients/calendar/sync"
+	"github.com/robertdolca/calendar-sync/clients/metrics"
+	"github.com/robertdolca/calendar-sync/commands/output"
 )
 
+const shutdownTimeout = 5 * time.Second
+
 type syncCmd struct {
 	sync                *calendar.Manager
 	srcAccountEmail     string
 	srcCalendarID       string
+	srcICS              string
+	srcICSURL           string
 	dstAccountEmail     string
 	dstCalendarID       string
+	dstICS              string
 	copyDescription     bool
 	copyLocation        bool
 	copyColor           bool
@@ -32,6 +47,10 @@ type syncCmd struct {
 	excludeTitleRegex   string
 	updateInterval      time.Duration
 	startAfter          string
+	output              string
+	interval            time.Duration
+	listen              string
+	restart             bool
 }
 
 func New(syncManager *calendar.Manager) subcommands.Command {
@@ -53,10 +72,13 @@ func (*syncCmd) Usage() string {
 }
 
 func (p *syncCmd) SetFlags(f *flag.FlagSet) {
-	f.StringVar(&p.srcAccountEmail, "src-account", "", "Source account email address (required)")
-	f.StringVar(&p.srcCalendarID, "src-calendar", "", "Source calendar id (required)")
-	f.StringVar(&p.dstAccountEmail, "dst-account", "", "Destination account email address (required)")
-	f.StringVar(&p.dstCalendarID, "dst-calendar", "", "Destination calendar id (required)")
+	f.StringVar(&p.srcAccountEmail, "src-account", "", "Source account email address (required unless the source calendar is an alias)")
+	f.StringVar(&p.srcCalendarID, "src-calendar", "", "Source calendar id, name or alias (required)")
+	f.StringVar(&p.srcICS, "src-ics", "", "Use an iCalendar (.ics) file as source instead of a source account and calendar")
+	f.StringVar(&p.srcICSURL, "src-ics-url", "", "Use an iCalendar feed URL as source instead of a source account and calendar")
+	f.StringVar(&p.dstAccountEmail, "dst-account", "", "Destination account email address (required unless the destination calendar is an alias)")
+	f.StringVar(&p.dstCalendarID, "dst-calendar", "", "Destination calendar id, name or alias (required)")
+	f.StringVar(&p.dstICS, "dst-ics", "", "Write the events to an iCalendar (.ics) file instead of a destination account and calendar")
 	f.StringVar(&p.titleOverride, "title-override", "", "Is specified the title of all events will be replaced by this (optional)")
 	f.StringVar(&p.visibility, "visibility", "default", "Event visibility (options: default / public / private)")
 	f.StringVar(&p.excludeTitleRegex, "exclude-title-regex", "", "Regular expression to exclude events when the title matches (optional)")
@@ -68,9 +90,14 @@ func (p *syncCmd) SetFlags(f *flag.FlagSet) {
 	f.BoolVar(&p.includeNotGoing, "include-not-going", false, "Copy events RSVP'ed as No (default: false)")
 	f.BoolVar(&p.includeNotResponded, "include-not-responded", false, "Copy events without RSVP response (default: false)")
 	f.BoolVar(&p.includeOutOfOffice, "include-out-of-office", false, "Copy out of office events (default: false)")
+	f.BoolVar(&p.restart, "restart", false, "Start from scratch instead of resuming an interrupted run (default: false)")
 
 	f.DurationVar(&p.updateInterval, "update-interval", 0, "Only list events updated with the specified time window (eg. 3h)")
 	f.StringVar(&p.startAfter, "start-after", "", "Only copy events that start after the specified date and time (eg. 2006-01-02T15:04:05Z07:00)")
+	f.StringVar(&p.output, "output", output.Table, output.Usage)
+
+	f.DurationVar(&p.interval, "interval", 0, "Keep running and sync again after this interval (eg. 15m, optional)")
+	f.StringVar(&p.listen, "listen", "", "Address to serve /metrics on while running (eg. :9090, optional)")
 }
 
 func (p *syncCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
@@ -99,11 +126,51 @@ func (p *syncCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}
 		}
 	}
 
+	var srcICSPath string
+	if p.srcICS != "" {
+		var err error
+		srcICSPath, err = filepath.Abs(p.srcICS)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("invalid source calendar file: %s", err))
+			return subcommands.ExitUsageError
+		}
+	}
+
+	var dstICSPath string
+	if p.dstICS != "" {
+		var err error
+		dstICSPath, err = filepath.Abs(p.dstICS)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("invalid destination calendar file: %s", err))
+			return subcommands.ExitUsageError
+		}
+	}
+
+	srcAccountEmail, srcCalendarID := p.srcAccountEmail, p.srcCalendarID
+	if srcICSPath == "" && p.srcICSURL == "" {
+		var err error
+		srcAccountEmail, srcCalendarID, err = p.sync.ResolveCalendar(ctx, p.srcAccountEmail, p.srcCalendarID)
+		if err != nil {
+			fmt.Println(errors.Wrap(err, "invalid source calendar"))
+			return subcommands.ExitUsageError
+		}
+	}
+
+	dstAccountEmail, dstCalendarID := p.dstAccountEmail, p.dstCalendarID
+	if dstICSPath == "" {
+		var err error
+		dstAccountEmail, dstCalendarID, err = p.sync.ResolveCalendar(ctx, p.dstAccountEmail, p.dstCalendarID)
+		if err != nil {
+			fmt.Println(errors.Wrap(err, "invalid destination calendar"))
+			return subcommands.ExitUsageError
+		}
+	}
+
 	request := sync.Request{
-		SrcAccountEmail:     p.srcAccountEmail,
-		SrcCalendarID:       p.srcCalendarID,
-		DstAccountEmail:     p.dstAccountEmail,
-		DstCalendarID:       p.dstCalendarID,
+		SrcAccountEmail:     srcAccountEmail,
+		SrcCalendarID:       srcCalendarID,
+		DstAccountEmail:     dstAccountEmail,
+		DstCalendarID:       dstCalendarID,
 		UpdateInterval:      p.updateInterval,
 		IncludeTentative:    p.includeTentative,
 		IncludeNotGoing:     p.includeNotGoing,
@@ -111,6 +178,10 @@ func (p *syncCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}
 		ExcludeTitleRegex:   excludeTitleRegex,
 		IncludeOutOfOffice:  p.includeOutOfOffice,
 		StartAfter:          startAfter,
+		Restart:             p.restart,
+		SrcICSPath:          srcICSPath,
+		SrcICSURL:           p.srcICSURL,
+		DstICSPath:          dstICSPath,
 		MappingOptions: sync.MappingOptions{
 			CopyDescription: p.copyDescription,
 			CopyLocation:    p.copyLocation,
@@ -120,14 +191,141 @@ func (p *syncCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}
 		},
 	}
 
-	if err := p.sync.Sync(ctx, request); err != nil {
+	if p.listen != "" {
+		stop, err := p.serve(dstICSPath)
+		if err != nil {
+			fmt.Println(err)
+			return subcommands.ExitFailure
+		}
+		defer stop()
+	}
+
+	if p.interval == 0 {
+		return p.runOnce(ctx, request)
+	}
+
+	for {
+		p.runOnce(ctx, request)
+		// only the first run ignores the checkpoint
+		request.Restart = false
+
+		select {
+		case <-ctx.Done():
+			return subcommands.ExitSuccess
+		case <-time.After(p.interval):
+		}
+	}
+}
+
+func (p *syncCmd) runOnce(ctx context.Context, request sync.Request) subcommands.ExitStatus {
+	report, syncErr := p.sync.Sync(ctx, request)
+	if err := p.printReport(report); err != nil {
 		fmt.Println(err)
+	}
+	if syncErr != nil {
+		fmt.Println(syncErr)
 		return subcommands.ExitFailure
 	}
-
 	return subcommands.ExitSuccess
 }
 
+// serve starts the HTTP server used to monitor a long running sync, which
+// also publishes the destination calendar file if there is one. The returned
+// function shuts the server down.
+func (p *syncCmd) serve(dstICSPath string) (func(), error) {
+	if err := p.sync.RegisterMetrics(); err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	if dstICSPath != "" {
+		mux.HandleFunc("/"+filepath.Base(dstICSPath), func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+			http.ServeFile(w, r, dstICSPath)
+		})
+	}
+
+	listener, err := net.Listen("tcp", p.listen)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to listen on %s", p.listen)
+	}
+
+	server := &http.Server{
+		Handler: mux,
+	}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			slog.Error("http server failed", "error", err)
+		}
+	}()
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			slog.Error("failed to shut down http server", "error", err)
+		}
+	}, nil
+}
+
+func (p *syncCmd) printReport(report sync.Report) error {
+	return output.Print(os.Stdout, p.output, runReport{report})
+}
+
+// runReport prints the report as a table of event counts by result.
+type runReport struct {
+	sync.Report
+}
+
+func (runReport) Header() table.Row {
+	return table.Row{"Result", "Events"}
+}
+
+func (r runReport) Rows() []table.Row {
+	rows := []table.Row{
+		{"Created", r.Created},
+		{"Updated", r.Updated},
+		{"Unchanged", r.Unchanged},
+		{"Deleted", r.Deleted},
+		{"Skipped", r.SkippedTotal()},
+	}
+
+	reasons := make([]string, 0, len(r.Skipped))
+	for reason := range r.Skipped {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		rows = append(rows, table.Row{"  " + reason, r.Skipped[reason]})
+	}
+
+	return append(rows,
+		table.Row{"Failed", r.Failed},
+		nil,
+		table.Row{"API calls", r.APICalls},
+		table.Row{"Duration", r.Duration.Round(time.Millisecond)},
+	)
+}
+
+func (runReport) Fields() []string {
+	fields := []string{"created", "updated", "unchanged", "deleted"}
+	for _, reason := range sync.SkipReasons {
+		fields = append(fields, "skipped."+reason)
+	}
+	return append(fields, "failed", "apiCalls")
+}
+
+func (r runReport) Records() [][]string {
+	record := []string{strconv.Itoa(r.Created), strconv.Itoa(r.Updated), strconv.Itoa(r.Unchanged), strconv.Itoa(r.Deleted)}
+	for _, reason := range sync.SkipReasons {
+		record = append(record, strconv.Itoa(r.Skipped[reason]))
+	}
+	record = append(record, strconv.Itoa(r.Failed), strconv.Itoa(r.APICalls))
+	return [][]string{record}
+}
+
 func validateVisibility(visibility string) error {
 	if visibility == "public" || visibility == "private" || visibility == "default" {
 		return nil
@@ -136,20 +334,35 @@ func validateVisibility(visibility string) error {
 }
 
 func (p *syncCmd) validateInput() error {
-	if p.srcAccountEmail == "" {
-		return errors.New("source account email not specified")
-	}
-	if p.srcCalendarID == "" {
-		return errors.New("source calendar id not specified")
+	if p.srcICS != "" && p.srcICSURL != "" {
+		return errors.New("only one of source calendar file and url can be specified")
 	}
-	if p.dstAccountEmail == "" {
-		return errors.New("destination account email not specified")
+	if p.srcICS != "" || p.srcICSURL != "" {
+		if p.srcAccountEmail != "" || p.srcCalendarID != "" {
+			return errors.New("source account and calendar can't be used with a source calendar file or url")
+		}
+	} else {
+		if p.srcCalendarID == "" {
+			return errors.New("source calendar not specified")
+		}
 	}
-	if p.dstCalendarID == "" {
-		return errors.New("destination calendar id not specified")
+	if p.dstICS != "" {
+		if p.dstAccountEmail != "" || p.dstCalendarID != "" {
+			return errors.New("destination account and calendar can't be used with a destination calendar file")
+		}
+	} else {
+		if p.dstCalendarID == "" {
+			return errors.New("destination calendar not specified")
+		}
 	}
 	if err := validateVisibility(p.visibility); err != nil {
 		return err
 	}
+	if err := output.Validate(p.output); err != nil {
+		return err
+	}
+	if p.interval < 0 {
+		return errors.New("interval must be positive")
+	}
 	return nil
 }
@@ -4,23 +4,74 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/google/subcommands"
 	"github.com/pkg/errors"
 	"golang.org/x/net/context"
 
 	"github.com/robertdolca/calendar-sync/clients/calendar"
+	"github.com/robertdolca/calendar-sync/clients/config"
+	"github.com/robertdolca/calendar-sync/clients/logging"
 	"github.com/robertdolca/calendar-sync/clients/syncdb"
 	"github.com/robertdolca/calendar-sync/clients/tmanager"
-	"github.com/robertdolca/calendar-sync/clients/userinfo"
+	"github.com/robertdolca/calendar-sync/clients/tracing"
+	"github.com/robertdolca/calendar-sync/commands/accounts"
 	"github.com/robertdolca/calendar-sync/commands/auth"
 	"github.com/robertdolca/calendar-sync/commands/clear"
+	"github.com/robertdolca/calendar-sync/commands/failures"
 	"github.com/robertdolca/calendar-sync/commands/list"
+	"github.com/robertdolca/calendar-sync/commands/status"
 	synccmd "github.com/robertdolca/calendar-sync/commands/sync"
 )
 
 func run() subcommands.ExitStatus {
-	tm, err := tmanager.New()
+	var logOptions logging.Options
+	flag.StringVar(&logOptions.Level, "log-level", "info", "Log level (options: debug / info / warn / error)")
+	flag.StringVar(&logOptions.Format, "log-format", logging.FormatText, "Log format (options: text / json)")
+	flag.StringVar(&logOptions.File, "log-file", "", "Write logs to this file instead of stderr (optional)")
+	flag.IntVar(&logOptions.MaxSizeMB, "log-max-size", 10, "Size in megabytes after which the log file is rotated")
+	flag.IntVar(&logOptions.MaxBackups, "log-max-backups", 5, "Number of rotated log files to keep (0 keeps all)")
+	flag.IntVar(&logOptions.MaxAgeDays, "log-max-age", 0, "Days to keep rotated log files for (0 keeps them forever)")
+
+	var traceOptions tracing.Options
+	flag.StringVar(&traceOptions.Exporter, "trace-exporter", tracing.ExporterNone, "Trace exporter (options: none / otlp / file)")
+	flag.StringVar(&traceOptions.Endpoint, "trace-endpoint", "", "OTLP HTTP collector address (default: localhost:4318)")
+	flag.BoolVar(&traceOptions.Insecure, "trace-insecure", false, "Connect to the OTLP collector without TLS")
+	flag.StringVar(&traceOptions.File, "trace-file", "", "File the spans are written to when using the file exporter")
+
+	var tokenOptions tmanager.Options
+	flag.StringVar(&tokenOptions.Store, "token-store", tmanager.StoreAuto, "Where account tokens are stored (options: auto / file / encrypted / helper)")
+	flag.StringVar(&tokenOptions.PassphraseFile, "passphrase-file", "", "File with the passphrase of the encrypted token store, used when "+tmanager.PassphraseEnv+" is not set")
+	flag.StringVar(&tokenOptions.Helper, "credential-helper", "", "Command that stores the tokens and credentials instead of files in the work directory (optional)")
+
+	configPath := flag.String("config", config.DefaultPath, "Config file with the calendar aliases")
+	flag.Parse()
+
+	logCloser, err := logging.Setup(logOptions)
+	if err != nil {
+		fmt.Println(errors.Wrap(err, "failed to set up logging"))
+		return subcommands.ExitUsageError
+	}
+	defer func() {
+		if err := logCloser.Close(); err != nil {
+			fmt.Println(errors.Wrap(err, "failed to close log file"))
+		}
+	}()
+
+	shutdownTracing, err := tracing.Setup(context.Background(), traceOptions)
+	if err != nil {
+		fmt.Println(errors.Wrap(err, "failed to set up tracing"))
+		return subcommands.ExitUsageError
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			fmt.Println(errors.Wrap(err, "failed to shut down tracing"))
+		}
+	}()
+
+	tm, err := tmanager.New(tokenOptions)
 	if err != nil {
 		fmt.Println(errors.Wrap(err, "failed to create token manager"))
 		return subcommands.ExitFailure
@@ -38,17 +89,27 @@ func run() subcommands.ExitStatus {
 		}
 	}()
 
-	ui := userinfo.New(tm)
-	cm := calendar.New(tm, ui, sdb)
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Println(err)
+		return subcommands.ExitUsageError
+	}
+
+	cm := calendar.New(tm, sdb, cfg.Aliases)
 
 	subcommands.Register(subcommands.HelpCommand(), "")
 	subcommands.Register(auth.New(tm), "")
+	subcommands.Register(accounts.New(tm), "")
 	subcommands.Register(list.New(cm), "")
 	subcommands.Register(synccmd.New(cm), "")
 	subcommands.Register(clear.New(cm), "")
-	flag.Parse()
+	subcommands.Register(failures.New(cm), "")
+	subcommands.Register(status.New(cm), "")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	return subcommands.Execute(context.Background())
+	return subcommands.Execute(ctx)
 }
 
 func main() {